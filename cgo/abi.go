@@ -0,0 +1,641 @@
+package main
+
+import (
+	"github.com/Pie-Messaging/core/pie"
+	"unsafe"
+)
+
+/*
+#define PIE_CORE_INTERNAL
+#include "pie_core.h"
+*/
+import "C"
+
+// The pie_* exports form the stable ABI declared in pie_core.h and wrap the Go-typed exports.
+
+// Fails to build when the codes in pie_core.h and errors.go disagree, since a negative constant overflows uint
+const (
+	_ = uint(ENo-C.PIE_OK) + uint(C.PIE_OK-ENo)
+	_ = uint(EInvalidHandle-C.PIE_E_INVALID_HANDLE) + uint(C.PIE_E_INVALID_HANDLE-EInvalidHandle)
+	_ = uint(EInvalidArg-C.PIE_E_INVALID_ARG) + uint(C.PIE_E_INVALID_ARG-EInvalidArg)
+	_ = uint(EventTrackerRemoved-C.PIE_EVENT_TRACKER_REMOVED) + uint(C.PIE_EVENT_TRACKER_REMOVED-EventTrackerRemoved)
+)
+
+//export pie_abi_version
+func pie_abi_version() C.int {
+	return C.PIE_CORE_ABI_VERSION
+}
+
+//export pie_get_last_error
+func pie_get_last_error(handle C.pie_handle_t, buf *C.char, bufCap C.size_t, length *C.size_t) (errCode C.int) {
+	defer recoverCPanic(&errCode)
+	n := GetLastError(C.uintptr_t(handle), goBytes((*C.uint8_t)(unsafe.Pointer(buf)), bufCap))
+	setSize(length, n)
+	if n > int(bufCap) {
+		return C.int(EMsgTooLong)
+	}
+	return C.int(ENo)
+}
+
+//export pie_set_log_output
+func pie_set_log_output(path *C.char, pathLen C.size_t) (errCode C.int) {
+	defer recoverCPanic(&errCode)
+	SetLogOutput(goString(path, pathLen))
+	return C.int(ENo)
+}
+
+//export pie_hash_bytes
+func pie_hash_bytes(data *C.uint8_t, dataLen C.size_t, result *C.uint8_t, resultLen C.size_t) (errCode C.int) {
+	defer recoverCPanic(&errCode)
+	HashBytes(goBytes(data, dataLen), goBytes(result, resultLen))
+	return C.int(ENo)
+}
+
+//export pie_context_new
+func pie_context_new(ctx *C.pie_context_t) (errCode C.int) {
+	defer recoverCPanic(&errCode)
+	if ctx == nil {
+		return C.int(EInvalidArg)
+	}
+	*ctx = C.pie_context_t(NewContext())
+	return C.int(ENo)
+}
+
+//export pie_context_cancel
+func pie_context_cancel(ctx C.pie_context_t) (errCode C.int) {
+	defer recoverCPanic(&errCode)
+	return C.int(CancelContext(C.uintptr_t(ctx)))
+}
+
+//export pie_config_load
+func pie_config_load(path *C.char, pathLen C.size_t, config *C.pie_config_t) (errCode C.int) {
+	defer recoverCPanic(&errCode)
+	if config == nil {
+		return C.int(EInvalidArg)
+	}
+	configPtr, errType := LoadConfig(goString(path, pathLen))
+	*config = C.pie_config_t(configPtr)
+	return C.int(errType)
+}
+
+//export pie_config_parse
+func pie_config_parse(data *C.uint8_t, dataLen C.size_t, format *C.char, formatLen C.size_t, config *C.pie_config_t) (errCode C.int) {
+	defer recoverCPanic(&errCode)
+	if config == nil {
+		return C.int(EInvalidArg)
+	}
+	configPtr, errType := ParseConfig(goBytes(data, dataLen), goString(format, formatLen))
+	*config = C.pie_config_t(configPtr)
+	return C.int(errType)
+}
+
+//export pie_config_delete
+func pie_config_delete(config C.pie_config_t) (errCode C.int) {
+	defer recoverCPanic(&errCode)
+	return C.int(DeleteConfig(C.uintptr_t(config)))
+}
+
+//export pie_set_resumption_file
+func pie_set_resumption_file(path *C.char, pathLen C.size_t) (errCode C.int) {
+	defer recoverCPanic(&errCode)
+	SetResumptionFile(goString(path, pathLen))
+	return C.int(ENo)
+}
+
+//export pie_cert_load
+func pie_cert_load(certPEM *C.uint8_t, certPEMLen C.size_t, keyPEM *C.uint8_t, keyPEMLen C.size_t,
+	certDER *C.uint8_t, certDERCap C.size_t, certDERLen *C.size_t, cert *C.pie_cert_t) (errCode C.int) {
+	defer recoverCPanic(&errCode)
+	if cert == nil {
+		return C.int(EInvalidArg)
+	}
+	certificate, err := pie.X509KeyPair(goBytes(certPEM, certPEMLen), goBytes(keyPEM, keyPEMLen))
+	if err != nil {
+		return C.int(fail(0, err))
+	}
+	if e := writeResult(certificate.Certificate[0], goBytes(certDER, certDERCap), certDERLen); e != ENo {
+		return C.int(e)
+	}
+	*cert = C.pie_cert_t(newHandle(certificate))
+	return C.int(ENo)
+}
+
+//export pie_cert_generate
+func pie_cert_generate(certPEM *C.uint8_t, certPEMCap C.size_t, certPEMLen *C.size_t, keyPEM *C.uint8_t, keyPEMCap C.size_t, keyPEMLen *C.size_t,
+	certDER *C.uint8_t, certDERCap C.size_t, certDERLen *C.size_t, cert *C.pie_cert_t) (errCode C.int) {
+	defer recoverCPanic(&errCode)
+	if cert == nil {
+		return C.int(EInvalidArg)
+	}
+	certificate, certPEMData, keyPEMData, err := pie.GenerateKeyPair()
+	if err != nil {
+		return C.int(fail(0, err))
+	}
+	// The handle is only created once every result fit, so a PIE_E_MSG_TOO_LONG leaves nothing to delete
+	e1 := writeResult(certPEMData, goBytes(certPEM, certPEMCap), certPEMLen)
+	e2 := writeResult(keyPEMData, goBytes(keyPEM, keyPEMCap), keyPEMLen)
+	e3 := writeResult(certificate.Certificate[0], goBytes(certDER, certDERCap), certDERLen)
+	if e1 != ENo || e2 != ENo || e3 != ENo {
+		return C.int(EMsgTooLong)
+	}
+	*cert = C.pie_cert_t(newHandle(certificate))
+	return C.int(ENo)
+}
+
+//export pie_identity_generate
+func pie_identity_generate(path *C.char, pathLen C.size_t, passphrase *C.uint8_t, passphraseLen C.size_t, overwrite C.int,
+	certDER *C.uint8_t, certDERCap C.size_t, certDERLen *C.size_t, cert *C.pie_cert_t) (errCode C.int) {
+	defer recoverCPanic(&errCode)
+	if cert == nil {
+		return C.int(EInvalidArg)
+	}
+	certPtr, n, errType := IdentityGenerate(goString(path, pathLen), goBytes(passphrase, passphraseLen), overwrite != 0,
+		goBytes(certDER, certDERCap))
+	return identityResult(certPtr, n, errType, certDERCap, certDERLen, cert)
+}
+
+//export pie_identity_load
+func pie_identity_load(path *C.char, pathLen C.size_t, passphrase *C.uint8_t, passphraseLen C.size_t,
+	certDER *C.uint8_t, certDERCap C.size_t, certDERLen *C.size_t, cert *C.pie_cert_t) (errCode C.int) {
+	defer recoverCPanic(&errCode)
+	if cert == nil {
+		return C.int(EInvalidArg)
+	}
+	certPtr, n, errType := IdentityLoad(goString(path, pathLen), goBytes(passphrase, passphraseLen), goBytes(certDER, certDERCap))
+	return identityResult(certPtr, n, errType, certDERCap, certDERLen, cert)
+}
+
+//export pie_identity_change_passphrase
+func pie_identity_change_passphrase(path *C.char, pathLen C.size_t, oldPassphrase *C.uint8_t, oldPassphraseLen C.size_t,
+	newPassphrase *C.uint8_t, newPassphraseLen C.size_t) (errCode C.int) {
+	defer recoverCPanic(&errCode)
+	return C.int(IdentityChangePassphrase(goString(path, pathLen), goBytes(oldPassphrase, oldPassphraseLen), goBytes(newPassphrase, newPassphraseLen)))
+}
+
+//export pie_link_device
+func pie_link_device(userCert C.pie_cert_t, deviceCertDER *C.uint8_t, deviceCertDERLen C.size_t, name *C.char, nameLen C.size_t,
+	addrJSON *C.uint8_t, addrJSONLen C.size_t, result *C.uint8_t, resultCap C.size_t, resultLen *C.size_t) (errCode C.int) {
+	defer recoverCPanic(&errCode)
+	n, errType := LinkDevice(C.uintptr_t(userCert), goBytes(deviceCertDER, deviceCertDERLen), goString(name, nameLen), goBytes(addrJSON, addrJSONLen), goBytes(result, resultCap))
+	setSize(resultLen, n)
+	return C.int(errType)
+}
+
+//export pie_send_to_devices
+func pie_send_to_devices(ctx C.pie_context_t, clientID *C.uint8_t, clientIDLen C.size_t, clientCert C.pie_cert_t,
+	user *C.uint8_t, userLen C.size_t, request *C.uint8_t, requestLen C.size_t, config C.pie_config_t, timeout C.int64_t, delivered *C.int) (errCode C.int) {
+	defer recoverCPanic(&errCode)
+	if delivered == nil {
+		return C.int(EInvalidArg)
+	}
+	n, errType := SendToDevices(C.uintptr_t(ctx), goBytes(clientID, clientIDLen), C.uintptr_t(clientCert), goBytes(user, userLen),
+		goBytes(request, requestLen), C.uintptr_t(config), int64(timeout))
+	*delivered = C.int(n)
+	return C.int(errType)
+}
+
+//export pie_send_to_devices_as_device
+func pie_send_to_devices_as_device(ctx C.pie_context_t, clientID *C.uint8_t, clientIDLen C.size_t, deviceCert C.pie_cert_t,
+	userCertDER *C.uint8_t, userCertDERLen C.size_t, device *C.uint8_t, deviceLen C.size_t, user *C.uint8_t, userLen C.size_t,
+	request *C.uint8_t, requestLen C.size_t, config C.pie_config_t, timeout C.int64_t, delivered *C.int) (errCode C.int) {
+	defer recoverCPanic(&errCode)
+	if delivered == nil {
+		return C.int(EInvalidArg)
+	}
+	n, errType := SendToDevicesAsDevice(C.uintptr_t(ctx), goBytes(clientID, clientIDLen), C.uintptr_t(deviceCert), goBytes(userCertDER, userCertDERLen),
+		goBytes(device, deviceLen), goBytes(user, userLen), goBytes(request, requestLen), C.uintptr_t(config), int64(timeout))
+	*delivered = C.int(n)
+	return C.int(errType)
+}
+
+//export pie_revocation_new
+func pie_revocation_new(cert C.pie_cert_t, newCertDER *C.uint8_t, newCertDERLen C.size_t, result *C.uint8_t, resultCap C.size_t, resultLen *C.size_t) (errCode C.int) {
+	defer recoverCPanic(&errCode)
+	n, errType := NewRevocation(C.uintptr_t(cert), goBytes(newCertDER, newCertDERLen), goBytes(result, resultCap))
+	setSize(resultLen, n)
+	return C.int(errType)
+}
+
+//export pie_revocation_add
+func pie_revocation_add(revocation *C.uint8_t, revocationLen C.size_t) (errCode C.int) {
+	defer recoverCPanic(&errCode)
+	return C.int(AddRevocation(goBytes(revocation, revocationLen)))
+}
+
+//export pie_cert_delete
+func pie_cert_delete(cert C.pie_cert_t) (errCode C.int) {
+	defer recoverCPanic(&errCode)
+	return C.int(DeleteCert(C.uintptr_t(cert)))
+}
+
+//export pie_listen_net
+func pie_listen_net(addr *C.char, addrLen C.size_t, cert C.pie_cert_t, config C.pie_config_t, server *C.pie_server_t, port *C.int) (errCode C.int) {
+	defer recoverCPanic(&errCode)
+	if server == nil || port == nil {
+		return C.int(EInvalidArg)
+	}
+	serverPtr, p, errType := ListenNet(goString(addr, addrLen), C.uintptr_t(cert), C.uintptr_t(config))
+	*server = C.pie_server_t(serverPtr)
+	*port = C.int(p)
+	return C.int(errType)
+}
+
+//export pie_server_accept_session
+func pie_server_accept_session(ctx C.pie_context_t, server C.pie_server_t, addr *C.char, addrCap C.size_t, addrLen *C.size_t, session *C.pie_session_t) (errCode C.int) {
+	defer recoverCPanic(&errCode)
+	if session == nil {
+		return C.int(EInvalidArg)
+	}
+	sessionPtr, n, errType := AcceptSession(C.uintptr_t(ctx), C.uintptr_t(server), goBytes((*C.uint8_t)(unsafe.Pointer(addr)), addrCap))
+	*session = C.pie_session_t(sessionPtr)
+	setSize(addrLen, n)
+	return C.int(errType)
+}
+
+//export pie_server_verify_client_cert
+func pie_server_verify_client_cert(server C.pie_server_t, clientCertDER *C.uint8_t, clientCertDERLen C.size_t, serverCertSign *C.uint8_t, serverCertSignLen C.size_t) (errCode C.int) {
+	defer recoverCPanic(&errCode)
+	return C.int(VerifyClientCert(C.uintptr_t(server), goBytes(clientCertDER, clientCertDERLen), goBytes(serverCertSign, serverCertSignLen)))
+}
+
+//export pie_server_verify_device_cert
+func pie_server_verify_device_cert(server C.pie_server_t, clientCertDER *C.uint8_t, clientCertDERLen C.size_t,
+	serverCertSign *C.uint8_t, serverCertSignLen C.size_t, device *C.uint8_t, deviceLen C.size_t) (errCode C.int) {
+	defer recoverCPanic(&errCode)
+	return C.int(VerifyDeviceCert(C.uintptr_t(server), goBytes(clientCertDER, clientCertDERLen), goBytes(serverCertSign, serverCertSignLen), goBytes(device, deviceLen)))
+}
+
+//export pie_server_close
+func pie_server_close(server C.pie_server_t) (errCode C.int) {
+	defer recoverCPanic(&errCode)
+	return C.int(CloseServer(C.uintptr_t(server)))
+}
+
+//export pie_connect_server
+func pie_connect_server(ctx C.pie_context_t, clientID *C.uint8_t, clientIDLen C.size_t, clientCert C.pie_cert_t, addr *C.char, addrLen C.size_t,
+	serverCertDER *C.uint8_t, serverCertDERLen C.size_t, config C.pie_config_t, session *C.pie_session_t) (errCode C.int) {
+	defer recoverCPanic(&errCode)
+	if session == nil {
+		return C.int(EInvalidArg)
+	}
+	sessionPtr, errType := ConnectServer(C.uintptr_t(ctx), goBytes(clientID, clientIDLen), C.uintptr_t(clientCert), goString(addr, addrLen),
+		goBytes(serverCertDER, serverCertDERLen), C.uintptr_t(config))
+	*session = C.pie_session_t(sessionPtr)
+	return C.int(errType)
+}
+
+//export pie_connect_server_as_device
+func pie_connect_server_as_device(ctx C.pie_context_t, clientID *C.uint8_t, clientIDLen C.size_t, deviceCert C.pie_cert_t,
+	userCertDER *C.uint8_t, userCertDERLen C.size_t, device *C.uint8_t, deviceLen C.size_t, addr *C.char, addrLen C.size_t,
+	serverCertDER *C.uint8_t, serverCertDERLen C.size_t, config C.pie_config_t, session *C.pie_session_t) (errCode C.int) {
+	defer recoverCPanic(&errCode)
+	if session == nil {
+		return C.int(EInvalidArg)
+	}
+	sessionPtr, errType := ConnectServerAsDevice(C.uintptr_t(ctx), goBytes(clientID, clientIDLen), C.uintptr_t(deviceCert), goBytes(userCertDER, userCertDERLen),
+		goBytes(device, deviceLen), goString(addr, addrLen), goBytes(serverCertDER, serverCertDERLen), C.uintptr_t(config))
+	*session = C.pie_session_t(sessionPtr)
+	return C.int(errType)
+}
+
+//export pie_connect_tracker
+func pie_connect_tracker(ctx C.pie_context_t, addr *C.char, addrLen C.size_t, id *C.uint8_t, idCap C.size_t, config C.pie_config_t, session *C.pie_session_t) (errCode C.int) {
+	defer recoverCPanic(&errCode)
+	if session == nil {
+		return C.int(EInvalidArg)
+	}
+	if int(idCap) < pie.IDLen {
+		return C.int(EMsgTooLong)
+	}
+	sessionPtr, errType := ConnectTracker(C.uintptr_t(ctx), goString(addr, addrLen), goBytes(id, idCap), C.uintptr_t(config))
+	*session = C.pie_session_t(sessionPtr)
+	return C.int(errType)
+}
+
+//export pie_server_connect_tracker
+func pie_server_connect_tracker(ctx C.pie_context_t, server C.pie_server_t, clientID *C.uint8_t, clientIDLen C.size_t, clientCert C.pie_cert_t,
+	addr *C.char, addrLen C.size_t, id *C.uint8_t, idCap C.size_t, session *C.pie_session_t) (errCode C.int) {
+	defer recoverCPanic(&errCode)
+	if session == nil {
+		return C.int(EInvalidArg)
+	}
+	if int(idCap) < pie.IDLen {
+		return C.int(EMsgTooLong)
+	}
+	sessionPtr, errType := ServerConnectTracker(C.uintptr_t(ctx), C.uintptr_t(server), goBytes(clientID, clientIDLen), C.uintptr_t(clientCert),
+		goString(addr, addrLen), goBytes(id, idCap))
+	*session = C.pie_session_t(sessionPtr)
+	return C.int(errType)
+}
+
+//export pie_server_request_punch
+func pie_server_request_punch(ctx C.pie_context_t, server C.pie_server_t, tracker C.pie_session_t, peerID *C.uint8_t, peerIDLen C.size_t,
+	timeout C.int64_t, session *C.pie_session_t) (errCode C.int) {
+	defer recoverCPanic(&errCode)
+	if session == nil {
+		return C.int(EInvalidArg)
+	}
+	sessionPtr, errType := ServerRequestPunch(C.uintptr_t(ctx), C.uintptr_t(server), C.uintptr_t(tracker), goBytes(peerID, peerIDLen), int64(timeout))
+	*session = C.pie_session_t(sessionPtr)
+	return C.int(errType)
+}
+
+//export pie_server_handle_punch_notify
+func pie_server_handle_punch_notify(ctx C.pie_context_t, server C.pie_server_t, notify *C.uint8_t, notifyLen C.size_t) (errCode C.int) {
+	defer recoverCPanic(&errCode)
+	return C.int(ServerHandlePunchNotify(C.uintptr_t(ctx), C.uintptr_t(server), goBytes(notify, notifyLen)))
+}
+
+//export pie_session_accept_stream
+func pie_session_accept_stream(ctx C.pie_context_t, session C.pie_session_t, recvBuf *C.uint8_t, recvBufCap C.size_t, stream *C.pie_stream_t, streamID *C.int64_t) (errCode C.int) {
+	defer recoverCPanic(&errCode)
+	if stream == nil || streamID == nil {
+		return C.int(EInvalidArg)
+	}
+	streamPtr, id, errType := SessionAcceptStream(C.uintptr_t(ctx), C.uintptr_t(session), goBytes(recvBuf, recvBufCap))
+	*stream = C.pie_stream_t(streamPtr)
+	*streamID = C.int64_t(id)
+	return C.int(errType)
+}
+
+//export pie_session_open_stream
+func pie_session_open_stream(session C.pie_session_t, recvBuf *C.uint8_t, recvBufCap C.size_t, stream *C.pie_stream_t, streamID *C.int64_t) (errCode C.int) {
+	defer recoverCPanic(&errCode)
+	if stream == nil || streamID == nil {
+		return C.int(EInvalidArg)
+	}
+	streamPtr, id, errType := SessionOpenStream(C.uintptr_t(session), goBytes(recvBuf, recvBufCap))
+	*stream = C.pie_stream_t(streamPtr)
+	*streamID = C.int64_t(id)
+	return C.int(errType)
+}
+
+//export pie_session_close
+func pie_session_close(session C.pie_session_t, code C.uint64_t) (errCode C.int) {
+	defer recoverCPanic(&errCode)
+	return C.int(CloseSession(C.uintptr_t(session), uint64(code)))
+}
+
+//export pie_stream_recv_data
+func pie_stream_recv_data(stream C.pie_stream_t, start *C.size_t, end *C.size_t) (errCode C.int) {
+	defer recoverCPanic(&errCode)
+	s, e, errType := StreamRecvData(C.uintptr_t(stream))
+	if errType == ENo {
+		setSize(start, s)
+		setSize(end, e)
+	}
+	return C.int(errType)
+}
+
+//export pie_stream_send_data
+func pie_stream_send_data(stream C.pie_stream_t, data *C.uint8_t, dataLen C.size_t, timeout C.int64_t) (errCode C.int) {
+	defer recoverCPanic(&errCode)
+	return C.int(StreamSendData(C.uintptr_t(stream), goBytes(data, dataLen), int64(timeout)))
+}
+
+//export pie_stream_close
+func pie_stream_close(stream C.pie_stream_t) (errCode C.int) {
+	defer recoverCPanic(&errCode)
+	return C.int(CloseStream(C.uintptr_t(stream)))
+}
+
+//export pie_stream_send_message
+func pie_stream_send_message(stream C.pie_stream_t, message *C.uint8_t, messageLen C.size_t, timeout C.int64_t) (errCode C.int) {
+	defer recoverCPanic(&errCode)
+	return C.int(StreamSendMessage(C.uintptr_t(stream), goBytes(message, messageLen), int64(timeout)))
+}
+
+//export pie_stream_recv_message
+func pie_stream_recv_message(stream C.pie_stream_t, timeout C.int64_t, result *C.uint8_t, resultCap C.size_t, resultLen *C.size_t) (errCode C.int) {
+	defer recoverCPanic(&errCode)
+	n, errType := StreamRecvMessage(C.uintptr_t(stream), int64(timeout), goBytes(result, resultCap))
+	setSize(resultLen, n)
+	return C.int(errType)
+}
+
+//export pie_session_request_relay
+func pie_session_request_relay(tracker C.pie_session_t, peerID *C.uint8_t, peerIDLen C.size_t, cert C.pie_cert_t, timeout C.int64_t,
+	stream *C.pie_secure_stream_t) (errCode C.int) {
+	defer recoverCPanic(&errCode)
+	if stream == nil {
+		return C.int(EInvalidArg)
+	}
+	securePtr, errType := SessionRequestRelay(C.uintptr_t(tracker), goBytes(peerID, peerIDLen), C.uintptr_t(cert), int64(timeout))
+	*stream = C.pie_secure_stream_t(securePtr)
+	return C.int(errType)
+}
+
+//export pie_stream_accept_relay
+func pie_stream_accept_relay(stream C.pie_stream_t, req *C.uint8_t, reqLen C.size_t, cert C.pie_cert_t, timeout C.int64_t,
+	secureStream *C.pie_secure_stream_t) (errCode C.int) {
+	defer recoverCPanic(&errCode)
+	if secureStream == nil {
+		return C.int(EInvalidArg)
+	}
+	securePtr, errType := StreamAcceptRelay(C.uintptr_t(stream), goBytes(req, reqLen), C.uintptr_t(cert), int64(timeout))
+	*secureStream = C.pie_secure_stream_t(securePtr)
+	return C.int(errType)
+}
+
+//export pie_secure_stream_send_message
+func pie_secure_stream_send_message(stream C.pie_secure_stream_t, message *C.uint8_t, messageLen C.size_t, timeout C.int64_t) (errCode C.int) {
+	defer recoverCPanic(&errCode)
+	return C.int(SecureStreamSendMessage(C.uintptr_t(stream), goBytes(message, messageLen), int64(timeout)))
+}
+
+//export pie_secure_stream_recv_message
+func pie_secure_stream_recv_message(stream C.pie_secure_stream_t, timeout C.int64_t, result *C.uint8_t, resultCap C.size_t, resultLen *C.size_t) (errCode C.int) {
+	defer recoverCPanic(&errCode)
+	n, errType := SecureStreamRecvMessage(C.uintptr_t(stream), int64(timeout), goBytes(result, resultCap))
+	setSize(resultLen, n)
+	return C.int(errType)
+}
+
+//export pie_secure_stream_close
+func pie_secure_stream_close(stream C.pie_secure_stream_t) (errCode C.int) {
+	defer recoverCPanic(&errCode)
+	return C.int(CloseSecureStream(C.uintptr_t(stream)))
+}
+
+//export pie_session_call
+func pie_session_call(session C.pie_session_t, request *C.uint8_t, requestLen C.size_t, timeout C.int64_t,
+	result *C.uint8_t, resultCap C.size_t, resultLen *C.size_t) (errCode C.int) {
+	defer recoverCPanic(&errCode)
+	n, errType := SessionCall(C.uintptr_t(session), goBytes(request, requestLen), int64(timeout), goBytes(result, resultCap))
+	setSize(resultLen, n)
+	return C.int(errType)
+}
+
+//export pie_event_queue_new
+func pie_event_queue_new(size C.int, queue *C.pie_event_queue_t) (errCode C.int) {
+	defer recoverCPanic(&errCode)
+	if queue == nil {
+		return C.int(EInvalidArg)
+	}
+	*queue = C.pie_event_queue_t(NewEventQueue(int(size)))
+	return C.int(ENo)
+}
+
+//export pie_event_queue_set_callback
+func pie_event_queue_set_callback(queue C.pie_event_queue_t, callback C.pie_event_callback, userData unsafe.Pointer) (errCode C.int) {
+	defer recoverCPanic(&errCode)
+	return C.int(EventQueueSetCallback(C.uintptr_t(queue), callback, userData))
+}
+
+//export pie_event_queue_poll
+func pie_event_queue_poll(queue C.pie_event_queue_t, timeout C.int64_t, data *C.uint8_t, dataCap C.size_t, e *C.pie_event) (errCode C.int) {
+	defer recoverCPanic(&errCode)
+	if e == nil {
+		return C.int(EInvalidArg)
+	}
+	eventType, handle, parent, dataLen, errType := PollEvent(C.uintptr_t(queue), int64(timeout), goBytes(data, dataCap))
+	e._type = C.int(eventType)
+	e.handle = C.pie_handle_t(handle)
+	e.parent = C.pie_handle_t(parent)
+	e.data_len = C.size_t(dataLen)
+	e.err = C.int(errType)
+	return C.int(errType)
+}
+
+//export pie_event_queue_watch_server
+func pie_event_queue_watch_server(queue C.pie_event_queue_t, server C.pie_server_t) (errCode C.int) {
+	defer recoverCPanic(&errCode)
+	return C.int(EventQueueWatchServer(C.uintptr_t(queue), C.uintptr_t(server)))
+}
+
+//export pie_event_queue_watch_session
+func pie_event_queue_watch_session(queue C.pie_event_queue_t, session C.pie_session_t) (errCode C.int) {
+	defer recoverCPanic(&errCode)
+	return C.int(EventQueueWatchSession(C.uintptr_t(queue), C.uintptr_t(session)))
+}
+
+//export pie_event_queue_watch_stream
+func pie_event_queue_watch_stream(queue C.pie_event_queue_t, stream C.pie_stream_t) (errCode C.int) {
+	defer recoverCPanic(&errCode)
+	return C.int(EventQueueWatchStream(C.uintptr_t(queue), C.uintptr_t(stream)))
+}
+
+//export pie_event_queue_watch_table
+func pie_event_queue_watch_table(queue C.pie_event_queue_t, table C.pie_table_t) (errCode C.int) {
+	defer recoverCPanic(&errCode)
+	return C.int(EventQueueWatchTable(C.uintptr_t(queue), C.uintptr_t(table)))
+}
+
+//export pie_event_queue_delete
+func pie_event_queue_delete(queue C.pie_event_queue_t) (errCode C.int) {
+	defer recoverCPanic(&errCode)
+	return C.int(DeleteEventQueue(C.uintptr_t(queue)))
+}
+
+//export pie_table_new
+func pie_table_new(protocol *C.char, protocolLen C.size_t, config C.pie_config_t, table *C.pie_table_t) (errCode C.int) {
+	defer recoverCPanic(&errCode)
+	if table == nil {
+		return C.int(EInvalidArg)
+	}
+	tablePtr, errType := NewTable(goString(protocol, protocolLen), C.uintptr_t(config))
+	*table = C.pie_table_t(tablePtr)
+	return C.int(errType)
+}
+
+//export pie_table_init
+func pie_table_init(ctx C.pie_context_t, table C.pie_table_t, addrJSON *C.uint8_t, addrJSONLen C.size_t) (errCode C.int) {
+	defer recoverCPanic(&errCode)
+	return C.int(TableInit(C.uintptr_t(ctx), C.uintptr_t(table), goBytes(addrJSON, addrJSONLen)))
+}
+
+//export pie_table_serve
+func pie_table_serve(ctx C.pie_context_t, table C.pie_table_t, server C.pie_server_t) (errCode C.int) {
+	defer recoverCPanic(&errCode)
+	return C.int(TableServe(C.uintptr_t(ctx), C.uintptr_t(table), C.uintptr_t(server)))
+}
+
+//export pie_table_find_tracker
+func pie_table_find_tracker(ctx C.pie_context_t, table C.pie_table_t, id *C.uint8_t, idLen C.size_t) (errCode C.int) {
+	defer recoverCPanic(&errCode)
+	return C.int(TableFindTracker(C.uintptr_t(ctx), C.uintptr_t(table), goBytes(id, idLen)))
+}
+
+//export pie_table_get_neighbors
+func pie_table_get_neighbors(table C.pie_table_t, id *C.uint8_t, idLen C.size_t, num C.int, result *C.uint8_t, resultCap C.size_t, resultLen *C.size_t) (errCode C.int) {
+	defer recoverCPanic(&errCode)
+	n, errType := TableGetNeighbors(C.uintptr_t(table), goBytes(id, idLen), int(num), goBytes(result, resultCap))
+	setSize(resultLen, n)
+	return C.int(errType)
+}
+
+//export pie_table_find_resource
+func pie_table_find_resource(ctx C.pie_context_t, table C.pie_table_t, id *C.uint8_t, idLen C.size_t, resourceType C.int32_t,
+	result *C.uint8_t, resultCap C.size_t, resultLen *C.size_t) (errCode C.int) {
+	defer recoverCPanic(&errCode)
+	n, errType := TableFindResource(C.uintptr_t(ctx), C.uintptr_t(table), goBytes(id, idLen), int32(resourceType), goBytes(result, resultCap))
+	setSize(resultLen, n)
+	return C.int(errType)
+}
+
+//export pie_table_put_resource
+func pie_table_put_resource(ctx C.pie_context_t, table C.pie_table_t, id *C.uint8_t, idLen C.size_t, resourceType C.int32_t, resource *C.uint8_t, resourceLen C.size_t) (errCode C.int) {
+	defer recoverCPanic(&errCode)
+	return C.int(TablePutResource(C.uintptr_t(ctx), C.uintptr_t(table), goBytes(id, idLen), int32(resourceType), goBytes(resource, resourceLen)))
+}
+
+//export pie_table_put_revocation
+func pie_table_put_revocation(ctx C.pie_context_t, table C.pie_table_t, revocation *C.uint8_t, revocationLen C.size_t) (errCode C.int) {
+	defer recoverCPanic(&errCode)
+	return C.int(TablePutRevocation(C.uintptr_t(ctx), C.uintptr_t(table), goBytes(revocation, revocationLen)))
+}
+
+//export pie_table_resolve_cert
+func pie_table_resolve_cert(ctx C.pie_context_t, table C.pie_table_t, certDER *C.uint8_t, certDERLen C.size_t,
+	result *C.uint8_t, resultCap C.size_t, resultLen *C.size_t) (errCode C.int) {
+	defer recoverCPanic(&errCode)
+	n, errType := TableResolveCert(C.uintptr_t(ctx), C.uintptr_t(table), goBytes(certDER, certDERLen), goBytes(result, resultCap))
+	setSize(resultLen, n)
+	return C.int(errType)
+}
+
+//export pie_table_delete
+func pie_table_delete(table C.pie_table_t) (errCode C.int) {
+	defer recoverCPanic(&errCode)
+	return C.int(DeleteTable(C.uintptr_t(table)))
+}
+
+// goBytes views C memory as a slice without copying, so it may be kept by Go as host-owned buffers are.
+func goBytes(data *C.uint8_t, length C.size_t) []byte {
+	if data == nil {
+		return nil
+	}
+	return unsafe.Slice((*byte)(unsafe.Pointer(data)), int(length))
+}
+
+func goString(s *C.char, length C.size_t) string {
+	if s == nil {
+		return ""
+	}
+	return C.GoStringN(s, C.int(length))
+}
+
+// identityResult reports a certificate whose DER did not fit as PIE_E_MSG_TOO_LONG; the handle is still returned.
+func identityResult(certPtr C.uintptr_t, certDERLen int, errType int, certDERCap C.size_t, length *C.size_t, cert *C.pie_cert_t) C.int {
+	setSize(length, certDERLen)
+	*cert = C.pie_cert_t(certPtr)
+	if errType == ENo && certDERLen > int(certDERCap) {
+		return C.int(EMsgTooLong)
+	}
+	return C.int(errType)
+}
+
+func setSize(p *C.size_t, n int) {
+	if p != nil {
+		*p = C.size_t(n)
+	}
+}
+
+func writeResult(data []byte, buf []byte, length *C.size_t) int {
+	setSize(length, len(data))
+	if len(data) > len(buf) {
+		return EMsgTooLong
+	}
+	copy(buf, data)
+	return ENo
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"github.com/Pie-Messaging/core/pie"
+)
+
+// #include <stdint.h>
+// #include <sys/types.h>
+import "C"
+
+//export LoadConfig
+func LoadConfig(path string) (configPtr C.uintptr_t, errType int) {
+	defer recoverPanic(&errType)
+	config, err := pie.LoadConfig(path)
+	if err != nil {
+		return 0, fail(0, err)
+	}
+	return newHandle(config), ENo
+}
+
+//export ParseConfig
+func ParseConfig(data []byte, format string) (configPtr C.uintptr_t, errType int) {
+	defer recoverPanic(&errType)
+	config, err := pie.ParseConfig(data, format)
+	if err != nil {
+		return 0, fail(0, err)
+	}
+	return newHandle(config), ENo
+}
+
+//export DeleteConfig
+func DeleteConfig(configPtr C.uintptr_t) (errType int) {
+	defer recoverPanic(&errType)
+	if _, err := deleteHandle[*pie.Config](configPtr); err != nil {
+		return fail(configPtr, err)
+	}
+	return ENo
+}
+
+func getConfig(configPtr C.uintptr_t) (*pie.Config, error) {
+	if configPtr == 0 {
+		return nil, nil
+	}
+	return getHandle[*pie.Config](configPtr)
+}
+
+//export SetResumptionFile
+func SetResumptionFile(path string) {
+	defer recoverPanic(nil)
+	pie.SetDefaultResumption(pie.NewResumption(pie.NewFileResumptionStore(path)))
+}
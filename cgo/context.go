@@ -2,7 +2,6 @@ package main
 
 import (
 	"context"
-	"runtime/cgo"
 )
 
 // #include <stdint.h>
@@ -15,22 +14,30 @@ type Context struct {
 }
 
 //export NewContext
-func NewContext() C.uintptr_t {
+func NewContext() (ctxPtr C.uintptr_t) {
+	defer recoverPanic(nil)
 	ctx, cancelFunc := context.WithCancel(context.Background())
-	return C.uintptr_t(cgo.NewHandle(Context{ctx, cancelFunc}))
+	return newHandle(Context{ctx, cancelFunc})
 }
 
 //export CancelContext
-func CancelContext(ctxPtr C.uintptr_t) {
-	handle := cgo.Handle(ctxPtr)
-	ctx := handle.Value().(Context)
+func CancelContext(ctxPtr C.uintptr_t) (errType int) {
+	defer recoverPanic(&errType)
+	ctx, err := deleteHandle[Context](ctxPtr)
+	if err != nil {
+		return fail(ctxPtr, err)
+	}
 	ctx.CancelFunc()
-	handle.Delete()
+	return ENo
 }
 
-func getContext(ctxPtr C.uintptr_t) context.Context {
+func getContext(ctxPtr C.uintptr_t) (context.Context, error) {
 	if ctxPtr == 0 {
-		return context.Background()
+		return context.Background(), nil
+	}
+	ctx, err := getHandle[Context](ctxPtr)
+	if err != nil {
+		return nil, err
 	}
-	return cgo.Handle(ctxPtr).Value().(Context).Context
+	return ctx.Context, nil
 }
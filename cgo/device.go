@@ -0,0 +1,105 @@
+package main
+
+import (
+	"crypto/tls"
+	"github.com/Pie-Messaging/core/pie"
+	"github.com/Pie-Messaging/core/pie/pb"
+	"google.golang.org/protobuf/proto"
+	"time"
+)
+
+// #include <stdint.h>
+import "C"
+
+// LinkDevice signs deviceCertDER with the user cert and returns the serialized pb.Device.
+//
+//export LinkDevice
+func LinkDevice(userCertPtr C.uintptr_t, deviceCertDER []byte, name string, addresses []byte, result []byte) (resultLen int, errType int) {
+	defer recoverPanic(&errType)
+	userCert, err := getHandle[*tls.Certificate](userCertPtr)
+	if err != nil {
+		return 0, fail(userCertPtr, err)
+	}
+	addrList, err := parseAddrList(addresses)
+	if err != nil {
+		return 0, fail(0, err)
+	}
+	device, err := pie.LinkDevice(userCert, deviceCertDER, name, addrList...)
+	if err != nil {
+		return 0, fail(userCertPtr, err)
+	}
+	return marshalResult(device, result)
+}
+
+// SendToDevices calls the serialized request on every device of the serialized pb.User
+// and returns how many devices answered.
+//
+//export SendToDevices
+func SendToDevices(ctxPtr C.uintptr_t, clientID []byte, clientCertPtr C.uintptr_t, user []byte, request []byte, configPtr C.uintptr_t, timeout int64) (delivered int, errType int) {
+	defer recoverPanic(&errType)
+	cert, err := getHandle[*tls.Certificate](clientCertPtr)
+	if err != nil {
+		return 0, fail(clientCertPtr, err)
+	}
+	return sendToDevices(ctxPtr, user, request, configPtr, timeout, func(session *pie.Session) error {
+		return session.SendCert(cert, clientID)
+	})
+}
+
+// SendToDevicesAsDevice is SendToDevices for a device linked to the user by the serialized pb.Device.
+//
+//export SendToDevicesAsDevice
+func SendToDevicesAsDevice(ctxPtr C.uintptr_t, clientID []byte, deviceCertPtr C.uintptr_t, userCertDER []byte, device []byte, user []byte, request []byte, configPtr C.uintptr_t, timeout int64) (delivered int, errType int) {
+	defer recoverPanic(&errType)
+	cert, err := getHandle[*tls.Certificate](deviceCertPtr)
+	if err != nil {
+		return 0, fail(deviceCertPtr, err)
+	}
+	link := &pb.Device{}
+	if err := proto.Unmarshal(device, link); err != nil {
+		return 0, fail(0, err)
+	}
+	d := &pie.Device{UserCertDER: userCertDER, Cert: cert, Link: link}
+	return sendToDevices(ctxPtr, user, request, configPtr, timeout, func(session *pie.Session) error {
+		return d.SendCert(session, clientID)
+	})
+}
+
+func sendToDevices(ctxPtr C.uintptr_t, user []byte, request []byte, configPtr C.uintptr_t, timeout int64, sendCert func(*pie.Session) error) (int, int) {
+	ctx, err := getContext(ctxPtr)
+	if err != nil {
+		return 0, fail(ctxPtr, err)
+	}
+	config, err := getConfig(configPtr)
+	if err != nil {
+		return 0, fail(configPtr, err)
+	}
+	if config == nil {
+		config = pie.DefaultConfig()
+	}
+	userRecord := &pb.User{}
+	if err := proto.Unmarshal(user, userRecord); err != nil {
+		return 0, fail(0, err)
+	}
+	message, err := parseNetMessage(request)
+	if err != nil {
+		return 0, fail(0, err)
+	}
+	recvTimeout := time.Duration(timeout) * time.Millisecond
+	if timeout == 0 {
+		recvTimeout = time.Duration(config.Routing.RecvTimeout)
+	}
+	delivered := 0
+	var lastErr error
+	for _, result := range pie.SendToDevices(ctx, userRecord, sendCert, message, config, recvTimeout) {
+		if result.Err != nil {
+			lastErr = result.Err
+			continue
+		}
+		delivered++
+	}
+	if delivered == 0 && lastErr != nil {
+		return 0, fail(0, lastErr)
+	}
+	return delivered, ENo
+}
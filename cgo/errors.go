@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"github.com/Pie-Messaging/core/pie"
+	"github.com/Pie-Messaging/core/pie/identity"
+	"github.com/lucas-clemente/quic-go"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+)
+
+// #include <stdint.h>
+// #include <sys/types.h>
+import "C"
+
+const (
+	ENo int = iota
+	EUnknown
+	ETimedOut
+	EClosed
+	EMsgTooLong
+	ECanceled
+	EInvalidHandle
+	ETLS
+	ECert
+	EPeerClosed
+	ESessNotFound
+	EInvalidMsg
+	EAddr
+	ENotFound
+	EPassphrase
+	ERevoked
+	EInvalidArg
+	EExist
+)
+
+var (
+	errServerCertMismatch = errors.New("server certificate mismatch")
+)
+
+var (
+	lastErrors     = make(map[C.uintptr_t]string)
+	lastErrorMutex sync.Mutex
+)
+
+// GetLastError copies the message of the last error reported for handle, or of the last error of any call
+// when handle is 0, and returns its full length.
+//
+//export GetLastError
+func GetLastError(handle C.uintptr_t, result []byte) (resultLen int) {
+	defer recoverPanic(nil)
+	lastErrorMutex.Lock()
+	defer lastErrorMutex.Unlock()
+	message := lastErrors[handle]
+	copy(result, message)
+	return len(message)
+}
+
+// fail records err for handle and returns its error code.
+func fail(handle C.uintptr_t, err error) int {
+	lastErrorMutex.Lock()
+	defer lastErrorMutex.Unlock()
+	if handle != 0 && !errors.Is(err, errInvalidHandle) {
+		lastErrors[handle] = err.Error()
+	}
+	lastErrors[0] = err.Error()
+	return getErrType(err)
+}
+
+func clearLastError(handle C.uintptr_t) {
+	lastErrorMutex.Lock()
+	defer lastErrorMutex.Unlock()
+	delete(lastErrors, handle)
+}
+
+func getErrType(err error) int {
+	var applicationErr *quic.ApplicationError
+	var transportErr *quic.TransportError
+	var addrErr *net.AddrError
+	var dnsErr *net.DNSError
+	var certInvalidErr x509.CertificateInvalidError
+	var unknownAuthorityErr x509.UnknownAuthorityError
+	switch {
+	case errors.Is(err, context.DeadlineExceeded) || errors.Is(err, os.ErrDeadlineExceeded):
+		return ETimedOut
+	case errors.As(err, &applicationErr) && applicationErr.Remote:
+		if applicationErr.ErrorCode == pie.SessErrNotFound {
+			return ESessNotFound
+		}
+		return EPeerClosed
+	case errors.Is(err, io.EOF) || errors.Is(err, &quic.IdleTimeoutError{}):
+		return EClosed
+	case errors.Is(err, pie.ErrMsgTooLong):
+		return EMsgTooLong
+	case errors.Is(err, context.Canceled):
+		return ECanceled
+	case errors.Is(err, errInvalidHandle):
+		return EInvalidHandle
+	case errors.As(err, &transportErr) && transportErr.ErrorCode.IsCryptoError():
+		return ETLS
+	case errors.Is(err, errServerCertMismatch) || errors.Is(err, pie.ErrPeerMismatch) || errors.Is(err, pie.ErrBadSign) ||
+		errors.As(err, &certInvalidErr) || errors.As(err, &unknownAuthorityErr):
+		return ECert
+	case errors.Is(err, pie.ErrInvalidMsg) || errors.Is(err, pie.ErrEmptyMsg) || errors.Is(err, proto.Error):
+		return EInvalidMsg
+	case errors.Is(err, pie.ErrNoAddr) || errors.Is(err, pie.ErrSocketMismatch) || errors.Is(err, syscall.EADDRINUSE) || errors.As(err, &addrErr) || errors.As(err, &dnsErr):
+		return EAddr
+	case errors.Is(err, pie.ErrNotFound):
+		return ENotFound
+	case errors.Is(err, identity.ErrPassphrase):
+		return EPassphrase
+	case errors.Is(err, pie.ErrRevoked):
+		return ERevoked
+	case errors.Is(err, os.ErrExist):
+		return EExist
+	}
+	return EUnknown
+}
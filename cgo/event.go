@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"github.com/Pie-Messaging/core/pie"
+	"github.com/Pie-Messaging/core/pie/routing"
+	"google.golang.org/protobuf/proto"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+/*
+#define PIE_CORE_INTERNAL
+#include "pie_core.h"
+#include <stdlib.h>
+
+// pie_callback_queue is the queue whose callback the thread is running, so that a delete from the callback is detected
+static _Thread_local uintptr_t pie_callback_queue;
+
+static inline void pie_call_event_callback(uintptr_t queue, pie_event_callback cb, int type, uintptr_t handle, uintptr_t parent, void *data, int data_len, int err_type, void *user_data) {
+	uintptr_t outer = pie_callback_queue;
+	pie_callback_queue = queue;
+	cb(type, handle, parent, data, data_len, err_type, user_data);
+	pie_callback_queue = outer;
+}
+
+static inline uintptr_t pie_current_callback_queue(void) {
+	return pie_callback_queue;
+}
+*/
+import "C"
+
+const (
+	EventNone int = iota
+	EventSession
+	EventStream
+	EventMessage
+	EventClosed
+	EventTrackerConnected
+	EventTrackerDisconnected
+	EventTrackerRemoved
+)
+
+// TrackerEventsLen is the number of tracker events a table buffers for a queue before dropping them.
+const TrackerEventsLen = 64
+
+type event struct {
+	Type    int
+	Handle  C.uintptr_t
+	Parent  C.uintptr_t
+	Data    []byte
+	ErrType int
+}
+
+// EventQueue replaces per-call polling: watchers accept sessions, streams and messages in the background
+// and the host either polls the queue or receives them through a callback.
+// PollEvent returns EventNone on timeout, and keeps a message longer than dataResult until it is polled
+// again, returning EMsgTooLong with the required length.
+type EventQueue struct {
+	events  chan event
+	pending *event
+	mutex   sync.Mutex
+	// callback and userData receive the events once EventQueueSetCallback started the consumer
+	callback      C.pie_event_callback
+	userData      unsafe.Pointer
+	callbackMutex sync.Mutex
+	ctx           context.Context
+	cancel        context.CancelFunc
+	wg            sync.WaitGroup
+}
+
+//export NewEventQueue
+func NewEventQueue(size int) (queuePtr C.uintptr_t) {
+	defer recoverPanic(nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	queue := &EventQueue{events: make(chan event, size), ctx: ctx, cancel: cancel}
+	return newHandle(queue)
+}
+
+// EventQueueSetCallback delivers the events of the queue to callback from a background thread. A later call replaces
+// the callback and userData for the events not delivered yet.
+//
+//export EventQueueSetCallback
+func EventQueueSetCallback(queuePtr C.uintptr_t, callback C.pie_event_callback, userData unsafe.Pointer) (errType int) {
+	defer recoverPanic(&errType)
+	queue, err := getHandle[*EventQueue](queuePtr)
+	if err != nil {
+		return fail(queuePtr, err)
+	}
+	if callback == nil {
+		return EInvalidArg
+	}
+	queue.callbackMutex.Lock()
+	defer queue.callbackMutex.Unlock()
+	started := queue.callback != nil
+	queue.callback, queue.userData = callback, userData
+	if started {
+		return ENo
+	}
+	queue.wg.Add(1)
+	go func() {
+		defer queue.wg.Done()
+		defer recoverPanic(nil)
+		for {
+			select {
+			case <-queue.ctx.Done():
+				return
+			case e := <-queue.events:
+				// The previous callback may have deleted the queue
+				if queue.ctx.Err() != nil {
+					return
+				}
+				var data unsafe.Pointer
+				if len(e.Data) != 0 {
+					data = C.CBytes(e.Data)
+				}
+				queue.callbackMutex.Lock()
+				callback, userData := queue.callback, queue.userData
+				queue.callbackMutex.Unlock()
+				C.pie_call_event_callback(queuePtr, callback, C.int(e.Type), e.Handle, e.Parent, data, C.int(len(e.Data)), C.int(e.ErrType), userData)
+				C.free(data)
+			}
+		}
+	}()
+	return ENo
+}
+
+//export PollEvent
+func PollEvent(queuePtr C.uintptr_t, timeout int64, dataResult []byte) (eventType int, handle C.uintptr_t, parent C.uintptr_t, dataLen int, errType int) {
+	defer recoverPanic(&errType)
+	queue, err := getHandle[*EventQueue](queuePtr)
+	if err != nil {
+		return EventNone, 0, 0, 0, fail(queuePtr, err)
+	}
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	var e event
+	if queue.pending != nil {
+		e = *queue.pending
+		queue.pending = nil
+	} else {
+		timer := time.NewTimer(time.Duration(timeout) * time.Millisecond)
+		defer timer.Stop()
+		select {
+		case e = <-queue.events:
+		case <-timer.C:
+			return EventNone, 0, 0, 0, ETimedOut
+		case <-queue.ctx.Done():
+			return EventNone, 0, 0, 0, ECanceled
+		}
+	}
+	if len(e.Data) > len(dataResult) {
+		queue.pending = &e
+		return e.Type, e.Handle, e.Parent, len(e.Data), EMsgTooLong
+	}
+	copy(dataResult, e.Data)
+	return e.Type, e.Handle, e.Parent, len(e.Data), e.ErrType
+}
+
+//export EventQueueWatchServer
+func EventQueueWatchServer(queuePtr C.uintptr_t, serverPtr C.uintptr_t) (errType int) {
+	defer recoverPanic(&errType)
+	queue, err := getHandle[*EventQueue](queuePtr)
+	if err != nil {
+		return fail(queuePtr, err)
+	}
+	server, err := getHandle[*pie.Server](serverPtr)
+	if err != nil {
+		return fail(serverPtr, err)
+	}
+	queue.watch(func() bool {
+		session, err := server.AcceptSession(queue.ctx, true)
+		if err != nil {
+			return queue.closed(serverPtr, err)
+		}
+		handle := newHandle(session)
+		if !queue.push(event{Type: EventSession, Handle: handle, Parent: serverPtr}) {
+			// The host never learns the handle, so nobody would close the session
+			_, _ = deleteHandle[*pie.Session](handle)
+			session.Close(pie.SessErrNoReason)
+			return false
+		}
+		return true
+	})
+	return ENo
+}
+
+//export EventQueueWatchSession
+func EventQueueWatchSession(queuePtr C.uintptr_t, sessionPtr C.uintptr_t) (errType int) {
+	defer recoverPanic(&errType)
+	queue, err := getHandle[*EventQueue](queuePtr)
+	if err != nil {
+		return fail(queuePtr, err)
+	}
+	session, err := getHandle[*pie.Session](sessionPtr)
+	if err != nil {
+		return fail(sessionPtr, err)
+	}
+	queue.watch(func() bool {
+		stream, err := session.AcceptStream(queue.ctx, nil, true)
+		if err != nil {
+			return queue.closed(sessionPtr, err)
+		}
+		handle := newHandle(stream)
+		if !queue.push(event{Type: EventStream, Handle: handle, Parent: sessionPtr}) {
+			_, _ = deleteHandle[*pie.Stream](handle)
+			stream.Close()
+			return false
+		}
+		return true
+	})
+	return ENo
+}
+
+//export EventQueueWatchStream
+func EventQueueWatchStream(queuePtr C.uintptr_t, streamPtr C.uintptr_t) (errType int) {
+	defer recoverPanic(&errType)
+	queue, err := getHandle[*EventQueue](queuePtr)
+	if err != nil {
+		return fail(queuePtr, err)
+	}
+	stream, err := getHandle[*pie.Stream](streamPtr)
+	if err != nil {
+		return fail(streamPtr, err)
+	}
+	queue.watch(func() bool {
+		data, _, _, err := stream.RecvDataContext(queue.ctx, true)
+		if err != nil {
+			return queue.closed(streamPtr, err)
+		}
+		return queue.push(event{Type: EventMessage, Handle: streamPtr, Data: append([]byte(nil), data...)})
+	})
+	return ENo
+}
+
+// EventQueueWatchTable reports trackers of the table connecting, disconnecting and being removed, with the serialized
+// pb.Tracker as data and the last connection error of removed ones. Several queues may watch the same table.
+//
+//export EventQueueWatchTable
+func EventQueueWatchTable(queuePtr C.uintptr_t, tablePtr C.uintptr_t) (errType int) {
+	defer recoverPanic(&errType)
+	queue, err := getHandle[*EventQueue](queuePtr)
+	if err != nil {
+		return fail(queuePtr, err)
+	}
+	table, err := getHandle[*routing.Table](tablePtr)
+	if err != nil {
+		return fail(tablePtr, err)
+	}
+	events := make(chan routing.TrackerEvent, TrackerEventsLen)
+	table.AddEvents(events)
+	go func() {
+		<-queue.ctx.Done()
+		table.RemoveEvents(events)
+	}()
+	queue.watch(func() bool {
+		select {
+		case <-queue.ctx.Done():
+			return false
+		case e := <-events:
+			data, err := proto.Marshal(e.Tracker.Proto())
+			if err != nil {
+				pie.Logger.Println("Failed to marshal tracker:", err)
+				return true
+			}
+			errType := ENo
+			if e.Err != nil {
+				errType = fail(tablePtr, e.Err)
+			}
+			// The tracker events are declared in the order of routing.TrackerEventType
+			return queue.push(event{Type: EventTrackerConnected + int(e.Type), Handle: tablePtr, Data: data, ErrType: errType})
+		}
+	})
+	return ENo
+}
+
+//export DeleteEventQueue
+func DeleteEventQueue(queuePtr C.uintptr_t) (errType int) {
+	defer recoverPanic(&errType)
+	queue, err := deleteHandle[*EventQueue](queuePtr)
+	if err != nil {
+		return fail(queuePtr, err)
+	}
+	queue.cancel()
+	// Deleted from its callback, the queue cannot wait for the callback to return
+	if C.pie_current_callback_queue() == queuePtr {
+		return ENo
+	}
+	queue.wg.Wait()
+	return ENo
+}
+
+// watch runs step until it returns false or the queue is deleted.
+func (q *EventQueue) watch(step func() bool) {
+	q.wg.Add(1)
+	go func() {
+		defer q.wg.Done()
+		defer recoverPanic(nil)
+		for q.ctx.Err() == nil && step() {
+		}
+	}()
+}
+
+func (q *EventQueue) push(e event) bool {
+	select {
+	case q.events <- e:
+		return true
+	case <-q.ctx.Done():
+		return false
+	}
+}
+
+func (q *EventQueue) closed(handle C.uintptr_t, err error) bool {
+	if q.ctx.Err() == nil {
+		q.push(event{Type: EventClosed, Handle: handle, ErrType: fail(handle, err)})
+	}
+	return false
+}
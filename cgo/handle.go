@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Pie-Messaging/core/pie"
+	"runtime/debug"
+	"sync"
+)
+
+// #include <stdint.h>
+// #include <sys/types.h>
+import "C"
+
+var (
+	errInvalidHandle = errors.New("invalid handle")
+)
+
+var (
+	handles = &handleRegistry{values: make(map[C.uintptr_t]any)}
+)
+
+// handleRegistry replaces runtime/cgo.Handle, whose Value panics on stale handles.
+type handleRegistry struct {
+	values map[C.uintptr_t]any
+	next   C.uintptr_t
+	mutex  sync.RWMutex
+}
+
+func newHandle(value any) C.uintptr_t {
+	handles.mutex.Lock()
+	defer handles.mutex.Unlock()
+	handles.next++
+	handles.values[handles.next] = value
+	return handles.next
+}
+
+func getHandle[T any](ptr C.uintptr_t) (T, error) {
+	handles.mutex.RLock()
+	defer handles.mutex.RUnlock()
+	value, ok := handles.values[ptr].(T)
+	if !ok {
+		pie.Logger.Println("Invalid handle:", ptr)
+		return value, errInvalidHandle
+	}
+	return value, nil
+}
+
+// deleteHandle removes ptr if it holds a T, so a handle of another type cannot be deleted by mistake.
+func deleteHandle[T any](ptr C.uintptr_t) (T, error) {
+	handles.mutex.Lock()
+	defer handles.mutex.Unlock()
+	value, ok := handles.values[ptr].(T)
+	if !ok {
+		pie.Logger.Println("Invalid handle:", ptr)
+		return value, errInvalidHandle
+	}
+	delete(handles.values, ptr)
+	clearLastError(ptr)
+	clearPendingMessage(ptr)
+	return value, nil
+}
+
+// recoverPanic must be deferred by every export so that a panic never unwinds into the host.
+func recoverPanic(errType *int) {
+	if r := recover(); r != nil {
+		code := recovered(r)
+		if errType != nil {
+			*errType = code
+		}
+	}
+}
+
+// recoverCPanic is recoverPanic for the pie_* exports, whose error is a C.int.
+func recoverCPanic(errType *C.int) {
+	if r := recover(); r != nil {
+		*errType = C.int(recovered(r))
+	}
+}
+
+func recovered(r any) int {
+	pie.Logger.Println("Recovered from panic:", r, string(debug.Stack()))
+	return fail(0, fmt.Errorf("panic: %v", r))
+}
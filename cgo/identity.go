@@ -0,0 +1,41 @@
+package main
+
+import (
+	"github.com/Pie-Messaging/core/pie/identity"
+)
+
+// #include <stdint.h>
+import "C"
+
+// IdentityGenerate fails with EExist if path exists, unless overwrite is set.
+//
+//export IdentityGenerate
+func IdentityGenerate(path string, passphrase []byte, overwrite bool, certDERResult []byte) (certPtr C.uintptr_t, certDERLen int, errType int) {
+	defer recoverPanic(&errType)
+	cert, err := identity.Generate(path, passphrase, overwrite)
+	if err != nil {
+		return 0, 0, fail(0, err)
+	}
+	copy(certDERResult, cert.Certificate[0])
+	return newHandle(cert), len(cert.Certificate[0]), ENo
+}
+
+//export IdentityLoad
+func IdentityLoad(path string, passphrase []byte, certDERResult []byte) (certPtr C.uintptr_t, certDERLen int, errType int) {
+	defer recoverPanic(&errType)
+	cert, err := identity.Load(path, passphrase)
+	if err != nil {
+		return 0, 0, fail(0, err)
+	}
+	copy(certDERResult, cert.Certificate[0])
+	return newHandle(cert), len(cert.Certificate[0]), ENo
+}
+
+//export IdentityChangePassphrase
+func IdentityChangePassphrase(path string, oldPassphrase []byte, newPassphrase []byte) (errType int) {
+	defer recoverPanic(&errType)
+	if err := identity.ChangePassphrase(path, oldPassphrase, newPassphrase); err != nil {
+		return fail(0, err)
+	}
+	return ENo
+}
@@ -9,11 +9,13 @@ import "C"
 
 //export SetLogOutput
 func SetLogOutput(path string) {
+	defer recoverPanic(nil)
 	pie.SetLogOutput(path)
 }
 
 //export HashBytes
 func HashBytes(data []byte, result []byte) {
+	defer recoverPanic(nil)
 	sha3.ShakeSum256(result, data)
 }
 
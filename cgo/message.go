@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"github.com/Pie-Messaging/core/pie"
+	"github.com/Pie-Messaging/core/pie/pb"
+	"google.golang.org/protobuf/proto"
+	"sync"
+	"time"
+)
+
+// #include <stdint.h>
+import "C"
+
+var (
+	// pendingMessages holds per handle a received message that did not fit into the result buffer
+	pendingMessages = make(map[C.uintptr_t]pendingMessage)
+	pendingMutex    sync.Mutex
+)
+
+// pendingMessage is a message kept for the next call on its handle that makes request, which is nil for streams.
+type pendingMessage struct {
+	request []byte
+	data    []byte
+}
+
+// StreamSendMessage sends a serialized pb.NetMessage, as 0-RTT early data if the message is idempotent.
+//
+//export StreamSendMessage
+func StreamSendMessage(streamPtr C.uintptr_t, message []byte, timeout int64) (errType int) {
+	defer recoverPanic(&errType)
+	stream, err := getHandle[*pie.Stream](streamPtr)
+	if err != nil {
+		return fail(streamPtr, err)
+	}
+	netMessage, err := parseNetMessage(message)
+	if err != nil {
+		return fail(streamPtr, err)
+	}
+	if err := stream.SendMarshaled(netMessage, message, getDeadline(timeout)); err != nil {
+		return fail(streamPtr, err)
+	}
+	return ENo
+}
+
+// StreamRecvMessage copies the next serialized pb.NetMessage into result. A message longer than result is kept, and
+// its length returned with EMsgTooLong, until a call passes a large enough result.
+//
+//export StreamRecvMessage
+func StreamRecvMessage(streamPtr C.uintptr_t, timeout int64, result []byte) (resultLen int, errType int) {
+	defer recoverPanic(&errType)
+	stream, err := getHandle[*pie.Stream](streamPtr)
+	if err != nil {
+		return 0, fail(streamPtr, err)
+	}
+	return recvPending(streamPtr, nil, result, func() ([]byte, error) {
+		data, _, _, err := stream.RecvData(getDeadline(timeout), true)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := parseNetMessage(data); err != nil {
+			return nil, err
+		}
+		return data, nil
+	})
+}
+
+// recvPending copies the message kept for handle and request, or else the one recv returns, into result. A message
+// longer than result is kept for handle and request instead, like EventQueue.pending. A call making another request
+// drops the kept message.
+func recvPending(handle C.uintptr_t, request []byte, result []byte, recv func() ([]byte, error)) (int, int) {
+	pendingMutex.Lock()
+	pending, ok := pendingMessages[handle]
+	delete(pendingMessages, handle)
+	pendingMutex.Unlock()
+	data := pending.data
+	if !ok || !bytes.Equal(pending.request, request) {
+		var err error
+		if data, err = recv(); err != nil {
+			return 0, fail(handle, err)
+		}
+	}
+	if len(data) > len(result) {
+		pendingMutex.Lock()
+		pendingMessages[handle] = pendingMessage{request: append([]byte(nil), request...), data: data}
+		pendingMutex.Unlock()
+		return len(data), fail(handle, pie.ErrMsgTooLong)
+	}
+	copy(result, data)
+	return len(data), ENo
+}
+
+func clearPendingMessage(handle C.uintptr_t) {
+	pendingMutex.Lock()
+	defer pendingMutex.Unlock()
+	delete(pendingMessages, handle)
+}
+
+// SessionCall sends a serialized pb.NetMessage request on a new stream and
+// copies the matching response into result. One-way requests return a zero length.
+// A response longer than result is kept, and its length returned with EMsgTooLong,
+// until a call with the same request passes a large enough result.
+//
+//export SessionCall
+func SessionCall(sessionPtr C.uintptr_t, request []byte, timeout int64, result []byte) (resultLen int, errType int) {
+	defer recoverPanic(&errType)
+	session, err := getHandle[*pie.Session](sessionPtr)
+	if err != nil {
+		return 0, fail(sessionPtr, err)
+	}
+	message, err := parseNetMessage(request)
+	if err != nil {
+		return 0, fail(sessionPtr, err)
+	}
+	recvTimeout := time.Duration(timeout) * time.Millisecond
+	if timeout == 0 {
+		recvTimeout = time.Duration(session.Config.Routing.RecvTimeout)
+	}
+	return recvPending(sessionPtr, request, result, func() ([]byte, error) {
+		response, err := session.Call(message, recvTimeout)
+		if err != nil || response == nil {
+			return nil, err
+		}
+		return proto.Marshal(response)
+	})
+}
+
+func parseNetMessage(data []byte) (*pb.NetMessage, error) {
+	message := &pb.NetMessage{}
+	if err := proto.Unmarshal(data, message); err != nil {
+		return nil, err
+	}
+	if err := pie.ValidateMessage(message); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
@@ -7,11 +7,9 @@ import (
 	"crypto/x509"
 	"errors"
 	"github.com/Pie-Messaging/core/pie"
-	"github.com/lucas-clemente/quic-go"
-	"io"
+	"github.com/Pie-Messaging/core/pie/pb"
+	"google.golang.org/protobuf/proto"
 	"net"
-	"os"
-	"runtime/cgo"
 	"syscall"
 	"time"
 )
@@ -24,66 +22,75 @@ const (
 	cgoTimeout = time.Second * 1
 )
 
-const (
-	ENo int = iota
-	EUnknown
-	ETimedOut
-	EClosed
-	EMsgTooLong
-	ECanceled
-)
-
 //export X509KeyPair
-func X509KeyPair(certPEM []byte, keyPEM []byte, certDERResult []byte) C.uintptr_t {
+func X509KeyPair(certPEM []byte, keyPEM []byte, certDERResult []byte) (certPtr C.uintptr_t, errType int) {
+	defer recoverPanic(&errType)
 	cert, err := pie.X509KeyPair(certPEM, keyPEM)
 	if err != nil {
-		return 0
+		return 0, fail(0, err)
 	}
 	copy(certDERResult, cert.Certificate[0])
-	return C.uintptr_t(cgo.NewHandle(cert))
+	return newHandle(cert), ENo
 }
 
 //export GenerateKeyPair
-func GenerateKeyPair(certResult []byte, keyResult []byte, certDERResult []byte) C.uintptr_t {
+func GenerateKeyPair(certResult []byte, keyResult []byte, certDERResult []byte) (certPtr C.uintptr_t, errType int) {
+	defer recoverPanic(&errType)
 	cert, certPEM, keyPEM, err := pie.GenerateKeyPair()
 	if err != nil {
-		return 0
+		return 0, fail(0, err)
 	}
 	copy(certResult, certPEM)
 	copy(keyResult, keyPEM)
 	copy(certDERResult, cert.Certificate[0])
-	return C.uintptr_t(cgo.NewHandle(cert))
+	return newHandle(cert), ENo
 }
 
 //export ListenNet
-func ListenNet(listenAddr string, certPtr C.uintptr_t) (C.uintptr_t, int) {
-	cert := cgo.Handle(certPtr).Value().(*tls.Certificate)
+func ListenNet(listenAddr string, certPtr C.uintptr_t, configPtr C.uintptr_t) (serverPtr C.uintptr_t, port int, errType int) {
+	defer recoverPanic(&errType)
+	cert, err := getHandle[*tls.Certificate](certPtr)
+	if err != nil {
+		return 0, 0, fail(certPtr, err)
+	}
+	config, err := getConfig(configPtr)
+	if err != nil {
+		return 0, 0, fail(configPtr, err)
+	}
 	tlsConfig := &tls.Config{
 		Certificates: []tls.Certificate{*cert},
 		NextProtos:   []string{pie.UserTLSProto},
 	}
 	for {
-		server, err := pie.ListenNet(listenAddr, tlsConfig, nil)
+		server, err := pie.ListenNet(listenAddr, tlsConfig, config)
 		if err != nil {
-			if errors.Is(err, syscall.EADDRINUSE) {
+			if errors.Is(err, syscall.EADDRINUSE) && listenAddr != ":0" {
 				listenAddr = ":0"
 				continue
 			}
-			return 0, 0
+			return 0, 0, fail(0, err)
 		}
 		port := server.Listener.Addr().(*net.UDPAddr).Port
-		return C.uintptr_t(cgo.NewHandle(server)), port
+		return newHandle(server), port, ENo
 	}
 }
 
 //export AcceptSession
-func AcceptSession(ctxPtr C.uintptr_t, serverPtr C.uintptr_t, addrResult []byte) (C.uintptr_t, int, int) {
-	ctx, cancel := context.WithTimeout(getContext(ctxPtr), cgoTimeout)
+func AcceptSession(ctxPtr C.uintptr_t, serverPtr C.uintptr_t, addrResult []byte) (sessionPtr C.uintptr_t, addrLen int, errType int) {
+	defer recoverPanic(&errType)
+	parent, err := getContext(ctxPtr)
+	if err != nil {
+		return 0, 0, fail(ctxPtr, err)
+	}
+	server, err := getHandle[*pie.Server](serverPtr)
+	if err != nil {
+		return 0, 0, fail(serverPtr, err)
+	}
+	ctx, cancel := context.WithTimeout(parent, cgoTimeout)
 	defer cancel()
-	server := cgo.Handle(serverPtr).Value().(*pie.Server)
 	session, err := server.AcceptSession(ctx, true)
 	if err != nil {
-		errType := getErrType(err)
+		errType := fail(serverPtr, err)
 		if errType != ENo && errType != ETimedOut {
 			pie.Logger.Println("Failed to accept session:", err)
 		}
@@ -91,129 +98,231 @@ func AcceptSession(ctxPtr C.uintptr_t, serverPtr C.uintptr_t, addrResult []byte)
 	}
 	addr := session.Session.RemoteAddr().String()
 	copy(addrResult, addr)
-	return C.uintptr_t(cgo.NewHandle(session)), len(addr), ENo
+	return newHandle(session), len(addr), ENo
 }
 
 //export VerifyClientCert
-func VerifyClientCert(serverPtr C.uintptr_t, clientCertDER []byte, serverCertSign []byte) bool {
-	server := cgo.Handle(serverPtr).Value().(*pie.Server)
+func VerifyClientCert(serverPtr C.uintptr_t, clientCertDER []byte, serverCertSign []byte) (errType int) {
+	defer recoverPanic(&errType)
+	server, err := getHandle[*pie.Server](serverPtr)
+	if err != nil {
+		return fail(serverPtr, err)
+	}
 	if err := server.VerifyClientCert(clientCertDER, serverCertSign); err != nil {
-		return false
+		return fail(serverPtr, err)
+	}
+	return ENo
+}
+
+// VerifyDeviceCert is VerifyClientCert for a client signing with a device linked by the serialized pb.Device.
+//
+//export VerifyDeviceCert
+func VerifyDeviceCert(serverPtr C.uintptr_t, clientCertDER []byte, serverCertSign []byte, device []byte) (errType int) {
+	defer recoverPanic(&errType)
+	server, err := getHandle[*pie.Server](serverPtr)
+	if err != nil {
+		return fail(serverPtr, err)
+	}
+	link := &pb.Device{}
+	if err := proto.Unmarshal(device, link); err != nil {
+		return fail(serverPtr, err)
 	}
-	return true
+	if err := server.VerifyClientCert(clientCertDER, serverCertSign, link); err != nil {
+		return fail(serverPtr, err)
+	}
+	return ENo
 }
 
 //export ConnectServer
-func ConnectServer(ctxPtr C.uintptr_t, clientID []byte, clientCertPtr C.uintptr_t, serverAddr string, serverCertDER []byte) (C.uintptr_t, int) {
-	ctx := getContext(ctxPtr)
+func ConnectServer(ctxPtr C.uintptr_t, clientID []byte, clientCertPtr C.uintptr_t, serverAddr string, serverCertDER []byte, configPtr C.uintptr_t) (sessionPtr C.uintptr_t, errType int) {
+	defer recoverPanic(&errType)
+	cert, err := getHandle[*tls.Certificate](clientCertPtr)
+	if err != nil {
+		return 0, fail(clientCertPtr, err)
+	}
+	return connectServer(ctxPtr, serverAddr, serverCertDER, configPtr, func(session *pie.Session) error {
+		return session.SendCert(cert, clientID)
+	})
+}
+
+// ConnectServerAsDevice is ConnectServer for a device linked to the user by the serialized pb.Device.
+//
+//export ConnectServerAsDevice
+func ConnectServerAsDevice(ctxPtr C.uintptr_t, clientID []byte, deviceCertPtr C.uintptr_t, userCertDER []byte, device []byte, serverAddr string, serverCertDER []byte, configPtr C.uintptr_t) (sessionPtr C.uintptr_t, errType int) {
+	defer recoverPanic(&errType)
+	cert, err := getHandle[*tls.Certificate](deviceCertPtr)
+	if err != nil {
+		return 0, fail(deviceCertPtr, err)
+	}
+	link := &pb.Device{}
+	if err := proto.Unmarshal(device, link); err != nil {
+		return 0, fail(0, err)
+	}
+	d := &pie.Device{UserCertDER: userCertDER, Cert: cert, Link: link}
+	return connectServer(ctxPtr, serverAddr, serverCertDER, configPtr, func(session *pie.Session) error {
+		return d.SendCert(session, clientID)
+	})
+}
+
+func connectServer(ctxPtr C.uintptr_t, serverAddr string, serverCertDER []byte, configPtr C.uintptr_t, sendCert func(*pie.Session) error) (C.uintptr_t, int) {
+	ctx, err := getContext(ctxPtr)
+	if err != nil {
+		return 0, fail(ctxPtr, err)
+	}
+	config, err := getConfig(configPtr)
+	if err != nil {
+		return 0, fail(configPtr, err)
+	}
 	tlsConfig := &tls.Config{
 		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
 			if !bytes.Equal(rawCerts[0], serverCertDER) {
-				return errors.New("server certificate mismatch")
+				return errServerCertMismatch
 			}
 			return nil
 		},
 		NextProtos:         []string{pie.UserTLSProto},
 		InsecureSkipVerify: true,
 	}
-	session, err := pie.Connect(ctx, tlsConfig, serverAddr)
+	session, err := pie.Connect(ctx, tlsConfig, config, serverAddr)
 	if err != nil {
-		return 0, getErrType(err)
+		return 0, fail(0, err)
 	}
-	cert := cgo.Handle(clientCertPtr).Value().(*tls.Certificate)
-	err = session.SendCert(cert, clientID)
+	err = sendCert(session)
 	pie.Logger.Println("Finished sending cert:", err)
 	if err != nil {
-		return 0, getErrType(err)
+		return 0, fail(0, err)
 	}
-	return C.uintptr_t(cgo.NewHandle(session)), ENo
+	return newHandle(session), ENo
 }
 
 //export ConnectTracker
-func ConnectTracker(ctxPtr C.uintptr_t, addr string, idResult []byte) (C.uintptr_t, int) {
-	ctx := getContext(ctxPtr)
+func ConnectTracker(ctxPtr C.uintptr_t, addr string, idResult []byte, configPtr C.uintptr_t) (sessionPtr C.uintptr_t, errType int) {
+	defer recoverPanic(&errType)
+	ctx, err := getContext(ctxPtr)
+	if err != nil {
+		return 0, fail(ctxPtr, err)
+	}
+	config, err := getConfig(configPtr)
+	if err != nil {
+		return 0, fail(configPtr, err)
+	}
 	tlsConfig := &tls.Config{
 		NextProtos:         []string{pie.UserTLSProto},
 		InsecureSkipVerify: true,
 	}
-	session, err := pie.Connect(ctx, tlsConfig, addr)
+	session, err := pie.Connect(ctx, tlsConfig, config, addr)
 	if err != nil {
-		return 0, getErrType(err)
+		return 0, fail(0, err)
 	}
 	copy(idResult, session.GetPeerIDByCertHash())
-	return C.uintptr_t(cgo.NewHandle(session)), ENo
+	return newHandle(session), ENo
 }
 
 //export SessionAcceptStream
-func SessionAcceptStream(ctxPtr C.uintptr_t, sessionPtr C.uintptr_t, recvBuf []byte) (C.uintptr_t, int64, int) {
-	ctx, cancel := context.WithTimeout(getContext(ctxPtr), cgoTimeout)
+func SessionAcceptStream(ctxPtr C.uintptr_t, sessionPtr C.uintptr_t, recvBuf []byte) (streamPtr C.uintptr_t, streamID int64, errType int) {
+	defer recoverPanic(&errType)
+	parent, err := getContext(ctxPtr)
+	if err != nil {
+		return 0, -1, fail(ctxPtr, err)
+	}
+	session, err := getHandle[*pie.Session](sessionPtr)
+	if err != nil {
+		return 0, -1, fail(sessionPtr, err)
+	}
+	ctx, cancel := context.WithTimeout(parent, cgoTimeout)
 	defer cancel()
-	session := cgo.Handle(sessionPtr).Value().(*pie.Session)
 	stream, err := session.AcceptStream(ctx, recvBuf, true)
 	if err != nil {
-		errType := getErrType(err)
+		errType := fail(sessionPtr, err)
 		if errType != ENo && errType != ETimedOut && errType != ECanceled {
 			pie.Logger.Println("Failed to accept stream:", err)
 		}
 		return 0, -1, errType
 	}
-	return C.uintptr_t(cgo.NewHandle(stream)), int64(stream.Stream.StreamID()), ENo
+	return newHandle(stream), int64(stream.Stream.StreamID()), ENo
 }
 
 //export SessionOpenStream
-func SessionOpenStream(sessionPtr C.uintptr_t, recvBuf []byte) (C.uintptr_t, int64, int) {
-	session := cgo.Handle(sessionPtr).Value().(*pie.Session)
+func SessionOpenStream(sessionPtr C.uintptr_t, recvBuf []byte) (streamPtr C.uintptr_t, streamID int64, errType int) {
+	defer recoverPanic(&errType)
+	session, err := getHandle[*pie.Session](sessionPtr)
+	if err != nil {
+		return 0, -1, fail(sessionPtr, err)
+	}
 	stream, err := session.OpenStream(recvBuf)
 	if err != nil {
-		return 0, -1, getErrType(err)
+		return 0, -1, fail(sessionPtr, err)
 	}
-	return C.uintptr_t(cgo.NewHandle(stream)), int64(stream.Stream.StreamID()), ENo
+	return newHandle(stream), int64(stream.Stream.StreamID()), ENo
 }
 
 //export StreamRecvData
-func StreamRecvData(streamPtr C.uintptr_t) (int, int, int) {
-	_, start, end, err := cgo.Handle(streamPtr).Value().(*pie.Stream).RecvData(time.Now().Add(cgoTimeout), true)
+func StreamRecvData(streamPtr C.uintptr_t) (start int, end int, errType int) {
+	defer recoverPanic(&errType)
+	stream, err := getHandle[*pie.Stream](streamPtr)
 	if err != nil {
-		return -1, -1, getErrType(err)
+		return -1, -1, fail(streamPtr, err)
+	}
+	_, start, end, err = stream.RecvData(time.Now().Add(cgoTimeout), true)
+	if err != nil {
+		return -1, -1, fail(streamPtr, err)
 	}
 	return start, end, ENo
 }
 
 //export StreamSendData
-func StreamSendData(streamPtr C.uintptr_t, data []byte, timeout int64) int {
-	if err := cgo.Handle(streamPtr).Value().(*pie.Stream).SendData(data, getDeadline(timeout)); err != nil {
-		return getErrType(err)
+func StreamSendData(streamPtr C.uintptr_t, data []byte, timeout int64) (errType int) {
+	defer recoverPanic(&errType)
+	stream, err := getHandle[*pie.Stream](streamPtr)
+	if err != nil {
+		return fail(streamPtr, err)
+	}
+	if err := stream.SendData(data, getDeadline(timeout)); err != nil {
+		return fail(streamPtr, err)
 	}
 	return ENo
 }
 
 //export CloseStream
-func CloseStream(streamPtr C.uintptr_t) {
-	handle := cgo.Handle(streamPtr)
-	stream := handle.Value().(*pie.Stream)
+func CloseStream(streamPtr C.uintptr_t) (errType int) {
+	defer recoverPanic(&errType)
+	stream, err := deleteHandle[*pie.Stream](streamPtr)
+	if err != nil {
+		return fail(streamPtr, err)
+	}
 	stream.Close()
-	handle.Delete()
+	return ENo
 }
 
 //export CloseSession
-func CloseSession(sessionPtr C.uintptr_t, err uint64) {
-	handle := cgo.Handle(sessionPtr)
-	session := handle.Value().(*pie.Session)
+func CloseSession(sessionPtr C.uintptr_t, err uint64) (errType int) {
+	defer recoverPanic(&errType)
+	session, e := deleteHandle[*pie.Session](sessionPtr)
+	if e != nil {
+		return fail(sessionPtr, e)
+	}
 	session.Close(err)
-	handle.Delete()
+	return ENo
 }
 
 //export CloseServer
-func CloseServer(serverPtr C.uintptr_t) {
-	handle := cgo.Handle(serverPtr)
-	server := handle.Value().(*pie.Server)
+func CloseServer(serverPtr C.uintptr_t) (errType int) {
+	defer recoverPanic(&errType)
+	server, err := deleteHandle[*pie.Server](serverPtr)
+	if err != nil {
+		return fail(serverPtr, err)
+	}
 	server.Close()
-	handle.Delete()
+	return ENo
 }
 
 //export DeleteCert
-func DeleteCert(certPtr C.uintptr_t) {
-	cgo.Handle(certPtr).Delete()
+func DeleteCert(certPtr C.uintptr_t) (errType int) {
+	defer recoverPanic(&errType)
+	if _, err := deleteHandle[*tls.Certificate](certPtr); err != nil {
+		return fail(certPtr, err)
+	}
+	return ENo
 }
 
 func getDeadline(timeout int64) time.Time {
@@ -223,16 +332,13 @@ func getDeadline(timeout int64) time.Time {
 	return time.Now().Add(time.Duration(timeout) * time.Millisecond)
 }
 
-func getErrType(err error) int {
-	errType := EUnknown
-	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, os.ErrDeadlineExceeded) {
-		errType = ETimedOut
-	} else if errors.Is(err, io.EOF) || errors.Is(err, &quic.IdleTimeoutError{}) {
-		errType = EClosed
-	} else if errors.Is(err, pie.ErrMsgTooLong) {
-		errType = EMsgTooLong
-	} else if errors.Is(err, context.Canceled) {
-		errType = ECanceled
+// timeoutOrDefault is timeout in milliseconds, or the RecvTimeout of config when it is 0.
+func timeoutOrDefault(config *pie.Config, timeout int64) time.Duration {
+	if timeout != 0 {
+		return time.Duration(timeout) * time.Millisecond
+	}
+	if config == nil {
+		config = pie.DefaultConfig()
 	}
-	return errType
+	return time.Duration(config.Routing.RecvTimeout)
 }
@@ -0,0 +1,188 @@
+#ifndef PIE_CORE_H
+#define PIE_CORE_H
+
+#include <stddef.h>
+#include <stdint.h>
+
+/*
+ * Stable C ABI of the Pie core library.
+ *
+ * Every function returns a pie_error and writes results through out-parameters. Length
+ * out-parameters may be NULL; any other NULL out-parameter is rejected with PIE_E_INVALID_ARG,
+ * and so is a tracker or resource ID that is not 24 bytes long.
+ * Buffers are passed as pointer and capacity; when a result does not fit, PIE_E_MSG_TOO_LONG
+ * is returned and the length out-parameter holds the required size.
+ * PIE_CORE_ABI_VERSION changes whenever a signature or a constant below changes,
+ * so host applications should compare it with pie_abi_version() at startup.
+ */
+
+/*
+ * Version 2 started with the punch exports (pie_server_connect_tracker, pie_server_request_punch,
+ * pie_server_handle_punch_notify, pie_table_serve) and covers every function and constant added since.
+ */
+#define PIE_CORE_ABI_VERSION 2
+
+#ifdef __cplusplus
+extern "C" {
+#endif
+
+typedef uintptr_t pie_handle_t;
+typedef pie_handle_t pie_context_t;
+typedef pie_handle_t pie_config_t;
+typedef pie_handle_t pie_cert_t;
+typedef pie_handle_t pie_server_t;
+typedef pie_handle_t pie_session_t;
+typedef pie_handle_t pie_stream_t;
+typedef pie_handle_t pie_secure_stream_t;
+typedef pie_handle_t pie_event_queue_t;
+typedef pie_handle_t pie_table_t;
+
+typedef enum pie_error {
+	PIE_OK = 0,
+	PIE_E_UNKNOWN,
+	PIE_E_TIMED_OUT,
+	PIE_E_CLOSED,
+	PIE_E_MSG_TOO_LONG,
+	PIE_E_CANCELED,
+	PIE_E_INVALID_HANDLE,
+	PIE_E_TLS,
+	PIE_E_CERT,
+	PIE_E_PEER_CLOSED,
+	PIE_E_SESS_NOT_FOUND,
+	PIE_E_INVALID_MSG,
+	PIE_E_ADDR,
+	PIE_E_NOT_FOUND,
+	PIE_E_PASSPHRASE,
+	PIE_E_REVOKED,
+	PIE_E_INVALID_ARG,
+	PIE_E_EXIST,
+} pie_error;
+
+typedef enum pie_event_type {
+	PIE_EVENT_NONE = 0,
+	PIE_EVENT_SESSION,
+	PIE_EVENT_STREAM,
+	PIE_EVENT_MESSAGE,
+	PIE_EVENT_CLOSED,
+	PIE_EVENT_TRACKER_CONNECTED,
+	PIE_EVENT_TRACKER_DISCONNECTED,
+	PIE_EVENT_TRACKER_REMOVED,
+} pie_event_type;
+
+typedef struct pie_event {
+	int type;
+	pie_handle_t handle;
+	pie_handle_t parent;
+	size_t data_len;
+	int err;
+} pie_event;
+
+/*
+ * The event callback runs on a library thread, one event at a time. It may delete its own queue;
+ * pie_event_queue_delete then returns without waiting for the callback, and no event follows it.
+ * Setting the callback again replaces it and its user data; a NULL callback is rejected.
+ */
+typedef void (*pie_event_callback)(int type, uintptr_t handle, uintptr_t parent, void *data, int data_len, int err_type, void *user_data);
+
+#ifndef PIE_CORE_INTERNAL
+
+int pie_abi_version(void);
+int pie_get_last_error(pie_handle_t handle, char *buf, size_t cap, size_t *len);
+int pie_set_log_output(const char *path, size_t path_len);
+int pie_hash_bytes(const uint8_t *data, size_t data_len, uint8_t *result, size_t result_len);
+
+int pie_context_new(pie_context_t *ctx);
+int pie_context_cancel(pie_context_t ctx);
+
+int pie_config_load(const char *path, size_t path_len, pie_config_t *config);
+int pie_config_parse(const uint8_t *data, size_t data_len, const char *format, size_t format_len, pie_config_t *config);
+int pie_config_delete(pie_config_t config);
+int pie_set_resumption_file(const char *path, size_t path_len);
+
+int pie_cert_load(const uint8_t *cert_pem, size_t cert_pem_len, const uint8_t *key_pem, size_t key_pem_len,
+	uint8_t *cert_der, size_t cert_der_cap, size_t *cert_der_len, pie_cert_t *cert);
+int pie_cert_generate(uint8_t *cert_pem, size_t cert_pem_cap, size_t *cert_pem_len, uint8_t *key_pem, size_t key_pem_cap, size_t *key_pem_len,
+	uint8_t *cert_der, size_t cert_der_cap, size_t *cert_der_len, pie_cert_t *cert);
+int pie_identity_generate(const char *path, size_t path_len, const uint8_t *passphrase, size_t passphrase_len, int overwrite,
+	uint8_t *cert_der, size_t cert_der_cap, size_t *cert_der_len, pie_cert_t *cert);
+int pie_identity_load(const char *path, size_t path_len, const uint8_t *passphrase, size_t passphrase_len,
+	uint8_t *cert_der, size_t cert_der_cap, size_t *cert_der_len, pie_cert_t *cert);
+int pie_identity_change_passphrase(const char *path, size_t path_len, const uint8_t *old_passphrase, size_t old_passphrase_len,
+	const uint8_t *new_passphrase, size_t new_passphrase_len);
+int pie_link_device(pie_cert_t user_cert, const uint8_t *device_cert_der, size_t device_cert_der_len, const char *name, size_t name_len,
+	const uint8_t *addr_json, size_t addr_json_len, uint8_t *result, size_t result_cap, size_t *result_len);
+int pie_send_to_devices(pie_context_t ctx, const uint8_t *client_id, size_t client_id_len, pie_cert_t client_cert,
+	const uint8_t *user, size_t user_len, const uint8_t *request, size_t request_len, pie_config_t config, int64_t timeout_ms, int *delivered);
+int pie_send_to_devices_as_device(pie_context_t ctx, const uint8_t *client_id, size_t client_id_len, pie_cert_t device_cert,
+	const uint8_t *user_cert_der, size_t user_cert_der_len, const uint8_t *device, size_t device_len, const uint8_t *user, size_t user_len,
+	const uint8_t *request, size_t request_len, pie_config_t config, int64_t timeout_ms, int *delivered);
+int pie_revocation_new(pie_cert_t cert, const uint8_t *new_cert_der, size_t new_cert_der_len, uint8_t *result, size_t result_cap, size_t *result_len);
+int pie_revocation_add(const uint8_t *revocation, size_t revocation_len);
+int pie_cert_delete(pie_cert_t cert);
+
+int pie_listen_net(const char *addr, size_t addr_len, pie_cert_t cert, pie_config_t config, pie_server_t *server, int *port);
+int pie_server_accept_session(pie_context_t ctx, pie_server_t server, char *addr, size_t addr_cap, size_t *addr_len, pie_session_t *session);
+int pie_server_verify_client_cert(pie_server_t server, const uint8_t *client_cert_der, size_t client_cert_der_len, const uint8_t *server_cert_sign, size_t server_cert_sign_len);
+int pie_server_verify_device_cert(pie_server_t server, const uint8_t *client_cert_der, size_t client_cert_der_len,
+	const uint8_t *server_cert_sign, size_t server_cert_sign_len, const uint8_t *device, size_t device_len);
+int pie_server_close(pie_server_t server);
+
+int pie_connect_server(pie_context_t ctx, const uint8_t *client_id, size_t client_id_len, pie_cert_t client_cert, const char *addr, size_t addr_len,
+	const uint8_t *server_cert_der, size_t server_cert_der_len, pie_config_t config, pie_session_t *session);
+int pie_connect_server_as_device(pie_context_t ctx, const uint8_t *client_id, size_t client_id_len, pie_cert_t device_cert,
+	const uint8_t *user_cert_der, size_t user_cert_der_len, const uint8_t *device, size_t device_len, const char *addr, size_t addr_len,
+	const uint8_t *server_cert_der, size_t server_cert_der_len, pie_config_t config, pie_session_t *session);
+int pie_connect_tracker(pie_context_t ctx, const char *addr, size_t addr_len, uint8_t *id, size_t id_cap, pie_config_t config, pie_session_t *session);
+int pie_server_connect_tracker(pie_context_t ctx, pie_server_t server, const uint8_t *client_id, size_t client_id_len, pie_cert_t client_cert,
+	const char *addr, size_t addr_len, uint8_t *id, size_t id_cap, pie_session_t *session);
+int pie_server_request_punch(pie_context_t ctx, pie_server_t server, pie_session_t tracker, const uint8_t *peer_id, size_t peer_id_len,
+	int64_t timeout_ms, pie_session_t *session);
+int pie_server_handle_punch_notify(pie_context_t ctx, pie_server_t server, const uint8_t *notify, size_t notify_len);
+int pie_session_accept_stream(pie_context_t ctx, pie_session_t session, uint8_t *recv_buf, size_t recv_buf_cap, pie_stream_t *stream, int64_t *stream_id);
+int pie_session_open_stream(pie_session_t session, uint8_t *recv_buf, size_t recv_buf_cap, pie_stream_t *stream, int64_t *stream_id);
+int pie_session_call(pie_session_t session, const uint8_t *request, size_t request_len, int64_t timeout_ms,
+	uint8_t *result, size_t result_cap, size_t *result_len);
+int pie_session_close(pie_session_t session, uint64_t code);
+
+int pie_stream_recv_data(pie_stream_t stream, size_t *start, size_t *end);
+int pie_stream_send_data(pie_stream_t stream, const uint8_t *data, size_t data_len, int64_t timeout_ms);
+int pie_stream_send_message(pie_stream_t stream, const uint8_t *message, size_t message_len, int64_t timeout_ms);
+int pie_stream_recv_message(pie_stream_t stream, int64_t timeout_ms, uint8_t *result, size_t result_cap, size_t *result_len);
+int pie_stream_close(pie_stream_t stream);
+
+int pie_session_request_relay(pie_session_t tracker, const uint8_t *peer_id, size_t peer_id_len, pie_cert_t cert, int64_t timeout_ms,
+	pie_secure_stream_t *stream);
+int pie_stream_accept_relay(pie_stream_t stream, const uint8_t *req, size_t req_len, pie_cert_t cert, int64_t timeout_ms,
+	pie_secure_stream_t *secure_stream);
+int pie_secure_stream_send_message(pie_secure_stream_t stream, const uint8_t *message, size_t message_len, int64_t timeout_ms);
+int pie_secure_stream_recv_message(pie_secure_stream_t stream, int64_t timeout_ms, uint8_t *result, size_t result_cap, size_t *result_len);
+int pie_secure_stream_close(pie_secure_stream_t stream);
+
+int pie_event_queue_new(int size, pie_event_queue_t *queue);
+int pie_event_queue_set_callback(pie_event_queue_t queue, pie_event_callback callback, void *user_data);
+int pie_event_queue_poll(pie_event_queue_t queue, int64_t timeout_ms, uint8_t *data, size_t data_cap, pie_event *event);
+int pie_event_queue_watch_server(pie_event_queue_t queue, pie_server_t server);
+int pie_event_queue_watch_session(pie_event_queue_t queue, pie_session_t session);
+int pie_event_queue_watch_stream(pie_event_queue_t queue, pie_stream_t stream);
+int pie_event_queue_watch_table(pie_event_queue_t queue, pie_table_t table);
+int pie_event_queue_delete(pie_event_queue_t queue);
+
+int pie_table_new(const char *protocol, size_t protocol_len, pie_config_t config, pie_table_t *table);
+int pie_table_init(pie_context_t ctx, pie_table_t table, const uint8_t *addr_json, size_t addr_json_len);
+int pie_table_serve(pie_context_t ctx, pie_table_t table, pie_server_t server);
+int pie_table_find_tracker(pie_context_t ctx, pie_table_t table, const uint8_t *id, size_t id_len);
+int pie_table_get_neighbors(pie_table_t table, const uint8_t *id, size_t id_len, int num, uint8_t *result, size_t result_cap, size_t *result_len);
+int pie_table_find_resource(pie_context_t ctx, pie_table_t table, const uint8_t *id, size_t id_len, int32_t type, uint8_t *result, size_t result_cap, size_t *result_len);
+int pie_table_put_resource(pie_context_t ctx, pie_table_t table, const uint8_t *id, size_t id_len, int32_t type, const uint8_t *resource, size_t resource_len);
+int pie_table_put_revocation(pie_context_t ctx, pie_table_t table, const uint8_t *revocation, size_t revocation_len);
+int pie_table_resolve_cert(pie_context_t ctx, pie_table_t table, const uint8_t *cert_der, size_t cert_der_len,
+	uint8_t *result, size_t result_cap, size_t *result_len);
+int pie_table_delete(pie_table_t table);
+
+#endif
+
+#ifdef __cplusplus
+}
+#endif
+
+#endif
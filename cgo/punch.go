@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/Pie-Messaging/core/pie"
+	"github.com/Pie-Messaging/core/pie/pb"
+	"google.golang.org/protobuf/proto"
+)
+
+// #include <stdint.h>
+import "C"
+
+// ServerConnectTracker connects to a tracker from the listening socket of the server and authenticates with the client
+// cert, so that the tracker can tell peers the address the server is reachable at for hole punching.
+//
+//export ServerConnectTracker
+func ServerConnectTracker(ctxPtr C.uintptr_t, serverPtr C.uintptr_t, clientID []byte, clientCertPtr C.uintptr_t, addr string, idResult []byte) (sessionPtr C.uintptr_t, errType int) {
+	defer recoverPanic(&errType)
+	ctx, err := getContext(ctxPtr)
+	if err != nil {
+		return 0, fail(ctxPtr, err)
+	}
+	server, err := getHandle[*pie.Server](serverPtr)
+	if err != nil {
+		return 0, fail(serverPtr, err)
+	}
+	cert, err := getHandle[*tls.Certificate](clientCertPtr)
+	if err != nil {
+		return 0, fail(clientCertPtr, err)
+	}
+	tlsConfig := &tls.Config{
+		NextProtos:         []string{pie.UserTLSProto},
+		InsecureSkipVerify: true,
+	}
+	session, err := server.Dial(ctx, tlsConfig, addr)
+	if err != nil {
+		return 0, fail(serverPtr, err)
+	}
+	if err := session.SendCert(cert, clientID); err != nil {
+		session.Close(pie.SessErrNoReason)
+		return 0, fail(serverPtr, err)
+	}
+	copy(idResult, session.GetPeerIDByCertHash())
+	return newHandle(session), ENo
+}
+
+// ServerRequestPunch asks the tracker, connected with ServerConnectTracker, to notify the user with peerID and
+// returns the session dialed to it.
+//
+//export ServerRequestPunch
+func ServerRequestPunch(ctxPtr C.uintptr_t, serverPtr C.uintptr_t, trackerPtr C.uintptr_t, peerID []byte, timeout int64) (sessionPtr C.uintptr_t, errType int) {
+	defer recoverPanic(&errType)
+	ctx, err := getContext(ctxPtr)
+	if err != nil {
+		return 0, fail(ctxPtr, err)
+	}
+	server, err := getHandle[*pie.Server](serverPtr)
+	if err != nil {
+		return 0, fail(serverPtr, err)
+	}
+	tracker, err := getHandle[*pie.Session](trackerPtr)
+	if err != nil {
+		return 0, fail(trackerPtr, err)
+	}
+	session, err := server.RequestPunch(ctx, tracker, peerID, peerTLSConfig(peerID), timeoutOrDefault(server.Config, timeout))
+	if err != nil {
+		return 0, fail(serverPtr, err)
+	}
+	return newHandle(session), ENo
+}
+
+// ServerHandlePunchNotify dials the requester of the serialized pb.PunchNotify received from a tracker. The requester
+// keeps its own session, so its session arrives through AcceptSession.
+//
+//export ServerHandlePunchNotify
+func ServerHandlePunchNotify(ctxPtr C.uintptr_t, serverPtr C.uintptr_t, notify []byte) (errType int) {
+	defer recoverPanic(&errType)
+	ctx, err := getContext(ctxPtr)
+	if err != nil {
+		return fail(ctxPtr, err)
+	}
+	server, err := getHandle[*pie.Server](serverPtr)
+	if err != nil {
+		return fail(serverPtr, err)
+	}
+	punchNotify := &pb.PunchNotify{}
+	if err := proto.Unmarshal(notify, punchNotify); err != nil {
+		return fail(serverPtr, err)
+	}
+	if err := server.HandlePunchNotify(ctx, peerTLSConfig(punchNotify.Id), punchNotify); err != nil {
+		return fail(serverPtr, err)
+	}
+	return ENo
+}
+
+// peerTLSConfig dials a user holding the cert id hashes from.
+func peerTLSConfig(id []byte) *tls.Config {
+	return &tls.Config{
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 || !bytes.Equal(pie.HashBytes(rawCerts[0], pie.IDLen), id) {
+				return pie.ErrPeerMismatch
+			}
+			return nil
+		},
+		NextProtos:         []string{pie.UserTLSProto},
+		InsecureSkipVerify: true,
+	}
+}
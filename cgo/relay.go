@@ -0,0 +1,104 @@
+package main
+
+import (
+	"crypto/tls"
+	"github.com/Pie-Messaging/core/pie"
+	"github.com/Pie-Messaging/core/pie/pb"
+	"google.golang.org/protobuf/proto"
+)
+
+// #include <stdint.h>
+import "C"
+
+// SessionRequestRelay asks the tracker, connected with ServerConnectTracker, to relay to the user with peerID and
+// returns a stream encrypted end to end with the peer.
+//
+//export SessionRequestRelay
+func SessionRequestRelay(trackerPtr C.uintptr_t, peerID []byte, certPtr C.uintptr_t, timeout int64) (securePtr C.uintptr_t, errType int) {
+	defer recoverPanic(&errType)
+	tracker, err := getHandle[*pie.Session](trackerPtr)
+	if err != nil {
+		return 0, fail(trackerPtr, err)
+	}
+	cert, err := getHandle[*tls.Certificate](certPtr)
+	if err != nil {
+		return 0, fail(certPtr, err)
+	}
+	stream, err := pie.RequestRelay(tracker, peerID, cert, timeoutOrDefault(tracker.Config, timeout))
+	if err != nil {
+		return 0, fail(trackerPtr, err)
+	}
+	return newHandle(stream), ENo
+}
+
+// StreamAcceptRelay accepts the serialized pb.RelayAcceptReq received on the stream from a tracker. The stream handle
+// is replaced by the returned one, which closes the stream when closed.
+//
+//export StreamAcceptRelay
+func StreamAcceptRelay(streamPtr C.uintptr_t, req []byte, certPtr C.uintptr_t, timeout int64) (securePtr C.uintptr_t, errType int) {
+	defer recoverPanic(&errType)
+	cert, err := getHandle[*tls.Certificate](certPtr)
+	if err != nil {
+		return 0, fail(certPtr, err)
+	}
+	acceptReq := &pb.RelayAcceptReq{}
+	if err := proto.Unmarshal(req, acceptReq); err != nil {
+		return 0, fail(streamPtr, err)
+	}
+	stream, err := deleteHandle[*pie.Stream](streamPtr)
+	if err != nil {
+		return 0, fail(streamPtr, err)
+	}
+	secureStream, err := pie.AcceptRelay(stream, acceptReq, cert, timeoutOrDefault(nil, timeout))
+	if err != nil {
+		stream.Close()
+		return 0, fail(0, err)
+	}
+	return newHandle(secureStream), ENo
+}
+
+//export SecureStreamSendMessage
+func SecureStreamSendMessage(securePtr C.uintptr_t, message []byte, timeout int64) (errType int) {
+	defer recoverPanic(&errType)
+	stream, err := getHandle[*pie.SecureStream](securePtr)
+	if err != nil {
+		return fail(securePtr, err)
+	}
+	netMessage, err := parseNetMessage(message)
+	if err != nil {
+		return fail(securePtr, err)
+	}
+	if err := stream.SendMessage(netMessage, getDeadline(timeout)); err != nil {
+		return fail(securePtr, err)
+	}
+	return ENo
+}
+
+// SecureStreamRecvMessage receives like StreamRecvMessage, keeping a message longer than result as well.
+//
+//export SecureStreamRecvMessage
+func SecureStreamRecvMessage(securePtr C.uintptr_t, timeout int64, result []byte) (resultLen int, errType int) {
+	defer recoverPanic(&errType)
+	stream, err := getHandle[*pie.SecureStream](securePtr)
+	if err != nil {
+		return 0, fail(securePtr, err)
+	}
+	return recvPending(securePtr, nil, result, func() ([]byte, error) {
+		message, err := stream.RecvMessage(getDeadline(timeout))
+		if err != nil {
+			return nil, err
+		}
+		return proto.Marshal(message)
+	})
+}
+
+//export CloseSecureStream
+func CloseSecureStream(securePtr C.uintptr_t) (errType int) {
+	defer recoverPanic(&errType)
+	stream, err := deleteHandle[*pie.SecureStream](securePtr)
+	if err != nil {
+		return fail(securePtr, err)
+	}
+	stream.Close()
+	return ENo
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"crypto/tls"
+	"github.com/Pie-Messaging/core/pie"
+	"github.com/Pie-Messaging/core/pie/pb"
+	"github.com/Pie-Messaging/core/pie/routing"
+	"google.golang.org/protobuf/proto"
+)
+
+// #include <stdint.h>
+import "C"
+
+// NewRevocation writes a serialized pb.Revocation of the cert, rotating to newCertDER unless it is empty.
+//
+//export NewRevocation
+func NewRevocation(certPtr C.uintptr_t, newCertDER []byte, result []byte) (resultLen int, errType int) {
+	defer recoverPanic(&errType)
+	cert, err := getHandle[*tls.Certificate](certPtr)
+	if err != nil {
+		return 0, fail(certPtr, err)
+	}
+	revocation, err := pie.NewRevocation(cert, newCertDER)
+	if err != nil {
+		return 0, fail(certPtr, err)
+	}
+	return marshalResult(revocation, result)
+}
+
+// AddRevocation verifies a serialized pb.Revocation and makes servers refuse the revoked cert.
+//
+//export AddRevocation
+func AddRevocation(revocation []byte) (errType int) {
+	defer recoverPanic(&errType)
+	record, err := parseRevocation(revocation)
+	if err != nil {
+		return fail(0, err)
+	}
+	if err := pie.DefaultRevocations.Add(record); err != nil {
+		return fail(0, err)
+	}
+	return ENo
+}
+
+//export TablePutRevocation
+func TablePutRevocation(ctxPtr C.uintptr_t, tablePtr C.uintptr_t, revocation []byte) (errType int) {
+	defer recoverPanic(&errType)
+	ctx, err := getContext(ctxPtr)
+	if err != nil {
+		return fail(ctxPtr, err)
+	}
+	table, err := getHandle[*routing.Table](tablePtr)
+	if err != nil {
+		return fail(tablePtr, err)
+	}
+	record, err := parseRevocation(revocation)
+	if err != nil {
+		return fail(tablePtr, err)
+	}
+	if err := table.PutRevocation(ctx, record, getRecvTimeout(table)); err != nil {
+		return fail(tablePtr, err)
+	}
+	return ENo
+}
+
+// TableResolveCert writes the current certificate of the identity of certDER, following rotations.
+//
+//export TableResolveCert
+func TableResolveCert(ctxPtr C.uintptr_t, tablePtr C.uintptr_t, certDER []byte, result []byte) (resultLen int, errType int) {
+	defer recoverPanic(&errType)
+	ctx, err := getContext(ctxPtr)
+	if err != nil {
+		return 0, fail(ctxPtr, err)
+	}
+	table, err := getHandle[*routing.Table](tablePtr)
+	if err != nil {
+		return 0, fail(tablePtr, err)
+	}
+	current, err := table.ResolveCert(ctx, certDER, getRecvTimeout(table))
+	if err != nil {
+		return 0, fail(tablePtr, err)
+	}
+	if len(current) > len(result) {
+		return len(current), EMsgTooLong
+	}
+	copy(result, current)
+	return len(current), ENo
+}
+
+func parseRevocation(data []byte) (*pb.Revocation, error) {
+	revocation := &pb.Revocation{}
+	if err := proto.Unmarshal(data, revocation); err != nil {
+		return nil, err
+	}
+	return revocation, nil
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/Pie-Messaging/core/pie"
+	"github.com/Pie-Messaging/core/pie/pb"
+	"github.com/Pie-Messaging/core/pie/routing"
+	"google.golang.org/protobuf/proto"
+	"time"
+)
+
+// #include <stdint.h>
+// #include <sys/types.h>
+import "C"
+
+//export NewTable
+func NewTable(protocol string, configPtr C.uintptr_t) (tablePtr C.uintptr_t, errType int) {
+	defer recoverPanic(&errType)
+	config, err := getConfig(configPtr)
+	if err != nil {
+		return 0, fail(configPtr, err)
+	}
+	if config == nil {
+		config = pie.DefaultConfig()
+	}
+	return newHandle(&routing.Table{Protocol: protocol, Config: config}), ENo
+}
+
+// TableInit bootstraps from a JSON array of tracker addresses, or from the config when it is empty.
+//
+//export TableInit
+func TableInit(ctxPtr C.uintptr_t, tablePtr C.uintptr_t, addrList []byte) (errType int) {
+	defer recoverPanic(&errType)
+	ctx, err := getContext(ctxPtr)
+	if err != nil {
+		return fail(ctxPtr, err)
+	}
+	table, err := getHandle[*routing.Table](tablePtr)
+	if err != nil {
+		return fail(tablePtr, err)
+	}
+	bootstrap := table.Config.BootstrapTrackers
+	if len(addrList) != 0 {
+		if bootstrap, err = parseAddrList(addrList); err != nil {
+			return fail(tablePtr, err)
+		}
+	}
+	table.Init(ctx, routing.NewBootstrapTrackers(bootstrap))
+	return ENo
+}
+
+// TableServe answers the routing requests, and the punch requests of the users that authenticated, on the sessions
+// accepted by server until ctx is canceled or server is closed. The table must be initialized.
+//
+//export TableServe
+func TableServe(ctxPtr C.uintptr_t, tablePtr C.uintptr_t, serverPtr C.uintptr_t) (errType int) {
+	defer recoverPanic(&errType)
+	ctx, err := getContext(ctxPtr)
+	if err != nil {
+		return fail(ctxPtr, err)
+	}
+	table, err := getHandle[*routing.Table](tablePtr)
+	if err != nil {
+		return fail(tablePtr, err)
+	}
+	server, err := getHandle[*pie.Server](serverPtr)
+	if err != nil {
+		return fail(serverPtr, err)
+	}
+	go table.Serve(ctx, server)
+	return ENo
+}
+
+//export TableFindTracker
+func TableFindTracker(ctxPtr C.uintptr_t, tablePtr C.uintptr_t, id []byte) (errType int) {
+	defer recoverPanic(&errType)
+	if len(id) != pie.IDLen {
+		return EInvalidArg
+	}
+	ctx, err := getContext(ctxPtr)
+	if err != nil {
+		return fail(ctxPtr, err)
+	}
+	table, err := getHandle[*routing.Table](tablePtr)
+	if err != nil {
+		return fail(tablePtr, err)
+	}
+	table.FindTracker(ctx, pie.BytesToIDA(id), table.Config.Routing.Alpha, getRecvTimeout(table))
+	return ENo
+}
+
+// TableGetNeighbors writes a serialized pb.FindTrackerRes holding up to num trackers closest to id.
+//
+//export TableGetNeighbors
+func TableGetNeighbors(tablePtr C.uintptr_t, id []byte, num int, result []byte) (resultLen int, errType int) {
+	defer recoverPanic(&errType)
+	if len(id) != pie.IDLen {
+		return 0, EInvalidArg
+	}
+	table, err := getHandle[*routing.Table](tablePtr)
+	if err != nil {
+		return 0, fail(tablePtr, err)
+	}
+	neighbors := table.GetNeighbors(pie.BytesToIDA(id), num)
+	findTrackerRes := &pb.FindTrackerRes{Status: pb.Status_OK, Candidates: make([]*pb.Tracker, 0, len(neighbors))}
+	for _, tracker := range neighbors {
+		findTrackerRes.Candidates = append(findTrackerRes.Candidates, tracker.Proto())
+	}
+	return marshalResult(findTrackerRes, result)
+}
+
+// TableFindResource writes the serialized pb.Resource stored under id. A resource longer than result is kept, and its
+// length returned with EMsgTooLong, until a call for the same id and type passes a large enough result.
+//
+//export TableFindResource
+func TableFindResource(ctxPtr C.uintptr_t, tablePtr C.uintptr_t, id []byte, resourceType int32, result []byte) (resultLen int, errType int) {
+	defer recoverPanic(&errType)
+	if len(id) != pie.IDLen {
+		return 0, EInvalidArg
+	}
+	ctx, err := getContext(ctxPtr)
+	if err != nil {
+		return 0, fail(ctxPtr, err)
+	}
+	table, err := getHandle[*routing.Table](tablePtr)
+	if err != nil {
+		return 0, fail(tablePtr, err)
+	}
+	// The lookup is the request a kept resource answers
+	request, err := proto.Marshal(&pb.FindResourceReq{Id: id, Type: pb.ResourceType(resourceType)})
+	if err != nil {
+		return 0, fail(tablePtr, err)
+	}
+	return recvPending(tablePtr, request, result, func() ([]byte, error) {
+		resource, err := table.FindResource(ctx, pie.BytesToIDA(id), pb.ResourceType(resourceType), table.Config.Routing.Alpha, getRecvTimeout(table))
+		if err != nil {
+			return nil, err
+		}
+		return proto.Marshal(resource)
+	})
+}
+
+// TablePutResource stores a serialized pb.Resource under id.
+//
+//export TablePutResource
+func TablePutResource(ctxPtr C.uintptr_t, tablePtr C.uintptr_t, id []byte, resourceType int32, resourceData []byte) (errType int) {
+	defer recoverPanic(&errType)
+	if len(id) != pie.IDLen {
+		return EInvalidArg
+	}
+	ctx, err := getContext(ctxPtr)
+	if err != nil {
+		return fail(ctxPtr, err)
+	}
+	table, err := getHandle[*routing.Table](tablePtr)
+	if err != nil {
+		return fail(tablePtr, err)
+	}
+	resource := &pb.Resource{}
+	if err := proto.Unmarshal(resourceData, resource); err != nil {
+		pie.Logger.Println("Failed to unmarshal resource:", err)
+		return fail(tablePtr, err)
+	}
+	redundancy := table.Config.Routing.MetaDataRedundancy
+	err = table.PutResource(ctx, pie.BytesToIDA(id), pb.ResourceType(resourceType), resource, redundancy, getRecvTimeout(table))
+	if err != nil {
+		return fail(tablePtr, err)
+	}
+	return ENo
+}
+
+//export DeleteTable
+func DeleteTable(tablePtr C.uintptr_t) (errType int) {
+	defer recoverPanic(&errType)
+	table, err := deleteHandle[*routing.Table](tablePtr)
+	if err != nil {
+		return fail(tablePtr, err)
+	}
+	table.Close()
+	return ENo
+}
+
+func getRecvTimeout(table *routing.Table) time.Duration {
+	return time.Duration(table.Config.Routing.RecvTimeout)
+}
+
+// parseAddrList parses a JSON array of addresses; an empty input is an empty list.
+func parseAddrList(data []byte) ([]string, error) {
+	var addrList []string
+	if len(data) == 0 {
+		return nil, nil
+	}
+	if err := json.Unmarshal(data, &addrList); err != nil {
+		pie.Logger.Println("Failed to unmarshal addresses:", err)
+		return nil, err
+	}
+	return addrList, nil
+}
+
+// marshalResult returns the serialized length, which is also the required size when result is too short.
+func marshalResult(message proto.Message, result []byte) (int, int) {
+	data, err := proto.Marshal(message)
+	if err != nil {
+		pie.Logger.Println("Failed to marshal message:", err)
+		return 0, fail(0, err)
+	}
+	if len(data) > len(result) {
+		return len(data), EMsgTooLong
+	}
+	copy(result, data)
+	return len(data), ENo
+}
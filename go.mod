@@ -3,9 +3,11 @@ module github.com/Pie-Messaging/core
 go 1.18
 
 require (
+	github.com/BurntSushi/toml v1.1.0
 	github.com/lucas-clemente/quic-go v0.26.0
 	golang.org/x/crypto v0.0.0-20220321153916-2c7772ba3064
 	google.golang.org/protobuf v1.28.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
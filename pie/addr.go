@@ -0,0 +1,142 @@
+package pie
+
+import (
+	"github.com/Pie-Messaging/core/pie/pb"
+	"net"
+	"sync"
+	"time"
+)
+
+// NATReport holds the addresses several peers observed for us.
+// Consistent means they all saw the same address, i.e. the NAT mapping does not depend on the destination.
+// See QueryAddr for when ports are compared.
+type NATReport struct {
+	Observed   []string
+	Consistent bool
+}
+
+// HandleGetAddrReq answers with the address the request came from, followed by listenAddrList.
+func (s *Session) HandleGetAddrReq(stream *Stream, listenAddrList ...string) error {
+	return stream.SendMessage(&pb.NetMessage{Body: &pb.NetMessage_GetAddrRes{GetAddrRes: &pb.GetAddrRes{
+		Addresses: appendUnique([]string{s.Session.RemoteAddr().String()}, listenAddrList...),
+	}}})
+}
+
+func (s *Server) HandleGetAddrReq(session *Session, stream *Stream) error {
+	return session.HandleGetAddrReq(stream, s.ListenAddrs()...)
+}
+
+// ListenAddrs expands an unspecified listening IP to the addresses of the local interfaces.
+func (s *Server) ListenAddrs() []string {
+	addr, ok := s.Listener.Addr().(*net.UDPAddr)
+	if !ok {
+		return []string{s.Listener.Addr().String()}
+	}
+	if !addr.IP.IsUnspecified() {
+		return []string{addr.String()}
+	}
+	return LocalAddrs(addr.Port)
+}
+
+// GetAddr asks the peer for our address as it observes it, followed by any addresses the peer adds.
+func (s *Session) GetAddr(recvTimeout time.Duration) ([]string, error) {
+	stream, err := s.OpenStream()
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+	err = stream.SendMessage(&pb.NetMessage{Body: &pb.NetMessage_GetAddrReq{GetAddrReq: &pb.GetAddrReq{}}})
+	if err != nil {
+		return nil, err
+	}
+	message, err := stream.RecvMessage(time.Now().Add(recvTimeout))
+	if err != nil {
+		return nil, err
+	}
+	getAddrRes := message.GetGetAddrRes()
+	if getAddrRes == nil || len(getAddrRes.Addresses) == 0 {
+		Logger.Println("Failed to get address: unexpected response")
+		return nil, ErrInvalidMsg
+	}
+	return getAddrRes.Addresses, nil
+}
+
+// PublicAddrs returns the addresses the peers of sessions observe for the listening socket, i.e. its reflexive
+// addresses behind a NAT, followed by the public addresses it listens on. Only the sessions dialed from the listening
+// socket are queried, since the others are observed at ports that do not reach the server; ErrSocketMismatch is
+// returned if there is none.
+func (s *Server) PublicAddrs(sessions []*Session, recvTimeout time.Duration) ([]string, error) {
+	var queried []*Session
+	for _, session := range sessions {
+		if session.Session.LocalAddr().String() == s.Listener.Addr().String() {
+			queried = append(queried, session)
+		}
+	}
+	if len(queried) == 0 {
+		return nil, ErrSocketMismatch
+	}
+	report, err := QueryAddr(queried, recvTimeout)
+	if err != nil {
+		return nil, err
+	}
+	addrs := appendUnique(nil, report.Observed...)
+	for _, addr := range s.ListenAddrs() {
+		if host, _, err := net.SplitHostPort(addr); err == nil && isPublic(net.ParseIP(host)) {
+			addrs = appendUnique(addrs, addr)
+		}
+	}
+	return addrs, nil
+}
+
+// QueryAddr asks every session for our observed address in parallel. Sessions dialed with pie.Connect each use a
+// socket of their own, so the ports they are observed at differ without any NAT. Ports are thus only compared when
+// all sessions share one local address, such as the sessions dialed with Server.Dial; otherwise only the IPs are.
+func QueryAddr(sessions []*Session, recvTimeout time.Duration) (*NATReport, error) {
+	observed := make([]string, len(sessions))
+	sameSocket := true
+	wg := &sync.WaitGroup{}
+	for i, session := range sessions {
+		i, session := i, session
+		if session.Session.LocalAddr().String() != sessions[0].Session.LocalAddr().String() {
+			sameSocket = false
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			addrList, err := session.GetAddr(recvTimeout)
+			if err != nil {
+				return
+			}
+			observed[i] = addrList[0]
+		}()
+	}
+	wg.Wait()
+	report := &NATReport{Consistent: true}
+	compared := ""
+	for _, addr := range observed {
+		if addr == "" {
+			continue
+		}
+		key := addr
+		if !sameSocket {
+			if host, _, err := net.SplitHostPort(addr); err == nil {
+				key = host
+			}
+		}
+		if len(report.Observed) == 0 {
+			compared = key
+		} else if key != compared {
+			report.Consistent = false
+		}
+		report.Observed = append(report.Observed, addr)
+	}
+	if len(report.Observed) == 0 {
+		return nil, ErrNoAddr
+	}
+	return report, nil
+}
+
+// isPublic reports whether ip is a global unicast address outside the private ranges of RFC 1918 and RFC 4193.
+func isPublic(ip net.IP) bool {
+	return ip != nil && ip.IsGlobalUnicast() && !ip.IsPrivate()
+}
@@ -48,3 +48,16 @@ func GenerateKeyPair() (*tls.Certificate, []byte, []byte, error) {
 	}
 	return &certificate, certPEM, privateKeyPEM, nil
 }
+
+// GenerateTrackerKeyPair generates key pairs until the ID of the cert has at least difficulty bits of proof of work.
+func GenerateTrackerKeyPair(difficulty int) (*tls.Certificate, []byte, []byte, error) {
+	for {
+		cert, certPEM, keyPEM, err := GenerateKeyPair()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if BytesToIDA(HashBytes(cert.Certificate[0], IDLen)).Difficulty() >= difficulty {
+			return cert, certPEM, keyPEM, nil
+		}
+	}
+}
@@ -1,5 +1,20 @@
 package pie
 
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"github.com/BurntSushi/toml"
+	"github.com/lucas-clemente/quic-go"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
 const (
 	KSize = 24
 	Alpha = 3
@@ -7,6 +22,7 @@ const (
 
 const (
 	IDLen             = KSize
+	IDBits            = IDLen * 8
 	UserCertHashLen   = 20
 	ServerCertHashLen = 50
 )
@@ -24,3 +40,280 @@ const (
 	UserTLSProto    = "pie-q-u-1"
 	TrackerTLSProto = "pie-q-t-1"
 )
+
+const (
+	EnvPrefix = "PIE_"
+)
+
+var (
+	ErrConfigFormat = errors.New("unknown config format")
+	// ErrConfigKind is returned for an environment override of a field whose kind cannot be parsed
+	ErrConfigKind = errors.New("unsupported config field kind")
+	// ErrKeepAlivePeriod is returned for a config setting both KeepAlivePeriod and MaxIdleTimeout
+	ErrKeepAlivePeriod = errors.New("keep_alive_period and max_idle_timeout are mutually exclusive")
+)
+
+type Duration time.Duration
+
+type Config struct {
+	// ListenAddr are tried in turn when ListenNet is given no address, and BootstrapTrackers when TableInit is given
+	// no trackers
+	ListenAddr        []string      `json:"listen_addr" yaml:"listen_addr" toml:"listen_addr" env:"LISTEN_ADDR"`
+	BootstrapTrackers []string      `json:"bootstrap_trackers" yaml:"bootstrap_trackers" toml:"bootstrap_trackers" env:"BOOTSTRAP_TRACKERS"`
+	CertPath          string        `json:"cert_path" yaml:"cert_path" toml:"cert_path" env:"CERT_PATH"`
+	KeyPath           string        `json:"key_path" yaml:"key_path" toml:"key_path" env:"KEY_PATH"`
+	MaxMessageLen     int           `json:"max_message_len" yaml:"max_message_len" toml:"max_message_len" env:"MAX_MESSAGE_LEN"`
+	QUIC              QUICConfig    `json:"quic" yaml:"quic" toml:"quic" env:"QUIC_"`
+	Routing           RoutingConfig `json:"routing" yaml:"routing" toml:"routing" env:"ROUTING_"`
+	// Transport defaults to QUICTransport; it is set in code only, e.g. to an in-memory network in tests
+	Transport Transport `json:"-" yaml:"-" toml:"-"`
+}
+
+type QUICConfig struct {
+	HandshakeIdleTimeout  Duration `json:"handshake_idle_timeout" yaml:"handshake_idle_timeout" toml:"handshake_idle_timeout" env:"HANDSHAKE_IDLE_TIMEOUT"`
+	MaxIdleTimeout        Duration `json:"max_idle_timeout" yaml:"max_idle_timeout" toml:"max_idle_timeout" env:"MAX_IDLE_TIMEOUT"`
+	MaxIncomingStreams    int64    `json:"max_incoming_streams" yaml:"max_incoming_streams" toml:"max_incoming_streams" env:"MAX_INCOMING_STREAMS"`
+	MaxIncomingUniStreams int64    `json:"max_incoming_uni_streams" yaml:"max_incoming_uni_streams" toml:"max_incoming_uni_streams" env:"MAX_INCOMING_UNI_STREAMS"`
+	KeepAlive             bool     `json:"keep_alive" yaml:"keep_alive" toml:"keep_alive" env:"KEEP_ALIVE"`
+	// KeepAlivePeriod enables KeepAlive with MaxIdleTimeout set to twice the period, since quic-go pings at half of
+	// the idle timeout, but never less often than every 20 seconds. It cannot be combined with MaxIdleTimeout.
+	KeepAlivePeriod                Duration `json:"keep_alive_period" yaml:"keep_alive_period" toml:"keep_alive_period" env:"KEEP_ALIVE_PERIOD"`
+	InitialStreamReceiveWindow     uint64   `json:"initial_stream_receive_window" yaml:"initial_stream_receive_window" toml:"initial_stream_receive_window" env:"INITIAL_STREAM_RECEIVE_WINDOW"`
+	MaxStreamReceiveWindow         uint64   `json:"max_stream_receive_window" yaml:"max_stream_receive_window" toml:"max_stream_receive_window" env:"MAX_STREAM_RECEIVE_WINDOW"`
+	InitialConnectionReceiveWindow uint64   `json:"initial_connection_receive_window" yaml:"initial_connection_receive_window" toml:"initial_connection_receive_window" env:"INITIAL_CONNECTION_RECEIVE_WINDOW"`
+	MaxConnectionReceiveWindow     uint64   `json:"max_connection_receive_window" yaml:"max_connection_receive_window" toml:"max_connection_receive_window" env:"MAX_CONNECTION_RECEIVE_WINDOW"`
+	Enable0RTT                     bool     `json:"enable_0rtt" yaml:"enable_0rtt" toml:"enable_0rtt" env:"ENABLE_0RTT"`
+}
+
+type RoutingConfig struct {
+	KSize              int      `json:"k_size" yaml:"k_size" toml:"k_size" env:"K_SIZE"`
+	Alpha              int      `json:"alpha" yaml:"alpha" toml:"alpha" env:"ALPHA"`
+	MetaDataRedundancy int      `json:"meta_data_redundancy" yaml:"meta_data_redundancy" toml:"meta_data_redundancy" env:"META_DATA_REDUNDANCY"`
+	RecvTimeout        Duration `json:"recv_timeout" yaml:"recv_timeout" toml:"recv_timeout" env:"RECV_TIMEOUT"`
+	// A tracker that failed is not dialed again before ReconnectDelay, doubled up to MaxReconnectDelay on each
+	// failure, and removed after MaxFailures consecutive failures
+	ReconnectDelay    Duration `json:"reconnect_delay" yaml:"reconnect_delay" toml:"reconnect_delay" env:"RECONNECT_DELAY"`
+	MaxReconnectDelay Duration `json:"max_reconnect_delay" yaml:"max_reconnect_delay" toml:"max_reconnect_delay" env:"MAX_RECONNECT_DELAY"`
+	MaxFailures       int      `json:"max_failures" yaml:"max_failures" toml:"max_failures" env:"MAX_FAILURES"`
+	// A tracker that reported MaxMisreports candidates holding another cert than their ID claims is removed, and not
+	// added again before BanTime
+	MaxMisreports int      `json:"max_misreports" yaml:"max_misreports" toml:"max_misreports" env:"MAX_MISREPORTS"`
+	BanTime       Duration `json:"ban_time" yaml:"ban_time" toml:"ban_time" env:"BAN_TIME"`
+	// MaxSessions caps the open tracker sessions, closing the least recently used ones; 0 disables the cap
+	MaxSessions int `json:"max_sessions" yaml:"max_sessions" toml:"max_sessions" env:"MAX_SESSIONS"`
+	// MaxSubnetTrackers caps the trackers of one IPv4 /24 or IPv6 /48 in each bucket, so that a single host cannot
+	// fill the table with IDs it generated; 0 disables the cap. It only limits which trackers are stored, and lookups
+	// still query the others. The default of 2 suits trackers on the internet; a network of trackers sharing a subnet,
+	// such as a LAN, needs a higher cap or 0.
+	MaxSubnetTrackers int `json:"max_subnet_trackers" yaml:"max_subnet_trackers" toml:"max_subnet_trackers" env:"MAX_SUBNET_TRACKERS"`
+	// IDDifficulty is the proof of work tracker IDs need to be added, see IDA.Difficulty; 0 disables it
+	IDDifficulty int `json:"id_difficulty" yaml:"id_difficulty" toml:"id_difficulty" env:"ID_DIFFICULTY"`
+	// DisjointPaths is the number of lookup paths that never share a tracker, so that one path through honest
+	// trackers finds the target even if others run into malicious ones
+	DisjointPaths int `json:"disjoint_paths" yaml:"disjoint_paths" toml:"disjoint_paths" env:"DISJOINT_PATHS"`
+	// MaxResources caps the resources the tracker stores for peers, refusing new ones once reached; 0 disables the cap
+	MaxResources int `json:"max_resources" yaml:"max_resources" toml:"max_resources" env:"MAX_RESOURCES"`
+	// StorePath is the file the known trackers are saved to on close and loaded from on init; empty disables it
+	StorePath string `json:"store_path" yaml:"store_path" toml:"store_path" env:"STORE_PATH"`
+	// EnableRelay makes the tracker relay streams between the users registered with it. Each user may relay
+	// RelayQuota bytes per RelayQuotaWindow, where 0 means unlimited, and relays idle for RelayIdleTimeout are closed.
+	EnableRelay      bool     `json:"enable_relay" yaml:"enable_relay" toml:"enable_relay" env:"ENABLE_RELAY"`
+	RelayQuota       int64    `json:"relay_quota" yaml:"relay_quota" toml:"relay_quota" env:"RELAY_QUOTA"`
+	RelayQuotaWindow Duration `json:"relay_quota_window" yaml:"relay_quota_window" toml:"relay_quota_window" env:"RELAY_QUOTA_WINDOW"`
+	RelayIdleTimeout Duration `json:"relay_idle_timeout" yaml:"relay_idle_timeout" toml:"relay_idle_timeout" env:"RELAY_IDLE_TIMEOUT"`
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		MaxMessageLen: MaxMessageLen,
+		QUIC: QUICConfig{
+			KeepAlive:  true,
+			Enable0RTT: true,
+		},
+		Routing: RoutingConfig{
+			KSize:              KSize,
+			Alpha:              Alpha,
+			MetaDataRedundancy: MetaDataRedundancy,
+			RecvTimeout:        Duration(10 * time.Second),
+			ReconnectDelay:     Duration(time.Second),
+			MaxReconnectDelay:  Duration(time.Minute),
+			MaxFailures:        5,
+			MaxMisreports:      3,
+			BanTime:            Duration(time.Hour),
+			MaxSessions:        128,
+			MaxSubnetTrackers:  2,
+			DisjointPaths:      2,
+			MaxResources:       1 << 16,
+			RelayQuota:         64 << 20,
+			RelayQuotaWindow:   Duration(time.Hour),
+			RelayIdleTimeout:   Duration(time.Minute),
+		},
+	}
+}
+
+// LoadConfig reads a JSON, YAML or TOML file chosen by extension on top of DefaultConfig,
+// then applies PIE_* environment variables. An empty path only applies the environment.
+func LoadConfig(path string) (*Config, error) {
+	if path == "" {
+		return ParseConfig(nil, "")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		Logger.Println("Failed to read config:", err)
+		return nil, err
+	}
+	return ParseConfig(data, strings.TrimPrefix(filepath.Ext(path), "."))
+}
+
+func ParseConfig(data []byte, format string) (*Config, error) {
+	config := DefaultConfig()
+	if len(data) != 0 {
+		var err error
+		switch strings.ToLower(format) {
+		case "json":
+			err = json.Unmarshal(data, config)
+		case "yaml", "yml":
+			err = yaml.Unmarshal(data, config)
+		case "toml":
+			err = toml.Unmarshal(data, config)
+		default:
+			err = ErrConfigFormat
+		}
+		if err != nil {
+			Logger.Println("Failed to parse config:", err)
+			return nil, err
+		}
+	}
+	if err := applyEnv(reflect.ValueOf(config).Elem(), EnvPrefix); err != nil {
+		Logger.Println("Failed to apply config environment:", err)
+		return nil, err
+	}
+	if config.QUIC.KeepAlivePeriod > 0 && config.QUIC.MaxIdleTimeout > 0 {
+		Logger.Println("Failed to parse config:", ErrKeepAlivePeriod)
+		return nil, ErrKeepAlivePeriod
+	}
+	return config, nil
+}
+
+func (c *Config) Certificate() (*tls.Certificate, error) {
+	certPEM, err := os.ReadFile(c.CertPath)
+	if err != nil {
+		Logger.Println("Failed to read certificate:", err)
+		return nil, err
+	}
+	keyPEM, err := os.ReadFile(c.KeyPath)
+	if err != nil {
+		Logger.Println("Failed to read private key:", err)
+		return nil, err
+	}
+	return X509KeyPair(certPEM, keyPEM)
+}
+
+func (c *Config) transport() Transport {
+	if c.Transport == nil {
+		return QUICTransport{}
+	}
+	return c.Transport
+}
+
+func (c *QUICConfig) Build() *quic.Config {
+	config := &quic.Config{
+		HandshakeIdleTimeout:           time.Duration(c.HandshakeIdleTimeout),
+		MaxIdleTimeout:                 time.Duration(c.MaxIdleTimeout),
+		MaxIncomingStreams:             c.MaxIncomingStreams,
+		MaxIncomingUniStreams:          c.MaxIncomingUniStreams,
+		KeepAlive:                      c.KeepAlive,
+		InitialStreamReceiveWindow:     c.InitialStreamReceiveWindow,
+		MaxStreamReceiveWindow:         c.MaxStreamReceiveWindow,
+		InitialConnectionReceiveWindow: c.InitialConnectionReceiveWindow,
+		MaxConnectionReceiveWindow:     c.MaxConnectionReceiveWindow,
+	}
+	// quic-go sends keep-alive pings at half of the idle timeout. ParseConfig rejects setting both, and a config built in
+	// code keeps its MaxIdleTimeout.
+	if c.KeepAlivePeriod > 0 {
+		config.KeepAlive = true
+		if config.MaxIdleTimeout == 0 {
+			config.MaxIdleTimeout = 2 * time.Duration(c.KeepAlivePeriod)
+		}
+	}
+	return config
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	duration, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(duration)
+	return nil
+}
+
+func applyEnv(v reflect.Value, prefix string) error {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		name, ok := v.Type().Field(i).Tag.Lookup("env")
+		if !ok {
+			continue
+		}
+		if field.Kind() == reflect.Struct {
+			if err := applyEnv(field, prefix+name); err != nil {
+				return err
+			}
+			continue
+		}
+		value, ok := os.LookupEnv(prefix + name)
+		if !ok {
+			continue
+		}
+		if err := setEnvValue(field, value); err != nil {
+			return errors.New(prefix + name + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
+func setEnvValue(field reflect.Value, value string) error {
+	if unmarshaler, ok := field.Addr().Interface().(interface{ UnmarshalText([]byte) error }); ok {
+		return unmarshaler.UnmarshalText([]byte(value))
+	}
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Uint, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetUint(n)
+	case reflect.Slice:
+		if field.Type().Elem().Kind() != reflect.String {
+			return ErrConfigKind
+		}
+		var list []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		field.Set(reflect.ValueOf(list))
+	default:
+		return ErrConfigKind
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package pie
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseConfig(t *testing.T) {
+	tests := []struct {
+		format string
+		data   string
+	}{
+		{format: "json", data: `{"listen_addr": [":7000"], "quic": {"max_idle_timeout": "30s"}, "routing": {"k_size": 8}}`},
+		{format: "yaml", data: "listen_addr: [\":7000\"]\nquic:\n  max_idle_timeout: 30s\nrouting:\n  k_size: 8\n"},
+		{format: "toml", data: "listen_addr = [\":7000\"]\n[quic]\nmax_idle_timeout = \"30s\"\n[routing]\nk_size = 8\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.format, func(t *testing.T) {
+			config, err := ParseConfig([]byte(test.data), test.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(config.ListenAddr) != 1 || config.ListenAddr[0] != ":7000" {
+				t.Errorf("ListenAddr = %v, want [:7000]", config.ListenAddr)
+			}
+			if config.QUIC.MaxIdleTimeout != Duration(30*time.Second) || config.Routing.KSize != 8 {
+				t.Errorf("MaxIdleTimeout = %v, KSize = %d, want 30s, 8", time.Duration(config.QUIC.MaxIdleTimeout), config.Routing.KSize)
+			}
+			// Fields missing from the file keep their defaults
+			if config.Routing.Alpha != Alpha || !config.QUIC.KeepAlive {
+				t.Errorf("Alpha = %d, KeepAlive = %v, want the defaults", config.Routing.Alpha, config.QUIC.KeepAlive)
+			}
+		})
+	}
+	if _, err := ParseConfig([]byte("k_size = 8"), "ini"); err != ErrConfigFormat {
+		t.Errorf("ParseConfig() of an unknown format = %v, want %v", err, ErrConfigFormat)
+	}
+}
+
+func TestConfigEnv(t *testing.T) {
+	t.Setenv(EnvPrefix+"BOOTSTRAP_TRACKERS", "10.0.0.1:7000, 10.0.0.2:7000")
+	t.Setenv(EnvPrefix+"QUIC_KEEP_ALIVE", "false")
+	t.Setenv(EnvPrefix+"QUIC_MAX_STREAM_RECEIVE_WINDOW", "1048576")
+	t.Setenv(EnvPrefix+"ROUTING_K_SIZE", "4")
+	t.Setenv(EnvPrefix+"ROUTING_RECV_TIMEOUT", "2s")
+	config, err := ParseConfig([]byte(`{"routing": {"k_size": 8}}`), "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.BootstrapTrackers) != 2 || config.BootstrapTrackers[1] != "10.0.0.2:7000" {
+		t.Errorf("BootstrapTrackers = %v, want both trackers", config.BootstrapTrackers)
+	}
+	if config.QUIC.KeepAlive || config.QUIC.MaxStreamReceiveWindow != 1<<20 {
+		t.Errorf("KeepAlive = %v, MaxStreamReceiveWindow = %d, want false, %d", config.QUIC.KeepAlive, config.QUIC.MaxStreamReceiveWindow, 1<<20)
+	}
+	// The environment overrides the file
+	if config.Routing.KSize != 4 || config.Routing.RecvTimeout != Duration(2*time.Second) {
+		t.Errorf("KSize = %d, RecvTimeout = %v, want 4, 2s", config.Routing.KSize, time.Duration(config.Routing.RecvTimeout))
+	}
+
+	t.Setenv(EnvPrefix+"ROUTING_K_SIZE", "four")
+	if _, err := ParseConfig(nil, ""); err == nil {
+		t.Error("ParseConfig() with a malformed override = nil error")
+	}
+}
+
+func TestSetEnvValueKind(t *testing.T) {
+	var value struct {
+		Float float64
+		Ints  []int
+	}
+	fields := reflect.ValueOf(&value).Elem()
+	for i := 0; i < fields.NumField(); i++ {
+		if err := setEnvValue(fields.Field(i), "1"); err != ErrConfigKind {
+			t.Errorf("setEnvValue() of a %v = %v, want %v", fields.Field(i).Type(), err, ErrConfigKind)
+		}
+	}
+}
+
+func TestKeepAlivePeriod(t *testing.T) {
+	config, err := ParseConfig([]byte(`{"quic": {"keep_alive_period": "10s"}}`), "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quicConfig := config.QUIC.Build(); !quicConfig.KeepAlive || quicConfig.MaxIdleTimeout != 20*time.Second {
+		t.Errorf("Build() = keep-alive %v, idle timeout %v, want true, 20s", quicConfig.KeepAlive, quicConfig.MaxIdleTimeout)
+	}
+	_, err = ParseConfig([]byte(`{"quic": {"keep_alive_period": "10s", "max_idle_timeout": "1m"}}`), "json")
+	if err != ErrKeepAlivePeriod {
+		t.Errorf("ParseConfig() with both = %v, want %v", err, ErrKeepAlivePeriod)
+	}
+}
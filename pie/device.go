@@ -0,0 +1,135 @@
+package pie
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/Pie-Messaging/core/pie/pb"
+	"sync"
+	"time"
+)
+
+// DeviceSignPrefix separates device link signatures from the server cert signatures made with the same key.
+const DeviceSignPrefix = "pie device link:"
+
+// Device is one installation of a user identity: its own certificate, linked to the user certificate by Link.
+type Device struct {
+	UserCertDER []byte
+	Cert        *tls.Certificate
+	Link        *pb.Device
+}
+
+// LinkDevice signs deviceCertDER with the long-term user key so the device may act for the user.
+func LinkDevice(userCert *tls.Certificate, deviceCertDER []byte, name string, addresses ...string) (*pb.Device, error) {
+	sign, err := userCert.PrivateKey.(crypto.Signer).Sign(rand.Reader, deviceSignData(deviceCertDER), crypto.Hash(0))
+	if err != nil {
+		Logger.Println("Failed to sign device cert:", err)
+		return nil, err
+	}
+	return &pb.Device{CertDer: deviceCertDER, Sign: sign, Name: name, Addresses: addresses}, nil
+}
+
+// VerifyDevice checks that device was linked by the owner of userCertDER.
+func VerifyDevice(userCertDER []byte, device *pb.Device) error {
+	publicKey, err := certPublicKey(userCertDER)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(publicKey, deviceSignData(device.CertDer), device.Sign) {
+		Logger.Println("Failed to verify device link:", ErrBadSign)
+		return ErrBadSign
+	}
+	return nil
+}
+
+// Devices returns the verified, unrevoked devices of user. A record without devices is treated as a single device
+// using the user certificate and addresses.
+func Devices(user *pb.User) []*pb.Device {
+	if len(user.Devices) == 0 {
+		return []*pb.Device{{CertDer: user.CertDer, Addresses: user.Addresses}}
+	}
+	devices := make([]*pb.Device, 0, len(user.Devices))
+	for _, device := range user.Devices {
+		if VerifyDevice(user.CertDer, device) == nil && DefaultRevocations.Check(device.CertDer) == nil {
+			devices = append(devices, device)
+		}
+	}
+	return devices
+}
+
+// SendCert sends the device link with the client cert, so the peer identifies the session as the user.
+func (d *Device) SendCert(session *Session, id ...[]byte) error {
+	return session.sendCert(d.Cert, d.UserCertDER, d.Link, id...)
+}
+
+type DeviceResult struct {
+	Device   *pb.Device
+	Response *pb.NetMessage
+	Err      error
+}
+
+// SendToDevices calls request on every device of user in parallel, e.g. to fan a SendMessageReq out to all of them.
+// sendCert authenticates each session, with Session.SendCert for the user itself or Device.SendCert for a linked
+// device.
+func SendToDevices(ctx context.Context, user *pb.User, sendCert func(*Session) error, request *pb.NetMessage, config *Config, recvTimeout time.Duration) []DeviceResult {
+	devices := Devices(user)
+	results := make([]DeviceResult, len(devices))
+	wg := sync.WaitGroup{}
+	for i, device := range devices {
+		i, device := i, device
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			response, err := callDevice(ctx, device, sendCert, request, config, recvTimeout)
+			results[i] = DeviceResult{Device: device, Response: response, Err: err}
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+func callDevice(ctx context.Context, device *pb.Device, sendCert func(*Session) error, request *pb.NetMessage, config *Config, recvTimeout time.Duration) (*pb.NetMessage, error) {
+	if len(device.Addresses) == 0 {
+		return nil, ErrNoAddr
+	}
+	tlsConfig := &tls.Config{
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 || !bytes.Equal(rawCerts[0], device.CertDer) {
+				return ErrPeerMismatch
+			}
+			return nil
+		},
+		NextProtos:         []string{UserTLSProto},
+		InsecureSkipVerify: true,
+	}
+	session, err := Connect(ctx, tlsConfig, config, device.Addresses...)
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close(SessErrNoReason)
+	if err := sendCert(session); err != nil {
+		return nil, err
+	}
+	return session.Call(request, recvTimeout)
+}
+
+func deviceSignData(deviceCertDER []byte) []byte {
+	return append([]byte(DeviceSignPrefix), HashBytes(deviceCertDER, UserCertHashLen)...)
+}
+
+func certPublicKey(certDER []byte) (ed25519.PublicKey, error) {
+	cert, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		Logger.Println("Failed to parse certificate:", err)
+		return nil, err
+	}
+	publicKey, ok := cert.PublicKey.(ed25519.PublicKey)
+	if !ok {
+		return nil, ErrBadSign
+	}
+	return publicKey, nil
+}
@@ -0,0 +1,64 @@
+package pie
+
+import (
+	"bytes"
+	"crypto/tls"
+	"github.com/Pie-Messaging/core/pie/pb"
+	"testing"
+)
+
+func newTestCert(t *testing.T) *tls.Certificate {
+	t.Helper()
+	cert, _, _, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestVerifyDevice(t *testing.T) {
+	user, other, deviceCert := newTestCert(t), newTestCert(t), newTestCert(t)
+	device, err := LinkDevice(user, deviceCert.Certificate[0], "phone", "192.0.2.1:7000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyDevice(user.Certificate[0], device); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyDevice(other.Certificate[0], device); err != ErrBadSign {
+		t.Fatalf("VerifyDevice() with another user = %v, want %v", err, ErrBadSign)
+	}
+	// The link signs the device cert, which cannot be swapped
+	swapped := &pb.Device{CertDer: other.Certificate[0], Sign: device.Sign}
+	if err := VerifyDevice(user.Certificate[0], swapped); err != ErrBadSign {
+		t.Fatalf("VerifyDevice() of a swapped cert = %v, want %v", err, ErrBadSign)
+	}
+}
+
+func TestDevices(t *testing.T) {
+	user, other, revokedCert := newTestCert(t), newTestCert(t), newTestCert(t)
+	link := func(signer *tls.Certificate, deviceCert *tls.Certificate) *pb.Device {
+		device, err := LinkDevice(signer, deviceCert.Certificate[0], "device")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return device
+	}
+	valid, forged, revoked := link(user, newTestCert(t)), link(other, newTestCert(t)), link(user, revokedCert)
+	revocation, err := NewRevocation(revokedCert, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := DefaultRevocations.Add(revocation); err != nil {
+		t.Fatal(err)
+	}
+	record := &pb.User{CertDer: user.Certificate[0], Devices: []*pb.Device{valid, forged, revoked}}
+	if devices := Devices(record); len(devices) != 1 || devices[0] != valid {
+		t.Fatalf("Devices() = %v, want only the valid device", devices)
+	}
+	// A record without devices is reached as a single device with the user cert
+	record = &pb.User{CertDer: user.Certificate[0], Addresses: []string{"192.0.2.1:7000"}}
+	if devices := Devices(record); len(devices) != 1 || !bytes.Equal(devices[0].CertDer, record.CertDer) {
+		t.Fatalf("Devices() of a record without devices = %v, want the user cert", devices)
+	}
+}
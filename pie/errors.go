@@ -13,10 +13,31 @@ var (
 )
 
 var (
-	ErrNoAddr = errors.New("no available address")
+	ErrNoAddr   = errors.New("no available address")
+	ErrStatus   = errors.New("request failed")
+	ErrNotFound = errors.New("not found")
+)
+
+var (
+	ErrPeerMismatch = errors.New("peer certificate does not match id")
+	ErrBadSign      = errors.New("invalid signature")
+	ErrInvalidID    = errors.New("invalid id")
+	// ErrSocketMismatch is returned for a session that was not dialed from the socket the server listens on
+	ErrSocketMismatch = errors.New("session not dialed from the listening socket")
 )
 
 const (
 	SessErrNoReason = iota
 	SessErrNotFound
+	// SessErrIdle closes a session that was evicted from a full session pool; it may be dialed again on demand
+	SessErrIdle
+	// SessErrDuplicate closes the session a punched peer dialed, since the requester keeps the one it dialed
+	SessErrDuplicate
+)
+
+const (
+	StreamErrNoReason = iota
+	StreamErrQuotaExceeded
+	StreamErrRelayClosed
+	StreamErrRelayIdle
 )
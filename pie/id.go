@@ -1,9 +1,12 @@
 package pie
 
 import (
+	"bytes"
 	"database/sql/driver"
+	"encoding/hex"
 	"golang.org/x/crypto/sha3"
 	"math/big"
+	"math/bits"
 )
 
 type ID big.Int
@@ -18,6 +21,75 @@ func (i ID) Value() (driver.Value, error) {
 	return (*big.Int)(&i).Bytes(), nil
 }
 
+func (i *IDA) Scan(value any) error {
+	*i = BytesToIDA(value.([]byte))
+	return nil
+}
+
+func (i IDA) Value() (driver.Value, error) {
+	return i[:], nil
+}
+
+func (i IDA) MarshalText() ([]byte, error) {
+	return []byte(hex.EncodeToString(i[:])), nil
+}
+
+func (i *IDA) UnmarshalText(text []byte) error {
+	if hex.DecodedLen(len(text)) != IDLen {
+		return ErrInvalidID
+	}
+	_, err := hex.Decode(i[:], text)
+	return err
+}
+
+// Xor returns the distance between i and o in the routing metric.
+func (i IDA) Xor(o IDA) IDA {
+	for n := range i {
+		i[n] ^= o[n]
+	}
+	return i
+}
+
+// CommonPrefixLen returns the number of leading bits i and o share, which is IDBits if they are equal.
+func (i IDA) CommonPrefixLen(o IDA) int {
+	for n := range i {
+		if x := i[n] ^ o[n]; x != 0 {
+			return n*8 + bits.LeadingZeros8(x)
+		}
+	}
+	return IDBits
+}
+
+// Cmp compares i and o as big-endian integers, so comparing distances orders IDs by closeness.
+func (i IDA) Cmp(o IDA) int {
+	return bytes.Compare(i[:], o[:])
+}
+
+// Bit returns bit n of i counted from the most significant bit.
+func (i IDA) Bit(n int) uint {
+	return uint(i[n/8]>>(7-n%8)) & 1
+}
+
+func (i IDA) IsZero() bool {
+	return i == IDA{}
+}
+
+// Difficulty returns the leading zero bits of the hash of i, the proof of work of a tracker ID. Finding a key whose
+// ID has d of them takes 2^d tries on average, while hashing the ID keeps the IDs themselves uniform.
+func (i IDA) Difficulty() int {
+	return BytesToIDA(HashBytes(i[:], IDLen)).CommonPrefixLen(IDA{})
+}
+
+// BytesToIDA right-aligns b, so IDs shortened by big.Int.Bytes keep their value
+func BytesToIDA(b []byte) IDA {
+	var ida IDA
+	if len(b) > IDLen {
+		b = b[len(b)-IDLen:]
+	}
+	copy(ida[IDLen-len(b):], b)
+	return ida
+}
+
 // HashBytes : max length of output is 128 bytes
 func HashBytes(data []byte, hashLen int) []byte {
 	h := make([]byte, hashLen)
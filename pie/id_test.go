@@ -0,0 +1,71 @@
+package pie
+
+import (
+	"testing"
+)
+
+func TestIDA(t *testing.T) {
+	low := BytesToIDA([]byte{0x01})
+	high := IDA{0x80}
+	tests := []struct {
+		name      string
+		a, b      IDA
+		prefixLen int
+		cmp       int
+	}{
+		{name: "equal", a: low, b: low, prefixLen: IDBits, cmp: 0},
+		{name: "zero and low", a: IDA{}, b: low, prefixLen: IDBits - 1, cmp: -1},
+		{name: "high and low", a: high, b: low, prefixLen: 0, cmp: 1},
+		{name: "second byte", a: IDA{0x12, 0x20}, b: IDA{0x12, 0x30}, prefixLen: 11, cmp: -1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.a.CommonPrefixLen(test.b); got != test.prefixLen {
+				t.Errorf("CommonPrefixLen() = %d, want %d", got, test.prefixLen)
+			}
+			if got := test.a.Cmp(test.b); got != test.cmp {
+				t.Errorf("Cmp() = %d, want %d", got, test.cmp)
+			}
+			if distance := test.a.Xor(test.b); distance != test.b.Xor(test.a) || distance.Xor(test.b) != test.a {
+				t.Errorf("Xor() = %x is not symmetric and invertible", distance)
+			}
+			for n := 0; n < test.prefixLen; n++ {
+				if test.a.Bit(n) != test.b.Bit(n) {
+					t.Fatalf("Bit(%d) differs within the common prefix", n)
+				}
+			}
+			if test.prefixLen < IDBits && test.a.Bit(test.prefixLen) == test.b.Bit(test.prefixLen) {
+				t.Errorf("Bit(%d) is equal after the common prefix", test.prefixLen)
+			}
+		})
+	}
+	if high.Bit(0) != 1 || low.Bit(IDBits-1) != 1 || low.Bit(0) != 0 {
+		t.Error("Bit() does not index from the most significant bit")
+	}
+}
+
+func TestIDAScanValue(t *testing.T) {
+	id := BytesToIDA([]byte{0, 0, 0xab, 0xcd})
+	value, err := id.Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var scanned IDA
+	if err := scanned.Scan(value); err != nil || scanned != id {
+		t.Fatalf("Scan(Value()) = %x, %v, want %x", scanned, err, id)
+	}
+}
+
+func TestIDADifficulty(t *testing.T) {
+	cert, _, _, err := GenerateTrackerKeyPair(6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := BytesToIDA(HashBytes(cert.Certificate[0], IDLen))
+	if difficulty := id.Difficulty(); difficulty < 6 {
+		t.Fatalf("Difficulty() = %d, want at least 6", difficulty)
+	}
+	if hash := BytesToIDA(HashBytes(id[:], IDLen)); hash.Bit(id.Difficulty()) != 1 {
+		t.Fatal("Difficulty() does not count the leading zero bits of the hash")
+	}
+}
@@ -0,0 +1,212 @@
+package identity
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"github.com/Pie-Messaging/core/pie"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+	"os"
+	"path/filepath"
+)
+
+const (
+	Version = 1
+	KDF     = "argon2id"
+
+	SaltLen = 16
+
+	// Bounds of the Argon2id parameters accepted from a file, so that a corrupt or hostile one cannot make Decrypt
+	// panic or exhaust the memory. The memory is in KiB, so it is capped at the 64 MiB of the default.
+	MaxArgonTime   = 64
+	MaxArgonMemory = 64 * 1024
+)
+
+// Argon2id parameters for newly encrypted keys; loaded files use the parameters they were written with.
+var (
+	ArgonTime    uint32 = 3
+	ArgonMemory  uint32 = 64 * 1024
+	ArgonThreads uint8  = 4
+)
+
+var (
+	ErrPassphrase = errors.New("wrong passphrase")
+	ErrFormat     = errors.New("unsupported identity format")
+)
+
+// File is the at-rest form of an identity. The certificate is stored in the clear,
+// the PKCS#8 private key is sealed with a key derived from the passphrase.
+type File struct {
+	Version int    `json:"version"`
+	KDF     string `json:"kdf"`
+	Time    uint32 `json:"time"`
+	Memory  uint32 `json:"memory"`
+	Threads uint8  `json:"threads"`
+	Salt    []byte `json:"salt"`
+	Nonce   []byte `json:"nonce"`
+	Cert    []byte `json:"cert"`
+	Key     []byte `json:"key"`
+}
+
+// Generate creates a new identity, saves it to path encrypted with passphrase and returns its certificate. An existing
+// file at path is an identity that would be lost, so Generate fails with an error matching os.ErrExist unless
+// overwrite is passed as true.
+func Generate(path string, passphrase []byte, overwrite ...bool) (*tls.Certificate, error) {
+	cert, _, _, err := pie.GenerateKeyPair()
+	if err != nil {
+		return nil, err
+	}
+	data, err := Encrypt(cert, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if len(overwrite) > 0 && overwrite[0] {
+		err = writeFile(path, data)
+	} else {
+		err = createFile(path, data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return cert, nil
+}
+
+func Save(path string, cert *tls.Certificate, passphrase []byte) error {
+	data, err := Encrypt(cert, passphrase)
+	if err != nil {
+		return err
+	}
+	return writeFile(path, data)
+}
+
+func Load(path string, passphrase []byte) (*tls.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		pie.Logger.Println("Failed to read identity:", err)
+		return nil, err
+	}
+	return Decrypt(data, passphrase)
+}
+
+// ChangePassphrase re-encrypts the identity at path, replacing the file only once the new one is written.
+func ChangePassphrase(path string, oldPassphrase []byte, newPassphrase []byte) error {
+	cert, err := Load(path, oldPassphrase)
+	if err != nil {
+		return err
+	}
+	return Save(path, cert, newPassphrase)
+}
+
+func Encrypt(cert *tls.Certificate, passphrase []byte) ([]byte, error) {
+	keyDER, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		pie.Logger.Println("Failed to marshal private key:", err)
+		return nil, err
+	}
+	file := &File{
+		Version: Version,
+		KDF:     KDF,
+		Time:    ArgonTime,
+		Memory:  ArgonMemory,
+		Threads: ArgonThreads,
+		Salt:    make([]byte, SaltLen),
+		Nonce:   make([]byte, chacha20poly1305.NonceSizeX),
+		Cert:    cert.Certificate[0],
+	}
+	if _, err := rand.Read(file.Salt); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(file.Nonce); err != nil {
+		return nil, err
+	}
+	aead, err := file.aead(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	// The certificate is authenticated too, so it cannot be swapped for another one under the same key.
+	file.Key = aead.Seal(nil, file.Nonce, keyDER, file.Cert)
+	return json.Marshal(file)
+}
+
+func Decrypt(data []byte, passphrase []byte) (*tls.Certificate, error) {
+	file := &File{}
+	if err := json.Unmarshal(data, file); err != nil {
+		pie.Logger.Println("Failed to parse identity:", err)
+		return nil, err
+	}
+	if file.Version != Version || file.KDF != KDF || len(file.Nonce) != chacha20poly1305.NonceSizeX {
+		return nil, ErrFormat
+	}
+	if file.Time == 0 || file.Time > MaxArgonTime || file.Memory == 0 || file.Memory > MaxArgonMemory ||
+		file.Threads == 0 {
+		return nil, ErrFormat
+	}
+	aead, err := file.aead(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	keyDER, err := aead.Open(nil, file.Nonce, file.Key, file.Cert)
+	if err != nil {
+		return nil, ErrPassphrase
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: file.Cert})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	return pie.X509KeyPair(certPEM, keyPEM)
+}
+
+func (f *File) aead(passphrase []byte) (cipher.AEAD, error) {
+	key := argon2.IDKey(passphrase, f.Salt, f.Time, f.Memory, f.Threads, chacha20poly1305.KeySize)
+	return chacha20poly1305.NewX(key)
+}
+
+// createFile writes data to a new file at path and fails if the file exists.
+func createFile(path string, data []byte) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		pie.Logger.Println("Failed to create identity:", err)
+		return err
+	}
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		// A partial file would keep the next Generate from succeeding
+		os.Remove(path)
+		pie.Logger.Println("Failed to create identity:", err)
+		return err
+	}
+	return nil
+}
+
+func writeFile(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		pie.Logger.Println("Failed to write identity:", err)
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		pie.Logger.Println("Failed to write identity:", err)
+		return err
+	}
+	// The data must be on disk before the rename replaces the old identity, or a crash could leave neither
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		pie.Logger.Println("Failed to write identity:", err)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
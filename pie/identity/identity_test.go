@@ -0,0 +1,97 @@
+package identity
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"github.com/Pie-Messaging/core/pie"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func init() {
+	// Keeps the tests fast; the parameters are stored in the file, so Decrypt uses them as well
+	ArgonTime, ArgonMemory, ArgonThreads = 1, 64, 1
+	pie.Logger.SetOutput(io.Discard)
+}
+
+func TestRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "identity.json")
+	cert, err := Generate(path, []byte("old"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ChangePassphrase(path, []byte("old"), []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Load(path, []byte("new"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(loaded.Certificate[0], cert.Certificate[0]) || !cert.PrivateKey.(ed25519.PrivateKey).Equal(loaded.PrivateKey) {
+		t.Fatal("loaded identity differs from the generated one")
+	}
+	if _, err := Load(path, []byte("old")); err != ErrPassphrase {
+		t.Fatalf("Load() with the old passphrase = %v, want %v", err, ErrPassphrase)
+	}
+}
+
+func TestGenerateExisting(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "identity.json")
+	cert, err := Generate(path, []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Generate(path, []byte("other")); !errors.Is(err, os.ErrExist) {
+		t.Fatalf("Generate() over an identity = %v, want %v", err, os.ErrExist)
+	}
+	loaded, err := Load(path, []byte("passphrase"))
+	if err != nil || !bytes.Equal(loaded.Certificate[0], cert.Certificate[0]) {
+		t.Fatalf("Load() = %v, want the first identity", err)
+	}
+	replaced, err := Generate(path, []byte("other"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err = Load(path, []byte("other"))
+	if err != nil || !bytes.Equal(loaded.Certificate[0], replaced.Certificate[0]) {
+		t.Fatalf("Load() = %v, want the overwriting identity", err)
+	}
+}
+
+func TestCorruptParams(t *testing.T) {
+	cert, _, _, err := pie.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := Encrypt(cert, []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []func(*File){
+		func(f *File) { f.Time = 0 },
+		func(f *File) { f.Time = MaxArgonTime + 1 },
+		func(f *File) { f.Memory = 0 },
+		func(f *File) { f.Memory = MaxArgonMemory + 1 },
+		func(f *File) { f.Threads = 0 },
+		func(f *File) { f.Nonce = f.Nonce[1:] },
+		func(f *File) { f.Version = Version + 1 },
+	}
+	for i, corrupt := range tests {
+		file := &File{}
+		if err := json.Unmarshal(data, file); err != nil {
+			t.Fatal(err)
+		}
+		corrupt(file)
+		corrupted, err := json.Marshal(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Decrypt(corrupted, []byte("passphrase")); err != ErrFormat {
+			t.Errorf("Decrypt() of corrupt file %d = %v, want %v", i, err, ErrFormat)
+		}
+	}
+}
@@ -0,0 +1,346 @@
+package memnet
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/lucas-clemente/quic-go"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+type conn struct {
+	network *Network
+	local   net.Addr
+	remote  net.Addr
+	state   quic.ConnectionState
+	peer    *conn
+	accept  chan *stream
+	ctx     context.Context
+	cancel  context.CancelFunc
+	err     error
+	nextID  quic.StreamID
+	mutex   sync.Mutex
+}
+
+func newConn(network *Network, local net.Addr, remote net.Addr, tlsState tls.ConnectionState, firstID quic.StreamID) *conn {
+	c := &conn{
+		network: network,
+		local:   local,
+		remote:  remote,
+		accept:  make(chan *stream, AcceptQueueLen),
+		nextID:  firstID,
+	}
+	c.state.TLS.ConnectionState = tlsState
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	return c
+}
+
+func (c *conn) AcceptStream(ctx context.Context) (quic.Stream, error) {
+	select {
+	case s := <-c.accept:
+		return s, nil
+	case <-c.ctx.Done():
+		return nil, c.closeErr()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (c *conn) OpenStream() (quic.Stream, error) {
+	c.mutex.Lock()
+	if c.err != nil {
+		c.mutex.Unlock()
+		return nil, c.err
+	}
+	id := c.nextID
+	c.nextID += 4
+	c.mutex.Unlock()
+	local, remote := newPipe(), newPipe()
+	s := newStream(c, id, remote, local)
+	select {
+	case c.peer.accept <- newStream(c.peer, id, local, remote):
+	default:
+		return nil, &quic.TransportError{ErrorCode: quic.StreamLimitError}
+	}
+	return s, nil
+}
+
+func (c *conn) LocalAddr() net.Addr {
+	return c.local
+}
+
+func (c *conn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func (c *conn) ConnectionState() quic.ConnectionState {
+	return c.state
+}
+
+// HandshakeComplete is done as soon as Dial returns, since memnet dials do not send early data.
+func (c *conn) HandshakeComplete() context.Context {
+	return closedContext
+}
+
+func (c *conn) Context() context.Context {
+	return c.ctx
+}
+
+// CloseWithError closes the conn at once and the peer one latency later.
+func (c *conn) CloseWithError(code quic.ApplicationErrorCode, reason string) error {
+	if !c.close(&quic.ApplicationError{ErrorCode: code, ErrorMessage: reason}) {
+		return nil
+	}
+	delay := c.network.delay()
+	go func() {
+		time.Sleep(delay)
+		c.peer.close(&quic.ApplicationError{Remote: true, ErrorCode: code, ErrorMessage: reason})
+	}()
+	return nil
+}
+
+func (c *conn) close(err error) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.err != nil {
+		return false
+	}
+	c.err = err
+	c.cancel()
+	return true
+}
+
+func (c *conn) closeErr() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.err
+}
+
+var closedContext = func() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return ctx
+}()
+
+// pipe carries one direction of a stream.
+type pipe struct {
+	chunks   []chunk
+	reset    error
+	stopped  error
+	notify   chan struct{}
+	lastTime time.Time
+	mutex    sync.Mutex
+}
+
+type chunk struct {
+	data []byte
+	fin  bool
+	at   time.Time
+}
+
+func newPipe() *pipe {
+	return &pipe{notify: make(chan struct{}, 1)}
+}
+
+func (p *pipe) push(c chunk) {
+	p.mutex.Lock()
+	// Keep the stream ordered even if a later write drew a shorter delay
+	if c.at.Before(p.lastTime) {
+		c.at = p.lastTime
+	}
+	p.lastTime = c.at
+	p.chunks = append(p.chunks, c)
+	p.mutex.Unlock()
+	p.signal()
+}
+
+func (p *pipe) signal() {
+	select {
+	case p.notify <- struct{}{}:
+	default:
+	}
+}
+
+type stream struct {
+	conn          *conn
+	id            quic.StreamID
+	in            *pipe
+	out           *pipe
+	readDeadline  time.Time
+	writeDeadline time.Time
+	finished      bool
+	ctx           context.Context
+	cancel        context.CancelFunc
+	mutex         sync.Mutex
+}
+
+func newStream(c *conn, id quic.StreamID, in *pipe, out *pipe) *stream {
+	s := &stream{conn: c, id: id, in: in, out: out}
+	s.ctx, s.cancel = context.WithCancel(c.ctx)
+	return s
+}
+
+func (s *stream) StreamID() quic.StreamID {
+	return s.id
+}
+
+func (s *stream) Read(b []byte) (int, error) {
+	for {
+		s.in.mutex.Lock()
+		if s.in.reset != nil {
+			s.in.mutex.Unlock()
+			return 0, s.in.reset
+		}
+		var wait time.Duration = -1
+		if len(s.in.chunks) != 0 {
+			head := &s.in.chunks[0]
+			reachable, _ := s.conn.network.reachable(s.conn.local, s.conn.remote)
+			if now := time.Now(); !head.at.After(now) && reachable {
+				if head.fin {
+					s.in.mutex.Unlock()
+					return 0, io.EOF
+				}
+				n := copy(b, head.data)
+				if head.data = head.data[n:]; len(head.data) == 0 {
+					s.in.chunks = s.in.chunks[1:]
+				}
+				s.in.mutex.Unlock()
+				return n, nil
+			} else if reachable {
+				wait = head.at.Sub(now)
+			}
+		}
+		s.in.mutex.Unlock()
+		if err := s.conn.closeErr(); err != nil {
+			return 0, err
+		}
+		if err := s.wait(s.getReadDeadline(), wait); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// wait blocks until new data arrives, the head chunk is due after wait, or the network changes.
+func (s *stream) wait(deadline time.Time, wait time.Duration) error {
+	var due, timeout <-chan time.Time
+	if wait >= 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		due = timer.C
+	}
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	_, healed := s.conn.network.reachable(s.conn.local, s.conn.remote)
+	select {
+	case <-s.in.notify:
+	case <-due:
+	case <-healed:
+	case <-s.conn.ctx.Done():
+	case <-timeout:
+		return os.ErrDeadlineExceeded
+	}
+	return nil
+}
+
+func (s *stream) Write(b []byte) (int, error) {
+	if err := s.conn.closeErr(); err != nil {
+		return 0, err
+	}
+	if deadline := s.getWriteDeadline(); !deadline.IsZero() && !time.Now().Before(deadline) {
+		return 0, os.ErrDeadlineExceeded
+	}
+	s.mutex.Lock()
+	finished := s.finished
+	s.mutex.Unlock()
+	if finished {
+		return 0, ErrStreamClosed
+	}
+	s.out.mutex.Lock()
+	stopped := s.out.stopped
+	s.out.mutex.Unlock()
+	if stopped != nil {
+		return 0, stopped
+	}
+	data := make([]byte, len(b))
+	copy(data, b)
+	s.out.push(chunk{data: data, at: time.Now().Add(s.conn.network.delay())})
+	return len(b), nil
+}
+
+// Close finishes the send direction; the peer reads io.EOF after the data written before.
+func (s *stream) Close() error {
+	s.mutex.Lock()
+	if s.finished {
+		s.mutex.Unlock()
+		return nil
+	}
+	s.finished = true
+	s.mutex.Unlock()
+	s.out.push(chunk{fin: true, at: time.Now().Add(s.conn.network.delay())})
+	s.cancel()
+	return nil
+}
+
+func (s *stream) CancelWrite(code quic.StreamErrorCode) {
+	s.mutex.Lock()
+	s.finished = true
+	s.mutex.Unlock()
+	s.out.mutex.Lock()
+	if s.out.reset == nil {
+		s.out.reset = &quic.StreamError{StreamID: s.id, ErrorCode: code}
+	}
+	s.out.mutex.Unlock()
+	s.out.signal()
+	s.cancel()
+}
+
+func (s *stream) CancelRead(code quic.StreamErrorCode) {
+	s.in.mutex.Lock()
+	if s.in.stopped == nil {
+		s.in.stopped = &quic.StreamError{StreamID: s.id, ErrorCode: code}
+	}
+	s.in.chunks = nil
+	s.in.mutex.Unlock()
+}
+
+func (s *stream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *stream) SetReadDeadline(t time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.readDeadline = t
+	s.in.signal()
+	return nil
+}
+
+func (s *stream) SetWriteDeadline(t time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.writeDeadline = t
+	return nil
+}
+
+func (s *stream) SetDeadline(t time.Time) error {
+	_ = s.SetReadDeadline(t)
+	return s.SetWriteDeadline(t)
+}
+
+func (s *stream) getReadDeadline() time.Time {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.readDeadline
+}
+
+func (s *stream) getWriteDeadline() time.Time {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.writeDeadline
+}
@@ -0,0 +1,360 @@
+// Package memnet is an in-memory pie.Transport for running many peers in one process.
+// Streams are reliable and ordered like QUIC streams; latency, loss and partitions only delay data.
+package memnet
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/Pie-Messaging/core/pie"
+	"github.com/lucas-clemente/quic-go"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultHandshakeTimeout = 5 * time.Second
+	AcceptQueueLen          = 1024
+)
+
+var (
+	ErrNoProtocol   = errors.New("memnet: no application protocol")
+	ErrAddrInUse    = errors.New("memnet: address already in use")
+	ErrStreamClosed = errors.New("memnet: write on closed stream")
+)
+
+// Network connects the listeners and conns created through it. Its exported fields may be changed at any time.
+type Network struct {
+	// Latency is the one-way delay of every write, plus a random delay of up to Jitter
+	Latency time.Duration
+	Jitter  time.Duration
+	// Loss is the probability that a write is lost, which delays it by one retransmission timeout of 3 * Latency
+	Loss float64
+
+	listeners  map[string]*listener
+	partitions map[string]int
+	healed     chan struct{}
+	nextHost   uint32
+	rand       *rand.Rand
+	mutex      sync.Mutex
+}
+
+func NewNetwork(seed int64) *Network {
+	return &Network{
+		listeners:  make(map[string]*listener),
+		partitions: make(map[string]int),
+		healed:     make(chan struct{}),
+		rand:       rand.New(rand.NewSource(seed)),
+	}
+}
+
+// Partition splits hosts (IPs without port) into groups that cannot reach each other. Hosts not listed
+// form one more group. Data between groups is held back until the partition changes.
+func (n *Network) Partition(groups ...[]string) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.partitions = make(map[string]int)
+	for i, group := range groups {
+		for _, host := range group {
+			n.partitions[host] = i + 1
+		}
+	}
+	close(n.healed)
+	n.healed = make(chan struct{})
+}
+
+func (n *Network) Heal() {
+	n.Partition()
+}
+
+func (n *Network) Listen(addr string, tlsConfig *tls.Config, quicConfig *quic.Config) (pie.Listener, error) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	udpAddr, err := n.resolve(addr)
+	if err != nil {
+		return nil, err
+	}
+	if _, exists := n.listeners[udpAddr.String()]; exists {
+		return nil, ErrAddrInUse
+	}
+	l := &listener{
+		network:    n,
+		addr:       udpAddr,
+		tlsConfig:  tlsConfig,
+		quicConfig: quicConfig,
+		accept:     make(chan *conn, AcceptQueueLen),
+		conns:      make(map[*conn]struct{}),
+		closed:     make(chan struct{}),
+	}
+	n.listeners[udpAddr.String()] = l
+	return l, nil
+}
+
+// Dial connects from a new address on a host of its own.
+func (n *Network) Dial(ctx context.Context, addr string, tlsConfig *tls.Config, quicConfig *quic.Config) (pie.Conn, error) {
+	n.mutex.Lock()
+	local := n.newAddr(0)
+	n.mutex.Unlock()
+	return n.dial(ctx, nil, local, addr, tlsConfig, quicConfig)
+}
+
+func (n *Network) dial(ctx context.Context, from *listener, local *net.UDPAddr, addr string, tlsConfig *tls.Config, quicConfig *quic.Config) (pie.Conn, error) {
+	n.mutex.Lock()
+	remote, err := n.resolve(addr)
+	var l *listener
+	if err == nil {
+		l = n.listeners[remote.String()]
+	}
+	n.mutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if l == nil || !n.waitReachable(ctx, local, remote, handshakeTimeout(quicConfig)) {
+		return nil, n.handshakeTimeout(ctx, quicConfig)
+	}
+	// One round trip for the handshake
+	if err := sleep(ctx, n.delay()+n.delay()); err != nil {
+		return nil, err
+	}
+	clientState, serverState, err := handshake(tlsConfig, l.tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	client := newConn(n, local, remote, clientState, 0)
+	server := newConn(n, remote, local, serverState, 1)
+	client.peer, server.peer = server, client
+	if from != nil {
+		from.track(client)
+	}
+	if !l.track(server) {
+		return nil, n.handshakeTimeout(ctx, quicConfig)
+	}
+	select {
+	case l.accept <- server:
+	default:
+		return nil, &quic.TransportError{ErrorCode: quic.ConnectionRefused}
+	}
+	return client, nil
+}
+
+func (n *Network) handshakeTimeout(ctx context.Context, quicConfig *quic.Config) error {
+	if err := sleep(ctx, handshakeTimeout(quicConfig)); err != nil {
+		return err
+	}
+	return &quic.HandshakeTimeoutError{}
+}
+
+func (n *Network) waitReachable(ctx context.Context, a net.Addr, b net.Addr, timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		reachable, healed := n.reachable(a, b)
+		if reachable {
+			return true
+		}
+		select {
+		case <-healed:
+		case <-timer.C:
+			return false
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+func (n *Network) reachable(a net.Addr, b net.Addr) (bool, <-chan struct{}) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	return n.partitions[host(a)] == n.partitions[host(b)], n.healed
+}
+
+// delay returns the time until a write is delivered.
+func (n *Network) delay() time.Duration {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	d := n.Latency
+	if n.Jitter > 0 {
+		d += time.Duration(n.rand.Int63n(int64(n.Jitter)))
+	}
+	for n.Loss > 0 && n.rand.Float64() < n.Loss {
+		d += 3 * n.Latency
+	}
+	return d
+}
+
+// resolve assigns a new host to unspecified IPs and a port to port 0.
+func (n *Network) resolve(addr string) (*net.UDPAddr, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if udpAddr.IP == nil || udpAddr.IP.IsUnspecified() {
+		return n.newAddr(udpAddr.Port), nil
+	}
+	if udpAddr.Port == 0 {
+		udpAddr.Port = 1
+	}
+	return udpAddr, nil
+}
+
+func (n *Network) newAddr(port int) *net.UDPAddr {
+	n.nextHost++
+	if port == 0 {
+		port = 1
+	}
+	return &net.UDPAddr{IP: net.IPv4(10, byte(n.nextHost>>16), byte(n.nextHost>>8), byte(n.nextHost)), Port: port}
+}
+
+type listener struct {
+	network    *Network
+	addr       *net.UDPAddr
+	tlsConfig  *tls.Config
+	quicConfig *quic.Config
+	accept     chan *conn
+	conns      map[*conn]struct{}
+	closed     chan struct{}
+	mutex      sync.Mutex
+}
+
+func (l *listener) Accept(ctx context.Context) (pie.Conn, error) {
+	select {
+	case c := <-l.accept:
+		return c, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (l *listener) Addr() net.Addr {
+	return l.addr
+}
+
+// Close stops listening and closes every conn of the listener, like closing the socket would.
+func (l *listener) Close() error {
+	l.network.mutex.Lock()
+	if l.network.listeners[l.addr.String()] == l {
+		delete(l.network.listeners, l.addr.String())
+	}
+	l.network.mutex.Unlock()
+	l.mutex.Lock()
+	select {
+	case <-l.closed:
+		l.mutex.Unlock()
+		return nil
+	default:
+	}
+	close(l.closed)
+	conns := l.conns
+	l.conns = nil
+	l.mutex.Unlock()
+	for c := range conns {
+		_ = c.CloseWithError(0, "")
+	}
+	return nil
+}
+
+// Dial connects from the listener address.
+func (l *listener) Dial(ctx context.Context, addr string, tlsConfig *tls.Config, quicConfig *quic.Config) (pie.Conn, error) {
+	return l.network.dial(ctx, l, l.addr, addr, tlsConfig, quicConfig)
+}
+
+func (l *listener) track(c *conn) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.conns == nil {
+		return false
+	}
+	l.conns[c] = struct{}{}
+	go func() {
+		<-c.ctx.Done()
+		l.mutex.Lock()
+		delete(l.conns, c)
+		l.mutex.Unlock()
+	}()
+	return true
+}
+
+// handshake checks ALPN and the client's VerifyPeerCertificate, and returns the TLS state of both sides.
+func handshake(clientConfig *tls.Config, serverConfig *tls.Config) (tls.ConnectionState, tls.ConnectionState, error) {
+	var state tls.ConnectionState
+	for _, proto := range clientConfig.NextProtos {
+		for _, serverProto := range serverConfig.NextProtos {
+			if proto == serverProto && state.NegotiatedProtocol == "" {
+				state.NegotiatedProtocol = proto
+			}
+		}
+	}
+	if state.NegotiatedProtocol == "" {
+		return state, state, ErrNoProtocol
+	}
+	state.HandshakeComplete = true
+	state.Version = tls.VersionTLS13
+	clientState, serverState := state, state
+	if len(serverConfig.Certificates) != 0 {
+		rawCerts := serverConfig.Certificates[0].Certificate
+		if clientConfig.VerifyPeerCertificate != nil {
+			if err := clientConfig.VerifyPeerCertificate(rawCerts, nil); err != nil {
+				return state, state, err
+			}
+		}
+		certs, err := parseCerts(rawCerts)
+		if err != nil {
+			return state, state, err
+		}
+		clientState.PeerCertificates = certs
+	}
+	if len(clientConfig.Certificates) != 0 {
+		certs, err := parseCerts(clientConfig.Certificates[0].Certificate)
+		if err != nil {
+			return state, state, err
+		}
+		serverState.PeerCertificates = certs
+	}
+	return clientState, serverState, nil
+}
+
+func parseCerts(rawCerts [][]byte) ([]*x509.Certificate, error) {
+	certs := make([]*x509.Certificate, 0, len(rawCerts))
+	for _, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return nil, fmt.Errorf("memnet: %w", err)
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
+
+func handshakeTimeout(quicConfig *quic.Config) time.Duration {
+	if quicConfig != nil && quicConfig.HandshakeIdleTimeout > 0 {
+		return quicConfig.HandshakeIdleTimeout
+	}
+	return DefaultHandshakeTimeout
+}
+
+func host(addr net.Addr) string {
+	if udpAddr, ok := addr.(*net.UDPAddr); ok {
+		return udpAddr.IP.String()
+	}
+	return addr.String()
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
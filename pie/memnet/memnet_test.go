@@ -0,0 +1,383 @@
+package memnet
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"github.com/Pie-Messaging/core/pie"
+	"github.com/Pie-Messaging/core/pie/pb"
+	"github.com/lucas-clemente/quic-go"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	pie.Logger.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+func newServer(t *testing.T, network *Network, addr string) *pie.Server {
+	t.Helper()
+	cert, _, _, err := pie.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newCertServer(t, network, addr, cert)
+}
+
+func newCertServer(t *testing.T, network *Network, addr string, cert *tls.Certificate) *pie.Server {
+	t.Helper()
+	config := pie.DefaultConfig()
+	config.Transport = network
+	config.QUIC.HandshakeIdleTimeout = pie.Duration(100 * time.Millisecond)
+	server, err := pie.ListenNet(addr, &tls.Config{Certificates: []tls.Certificate{*cert}, NextProtos: []string{pie.UserTLSProto}}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Close)
+	return server
+}
+
+func connect(ctx context.Context, server *pie.Server) (*pie.Session, error) {
+	tlsConfig := &tls.Config{NextProtos: []string{pie.UserTLSProto}, InsecureSkipVerify: true}
+	return pie.Connect(ctx, tlsConfig, server.Config, server.Listener.Addr().String())
+}
+
+// echo answers every GetAddrReq on the accepted sessions of server.
+func echo(ctx context.Context, server *pie.Server) {
+	for {
+		session, err := server.AcceptSession(ctx, true)
+		if err != nil {
+			return
+		}
+		go func() {
+			for {
+				stream, err := session.AcceptStream(ctx, nil, true)
+				if err != nil {
+					return
+				}
+				go func() {
+					defer stream.Close()
+					if _, err := stream.RecvMessage(time.Now().Add(time.Second)); err == nil {
+						server.HandleGetAddrReq(session, stream)
+					}
+				}()
+			}
+		}()
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	network := NewNetwork(1)
+	network.Latency = 5 * time.Millisecond
+	server := newServer(t, network, "10.1.0.1:7000")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go echo(ctx, server)
+	session, err := connect(ctx, server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	peerCertDER := session.Session.ConnectionState().TLS.PeerCertificates[0].Raw
+	if !bytes.Equal(pie.HashBytes(peerCertDER, pie.ServerCertHashLen), server.CertHash) {
+		t.Error("peer certificate differs from the server certificate")
+	}
+	start := time.Now()
+	addrs, err := session.GetAddr(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 2*network.Latency {
+		t.Errorf("round trip took %v, want at least %v", elapsed, 2*network.Latency)
+	}
+	if addrs[0] != session.Session.LocalAddr().String() {
+		t.Errorf("observed address %v, want %v", addrs[0], session.Session.LocalAddr())
+	}
+}
+
+func TestListenAddr(t *testing.T) {
+	network := NewNetwork(1)
+	busy := newServer(t, network, "10.1.0.1:7000")
+	config := busy.Config
+	config.ListenAddr = []string{"10.1.0.1:7000", "10.1.0.1:7001"}
+	cert, _, _, err := pie.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{*cert}, NextProtos: []string{pie.UserTLSProto}}
+	server, err := pie.ListenNet("", tlsConfig, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	if addr := server.Listener.Addr().String(); addr != "10.1.0.1:7001" {
+		t.Errorf("ListenNet() listens on %v, want the first free address 10.1.0.1:7001", addr)
+	}
+	if _, err := pie.ListenNet("", tlsConfig, config); err != ErrAddrInUse {
+		t.Errorf("ListenNet() with all addresses in use = %v, want %v", err, ErrAddrInUse)
+	}
+}
+
+func TestUnreachable(t *testing.T) {
+	network := NewNetwork(1)
+	server := newServer(t, network, "10.1.0.1:7000")
+	tlsConfig := &tls.Config{NextProtos: []string{pie.UserTLSProto}, InsecureSkipVerify: true}
+	_, err := pie.Connect(context.Background(), tlsConfig, server.Config, "10.1.0.2:7000")
+	if !errors.Is(err, &quic.HandshakeTimeoutError{}) {
+		t.Errorf("dial without listener: got %v, want handshake timeout", err)
+	}
+	tlsConfig.NextProtos = []string{pie.TrackerTLSProto}
+	if _, err := pie.Connect(context.Background(), tlsConfig, server.Config, "10.1.0.1:7000"); !errors.Is(err, ErrNoProtocol) {
+		t.Errorf("dial with unknown protocol: got %v, want %v", err, ErrNoProtocol)
+	}
+}
+
+func TestPartition(t *testing.T) {
+	network := NewNetwork(1)
+	server := newServer(t, network, "10.1.0.1:7000")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go echo(ctx, server)
+	session, err := connect(ctx, server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	network.Partition([]string{"10.1.0.1"})
+	if _, err := session.GetAddr(50 * time.Millisecond); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("request across partition: got %v, want deadline exceeded", err)
+	}
+	if _, err := connect(ctx, server); !errors.Is(err, &quic.HandshakeTimeoutError{}) {
+		t.Errorf("dial across partition: got %v, want handshake timeout", err)
+	}
+	network.Heal()
+	if _, err := session.GetAddr(time.Second); err != nil {
+		t.Errorf("request after healing: %v", err)
+	}
+}
+
+func TestLossKeepsOrder(t *testing.T) {
+	network := NewNetwork(1)
+	network.Latency = time.Millisecond
+	network.Jitter = time.Millisecond
+	network.Loss = 0.3
+	server := newServer(t, network, ":0")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	accepted := make(chan *pie.Session, 1)
+	go func() {
+		session, err := server.AcceptSession(ctx)
+		if err == nil {
+			accepted <- session
+		}
+	}()
+	client, err := connect(ctx, server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, err := client.Session.OpenStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	const n = 200
+	for i := 0; i < n; i++ {
+		if _, err := stream.Write([]byte{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = stream.Close()
+	session := <-accepted
+	peer, err := session.Session.AcceptStream(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = peer.SetReadDeadline(time.Now().Add(5 * time.Second))
+	data, err := io.ReadAll(peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != n {
+		t.Fatalf("read %d bytes, want %d", len(data), n)
+	}
+	for i, b := range data {
+		if b != byte(i) {
+			t.Fatalf("byte %d is %d", i, b)
+		}
+	}
+}
+
+func TestCloseNotifiesPeer(t *testing.T) {
+	network := NewNetwork(1)
+	server := newServer(t, network, ":0")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	session, err := connect(ctx, server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Close()
+	_, err = session.Session.AcceptStream(ctx)
+	var applicationErr *quic.ApplicationError
+	if !errors.As(err, &applicationErr) || !applicationErr.Remote {
+		t.Errorf("accept after server close: got %v, want remote application error", err)
+	}
+}
+
+func TestQueryAddr(t *testing.T) {
+	network := NewNetwork(1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var sessions []*pie.Session
+	client := newServer(t, network, ":7000")
+	for _, addr := range []string{"10.1.0.1:7000", "10.1.0.2:7000"} {
+		server := newServer(t, network, addr)
+		go echo(ctx, server)
+		tlsConfig := &tls.Config{NextProtos: []string{pie.UserTLSProto}, InsecureSkipVerify: true}
+		session, err := client.Dial(ctx, tlsConfig, addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sessions = append(sessions, session)
+	}
+	report, err := pie.QueryAddr(sessions, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Consistent || len(report.Observed) != 2 || report.Observed[0] != client.Listener.Addr().String() {
+		t.Errorf("sessions from one socket: got %+v, want both observed at %v", report, client.Listener.Addr())
+	}
+
+	// memnet dials from a host of its own, so the IP differs as well
+	server := newServer(t, network, "10.1.0.3:7000")
+	go echo(ctx, server)
+	other, err := connect(ctx, server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report, err := pie.QueryAddr(append(sessions, other), time.Second); err != nil || report.Consistent {
+		t.Errorf("sessions from other hosts: got %+v, %v, want inconsistent", report, err)
+	}
+}
+
+func TestPublicAddrs(t *testing.T) {
+	network := NewNetwork(1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := newServer(t, network, ":7000")
+	server := newServer(t, network, "10.1.0.1:7000")
+	go echo(ctx, server)
+	tlsConfig := &tls.Config{NextProtos: []string{pie.UserTLSProto}, InsecureSkipVerify: true}
+	session, err := client.Dial(ctx, tlsConfig, server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := connect(ctx, server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The private address client listens on is left out, and so is the session from another socket
+	addrs, err := client.PublicAddrs([]*pie.Session{other, session}, time.Second)
+	if err != nil || len(addrs) != 1 || addrs[0] != client.Listener.Addr().String() {
+		t.Errorf("PublicAddrs() = %v, %v, want [%v]", addrs, err, client.Listener.Addr())
+	}
+	if _, err := client.PublicAddrs([]*pie.Session{other}, time.Second); err != pie.ErrSocketMismatch {
+		t.Errorf("PublicAddrs() without a session from the listening socket = %v, want %v", err, pie.ErrSocketMismatch)
+	}
+}
+
+func TestWatchPath(t *testing.T) {
+	network := NewNetwork(1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	server := newServer(t, network, ":7000")
+	// The server reports the reflexive address it is handed, as if a NAT in front of the client rebound
+	observed := make(chan string)
+	go func() {
+		session, err := server.AcceptSession(ctx, true)
+		if err != nil {
+			return
+		}
+		for {
+			stream, err := session.AcceptStream(ctx, nil, true)
+			if err != nil {
+				return
+			}
+			if _, err := stream.RecvMessage(time.Now().Add(time.Second)); err == nil {
+				select {
+				case addr := <-observed:
+					_ = stream.SendMessage(&pb.NetMessage{Body: &pb.NetMessage_GetAddrRes{GetAddrRes: &pb.GetAddrRes{Addresses: []string{addr}}}})
+				case <-ctx.Done():
+				}
+			}
+			stream.Close()
+		}
+	}()
+	session, err := connect(ctx, server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := session.WatchPath(ctx, 10*time.Millisecond, time.Second)
+	observed <- "192.0.2.1:7000"
+	observed <- "192.0.2.1:7000"
+	observed <- "192.0.2.1:7001"
+	event := <-events
+	if event.Type != pie.PathReflexiveChanged || event.Old[0] != "192.0.2.1:7000" || event.New[0] != "192.0.2.1:7001" {
+		t.Fatalf("WatchPath() = %+v, want the reflexive address to change to 192.0.2.1:7001", event)
+	}
+	server.Close()
+	for event = range events {
+		if event.Type == pie.PathClosed {
+			return
+		}
+	}
+	t.Fatal("WatchPath() closed without PathClosed")
+}
+
+func TestSendToDevices(t *testing.T) {
+	network := NewNetwork(1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	userCert, _, _, err := pie.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &pb.User{CertDer: userCert.Certificate[0]}
+	var servers []*pie.Server
+	for i := 0; i < 2; i++ {
+		deviceCert, _, _, err := pie.GenerateKeyPair()
+		if err != nil {
+			t.Fatal(err)
+		}
+		server := newCertServer(t, network, ":7000", deviceCert)
+		go echo(ctx, server)
+		device, err := pie.LinkDevice(userCert, deviceCert.Certificate[0], "device", server.Listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		user.Devices = append(user.Devices, device)
+		servers = append(servers, server)
+	}
+	// A device whose address answers with another cert is not called
+	mismatched, err := pie.LinkDevice(userCert, userCert.Certificate[0], "device", servers[0].Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	user.Devices = append(user.Devices, mismatched)
+
+	request := &pb.NetMessage{Body: &pb.NetMessage_GetAddrReq{GetAddrReq: &pb.GetAddrReq{}}}
+	sendCert := func(session *pie.Session) error { return session.SendCert(userCert) }
+	results := pie.SendToDevices(ctx, user, sendCert, request, servers[0].Config, time.Second)
+	if len(results) != 3 {
+		t.Fatalf("SendToDevices() = %d results, want 3", len(results))
+	}
+	for i, result := range results[:2] {
+		if result.Err != nil || result.Response.GetGetAddrRes() == nil {
+			t.Errorf("device %d: response = %v, %v, want GetAddrRes", i, result.Response, result.Err)
+		}
+	}
+	if results[2].Err == nil {
+		t.Error("device with a mismatched cert was called")
+	}
+}
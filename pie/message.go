@@ -0,0 +1,112 @@
+package pie
+
+import (
+	"github.com/Pie-Messaging/core/pie/pb"
+	"reflect"
+	"time"
+)
+
+// responseTypes maps each request body to the body of its response. Requests missing here are one-way.
+var responseTypes = map[reflect.Type]reflect.Type{
+	reflect.TypeOf(&pb.NetMessage_GetAddrReq{}):             reflect.TypeOf(&pb.NetMessage_GetAddrRes{}),
+	reflect.TypeOf(&pb.NetMessage_FindTrackerReq{}):         reflect.TypeOf(&pb.NetMessage_FindTrackerRes{}),
+	reflect.TypeOf(&pb.NetMessage_FindResourceReq{}):        reflect.TypeOf(&pb.NetMessage_FindResourceRes{}),
+	reflect.TypeOf(&pb.NetMessage_PutResourceReq{}):         reflect.TypeOf(&pb.NetMessage_PutResourceRes{}),
+	reflect.TypeOf(&pb.NetMessage_AddContactReq{}):          reflect.TypeOf(&pb.NetMessage_AddContactRes{}),
+	reflect.TypeOf(&pb.NetMessage_AcceptContactAddingReq{}): reflect.TypeOf(&pb.NetMessage_AcceptContactAddingRes{}),
+	reflect.TypeOf(&pb.NetMessage_SendMessageReq{}):         reflect.TypeOf(&pb.NetMessage_SendMessageRes{}),
+	reflect.TypeOf(&pb.NetMessage_GetFileReq{}):             reflect.TypeOf(&pb.NetMessage_GetFileRes{}),
+	reflect.TypeOf(&pb.NetMessage_SendFileReq{}):            reflect.TypeOf(&pb.NetMessage_SendFileRes{}),
+	reflect.TypeOf(&pb.NetMessage_PunchReq{}):               reflect.TypeOf(&pb.NetMessage_PunchRes{}),
+	reflect.TypeOf(&pb.NetMessage_RelayReq{}):               reflect.TypeOf(&pb.NetMessage_RelayRes{}),
+	reflect.TypeOf(&pb.NetMessage_RelayAcceptReq{}):         reflect.TypeOf(&pb.NetMessage_RelayAcceptRes{}),
+}
+
+// IsIdempotent reports whether message may be replayed safely and so may be sent as 0-RTT early data.
+func IsIdempotent(message *pb.NetMessage) bool {
+	switch message.Body.(type) {
+	case *pb.NetMessage_GetAddrReq, *pb.NetMessage_FindTrackerReq, *pb.NetMessage_FindResourceReq:
+		return true
+	}
+	return false
+}
+
+// ValidateMessage checks that exactly one known body is set, which also rejects bodies unknown to this version.
+func ValidateMessage(message *pb.NetMessage) error {
+	if message == nil || message.Body == nil {
+		return ErrInvalidMsg
+	}
+	body := reflect.ValueOf(message.Body).Elem()
+	if body.NumField() != 1 || body.Field(0).IsNil() {
+		return ErrInvalidMsg
+	}
+	return nil
+}
+
+// HasResponse reports whether the peer answers request with a response on the same stream.
+func HasResponse(request *pb.NetMessage) bool {
+	_, ok := responseTypes[reflect.TypeOf(request.Body)]
+	return ok
+}
+
+// IsResponse reports whether response is the kind of message that answers request.
+func IsResponse(request *pb.NetMessage, response *pb.NetMessage) bool {
+	responseType, ok := responseTypes[reflect.TypeOf(request.Body)]
+	return ok && response != nil && reflect.TypeOf(response.Body) == responseType
+}
+
+// Call sends request on a new stream and, unless it is one-way, waits for the matching response.
+func (s *Session) Call(request *pb.NetMessage, recvTimeout time.Duration) (*pb.NetMessage, error) {
+	if err := ValidateMessage(request); err != nil {
+		return nil, err
+	}
+	stream, err := s.OpenStream()
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+	if err := stream.SendMessage(request); err != nil {
+		return nil, err
+	}
+	if !HasResponse(request) {
+		return nil, nil
+	}
+	response, err := stream.RecvMessage(time.Now().Add(recvTimeout))
+	if err != nil {
+		return nil, err
+	}
+	if !IsResponse(request, response) {
+		Logger.Println("Failed to call: unexpected response")
+		return nil, ErrInvalidMsg
+	}
+	return response, nil
+}
+
+// PutResource stores resource on the peer, which answers with the outcome.
+func (s *Session) PutResource(resourceType pb.ResourceType, resource *pb.Resource, recvTimeout time.Duration) error {
+	stream, err := s.OpenStream()
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	err = stream.SendMessage(&pb.NetMessage{Body: &pb.NetMessage_PutResourceReq{
+		PutResourceReq: &pb.PutResourceReq{Type: resourceType, Resource: resource},
+	}})
+	if err != nil {
+		return err
+	}
+	message, err := stream.RecvMessage(time.Now().Add(recvTimeout))
+	if err != nil {
+		return err
+	}
+	putResourceRes := message.GetPutResourceRes()
+	if putResourceRes == nil {
+		Logger.Println("Failed to put resource: unexpected response")
+		return ErrInvalidMsg
+	}
+	if putResourceRes.Status != pb.Status_OK {
+		Logger.Println("Failed to put resource:", putResourceRes.Status)
+		return ErrStatus
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package pie
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// quic-go does not migrate connections, and a session whose NAT binding changed is lost, since the peer keeps
+// sending to the old one. WatchPath thus probes the peer for our reflexive address every interval, which keeps the
+// binding alive even when QUIC keep-alives are disabled, and reports when the NAT rebound anyway. Local address
+// changes are observed by polling the local interfaces.
+
+const (
+	PathLocalChanged = iota
+	// PathReflexiveChanged means the peer observes us at another address, so the NAT binding changed
+	PathReflexiveChanged
+	PathClosed
+)
+
+type PathEvent struct {
+	Type int
+	Old  []string
+	New  []string
+}
+
+// WatchPath reports the path changes of the session until ctx is done or the session closes. The peer must answer
+// GetAddrReq, as trackers do, and interval should stay below the UDP timeout of NATs, commonly 30 seconds.
+func (s *Session) WatchPath(ctx context.Context, interval time.Duration, recvTimeout time.Duration) <-chan PathEvent {
+	events := make(chan PathEvent, 1)
+	go func() {
+		defer close(events)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		localAddrs := LocalAddrs(0)
+		reflexiveAddr := ""
+		send := func(event PathEvent) bool {
+			select {
+			case events <- event:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-s.Session.Context().Done():
+				send(PathEvent{Type: PathClosed, Old: []string{reflexiveAddr}})
+				return
+			case <-ticker.C:
+			}
+			if addrs := LocalAddrs(0); !equalStrings(addrs, localAddrs) {
+				if !send(PathEvent{Type: PathLocalChanged, Old: localAddrs, New: addrs}) {
+					return
+				}
+				localAddrs = addrs
+			}
+			addrList, err := s.GetAddr(recvTimeout)
+			if err != nil {
+				continue
+			}
+			if addr := addrList[0]; addr != reflexiveAddr {
+				if reflexiveAddr != "" && !send(PathEvent{Type: PathReflexiveChanged, Old: []string{reflexiveAddr}, New: []string{addr}}) {
+					return
+				}
+				reflexiveAddr = addr
+			}
+		}
+	}()
+	return events
+}
+
+// LocalAddrs lists the non-loopback addresses of this host, joined with port unless it is 0. Private and CGNAT
+// addresses are included, since a change of them moves us to another network all the same.
+func LocalAddrs(port int) []string {
+	interfaceAddrs, err := net.InterfaceAddrs()
+	if err != nil {
+		Logger.Println("Failed to get interface addresses:", err)
+		return nil
+	}
+	addrs := make([]string, 0, len(interfaceAddrs))
+	for _, interfaceAddr := range interfaceAddrs {
+		ipNet, ok := interfaceAddr.(*net.IPNet)
+		if !ok || ipNet.IP.IsLoopback() {
+			continue
+		}
+		if port == 0 {
+			addrs = append(addrs, ipNet.IP.String())
+		} else {
+			addrs = append(addrs, net.JoinHostPort(ipNet.IP.String(), strconv.Itoa(port)))
+		}
+	}
+	sort.Strings(addrs)
+	return addrs
+}
@@ -0,0 +1,226 @@
+package pie
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/Pie-Messaging/core/pie/pb"
+	"sync"
+	"time"
+)
+
+// Rendezvous is kept by a tracker to relay PunchReq from one connected user to another. Users register the sessions
+// they authenticated with ClientCertReq, one per device, and stay registered until the session is closed.
+type Rendezvous struct {
+	// sessions lists the devices of each user in the order they registered
+	sessions map[IDA][]deviceSession
+	ids      map[*Session]deviceSession
+	mutex    sync.RWMutex
+}
+
+type deviceSession struct {
+	user    IDA
+	device  IDA
+	session *Session
+}
+
+func NewRendezvous() *Rendezvous {
+	return &Rendezvous{sessions: make(map[IDA][]deviceSession), ids: make(map[*Session]deviceSession)}
+}
+
+// Register makes session the one the device with deviceID of the user with id is reached on, until it is closed or
+// the device registers another. deviceID defaults to id, for a user connecting with its own cert.
+func (r *Rendezvous) Register(id []byte, session *Session, deviceID ...[]byte) {
+	registered := deviceSession{user: BytesToIDA(id), device: BytesToIDA(id), session: session}
+	if len(deviceID) != 0 {
+		registered.device = BytesToIDA(deviceID[0])
+	}
+	r.mutex.Lock()
+	devices := r.sessions[registered.user]
+	for i, device := range devices {
+		if device.device == registered.device {
+			devices = append(devices[:i:i], devices[i+1:]...)
+			break
+		}
+	}
+	r.sessions[registered.user] = append(devices, registered)
+	r.ids[session] = registered
+	r.mutex.Unlock()
+	go func() {
+		<-session.Session.Context().Done()
+		r.Unregister(id, session)
+	}()
+}
+
+func (r *Rendezvous) Unregister(id []byte, session *Session) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	user := BytesToIDA(id)
+	var devices []deviceSession
+	for _, device := range r.sessions[user] {
+		if device.session != session {
+			devices = append(devices, device)
+		}
+	}
+	if len(devices) == 0 {
+		delete(r.sessions, user)
+	} else {
+		r.sessions[user] = devices
+	}
+	if r.ids[session].user == user {
+		delete(r.ids, session)
+	}
+}
+
+// Session returns the session of the device of the user with id that registered last, or nil.
+func (r *Rendezvous) Session(id []byte) *Session {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	devices := r.sessions[BytesToIDA(id)]
+	if len(devices) == 0 {
+		return nil
+	}
+	return devices[len(devices)-1].session
+}
+
+// Sessions returns the sessions of all registered devices of the user with id, in the order they registered.
+func (r *Rendezvous) Sessions(id []byte) []*Session {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	devices := r.sessions[BytesToIDA(id)]
+	sessions := make([]*Session, 0, len(devices))
+	for _, device := range devices {
+		sessions = append(sessions, device.session)
+	}
+	return sessions
+}
+
+// ID returns the ID of the user that authenticated on session, or false if none did.
+func (r *Rendezvous) ID(session *Session) ([]byte, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	registered, ok := r.ids[session]
+	return registered.user[:], ok
+}
+
+// HandlePunchReq tells the requested peer to dial the requester and answers with the peer's address,
+// so that both sides can dial each other at the same time. Only registered users may request.
+func (r *Rendezvous) HandlePunchReq(session *Session, stream *Stream, req *pb.PunchReq) error {
+	reply := func(status pb.Status, addrList ...string) error {
+		return stream.SendMessage(&pb.NetMessage{Body: &pb.NetMessage_PunchRes{
+			PunchRes: &pb.PunchRes{Status: status, Addresses: addrList},
+		}})
+	}
+	id, ok := r.ID(session)
+	if !ok {
+		return reply(pb.Status_CERT_ERROR)
+	}
+	peer := r.Session(req.Id)
+	if peer == nil {
+		return reply(pb.Status_NOT_FOUND)
+	}
+	notifyStream, err := peer.OpenStream()
+	if err != nil {
+		return reply(pb.Status_INTERNAL_ERROR)
+	}
+	err = notifyStream.SendMessage(&pb.NetMessage{Body: &pb.NetMessage_PunchNotify{
+		PunchNotify: &pb.PunchNotify{
+			Id:        id,
+			Addresses: appendUnique([]string{session.Session.RemoteAddr().String()}, req.Addresses...),
+		},
+	}})
+	notifyStream.Close()
+	if err != nil {
+		return reply(pb.Status_INTERNAL_ERROR)
+	}
+	return reply(pb.Status_OK, peer.Session.RemoteAddr().String())
+}
+
+// RequestPunch learns our reflexive address from tracker, asks it to notify the peer and dials the peer. tracker must
+// be dialed with Dial and authenticated with SendCert, so that the addresses both sides learn are the mappings of the
+// socket that punches.
+func (s *Server) RequestPunch(ctx context.Context, tracker *Session, peerID []byte, tlsConfig *tls.Config, recvTimeout time.Duration) (*Session, error) {
+	if tracker.Session.LocalAddr().String() != s.Listener.Addr().String() {
+		Logger.Println("Failed to request punch:", ErrSocketMismatch)
+		return nil, ErrSocketMismatch
+	}
+	addrList, err := tracker.GetAddr(recvTimeout)
+	if err != nil {
+		return nil, err
+	}
+	stream, err := tracker.OpenStream()
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+	err = stream.SendMessage(&pb.NetMessage{Body: &pb.NetMessage_PunchReq{
+		PunchReq: &pb.PunchReq{Id: peerID, Addresses: addrList},
+	}})
+	if err != nil {
+		return nil, err
+	}
+	message, err := stream.RecvMessage(time.Now().Add(recvTimeout))
+	if err != nil {
+		return nil, err
+	}
+	punchRes := message.GetPunchRes()
+	if punchRes == nil {
+		Logger.Println("Failed to request punch: unexpected response")
+		return nil, ErrInvalidMsg
+	}
+	if punchRes.Status != pb.Status_OK {
+		Logger.Println("Failed to request punch:", punchRes.Status)
+		return nil, ErrStatus
+	}
+	return s.Punch(ctx, tlsConfig, punchRes.Addresses)
+}
+
+// HandlePunchNotify dials the requester from the listening socket, so that our NAT maps its address and lets its
+// simultaneous dial in. The requester keeps the session it dialed, so a session established here is closed with
+// SessErrDuplicate and both sides end up with one.
+func (s *Server) HandlePunchNotify(ctx context.Context, tlsConfig *tls.Config, notify *pb.PunchNotify) error {
+	session, err := s.Punch(ctx, tlsConfig, notify.Addresses)
+	if err != nil {
+		return err
+	}
+	session.Close(SessErrDuplicate)
+	return nil
+}
+
+// Punch dials every address from the listening socket at once and keeps the first session established.
+// The peer dials us simultaneously, so the outgoing packets open the mapping for its incoming ones.
+func (s *Server) Punch(ctx context.Context, tlsConfig *tls.Config, addrList []string) (*Session, error) {
+	if len(addrList) == 0 {
+		return nil, ErrNoAddr
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan *Session, len(addrList))
+	for _, addr := range addrList {
+		addr := addr
+		go func() {
+			session, err := s.Dial(ctx, tlsConfig, addr)
+			if err != nil {
+				results <- nil
+				return
+			}
+			results <- session
+		}()
+	}
+	var result *Session
+	for range addrList {
+		session := <-results
+		if session == nil {
+			continue
+		}
+		if result == nil {
+			result = session
+			cancel()
+			continue
+		}
+		session.Close(SessErrNoReason)
+	}
+	if result == nil {
+		return nil, ErrNoAddr
+	}
+	return result, nil
+}
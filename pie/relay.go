@@ -0,0 +1,201 @@
+package pie
+
+import (
+	"crypto/tls"
+	"errors"
+	"github.com/Pie-Messaging/core/pie/pb"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Relay splices streams between two users registered in Rendezvous when they cannot connect directly.
+// Each user may push at most Quota bytes through the relay per QuotaWindow, or any amount if Quota is 0. A relay
+// whose streams carried no data in either direction for IdleTimeout is closed, unless IdleTimeout is 0.
+type Relay struct {
+	Rendezvous  *Rendezvous
+	Quota       int64
+	QuotaWindow time.Duration
+	IdleTimeout time.Duration
+	usage       map[IDA]*relayUsage
+	// sweepAt is when the expired usage windows are deleted next
+	sweepAt time.Time
+	mutex   sync.Mutex
+}
+
+type relayUsage struct {
+	bytes   int64
+	resetAt time.Time
+}
+
+func NewRelay(rendezvous *Rendezvous, quota int64, quotaWindow time.Duration, idleTimeout time.Duration) *Relay {
+	return &Relay{
+		Rendezvous:  rendezvous,
+		Quota:       quota,
+		QuotaWindow: quotaWindow,
+		IdleTimeout: idleTimeout,
+		usage:       make(map[IDA]*relayUsage),
+	}
+}
+
+// HandleRelayReq asks the requested peer to accept and then splices the two streams until either side closes.
+// It blocks for the lifetime of the relay. Only users registered on session may request.
+func (r *Relay) HandleRelayReq(session *Session, stream *Stream, req *pb.RelayReq, recvTimeout time.Duration) error {
+	reply := func(status pb.Status) error {
+		return stream.SendMessage(&pb.NetMessage{Body: &pb.NetMessage_RelayRes{RelayRes: &pb.RelayRes{Status: status}}})
+	}
+	id, ok := r.Rendezvous.ID(session)
+	if !ok {
+		return reply(pb.Status_CERT_ERROR)
+	}
+	if !r.charge(id, 0) {
+		return reply(pb.Status_TOO_MANY_REQUESTS)
+	}
+	peer := r.Rendezvous.Session(req.Id)
+	if peer == nil {
+		return reply(pb.Status_NOT_FOUND)
+	}
+	peerStream, err := peer.OpenStream()
+	if err != nil {
+		return reply(pb.Status_INTERNAL_ERROR)
+	}
+	err = peerStream.SendMessage(&pb.NetMessage{Body: &pb.NetMessage_RelayAcceptReq{RelayAcceptReq: &pb.RelayAcceptReq{Id: id}}})
+	if err != nil {
+		peerStream.Close()
+		return reply(pb.Status_INTERNAL_ERROR)
+	}
+	message, err := peerStream.RecvMessage(time.Now().Add(recvTimeout))
+	if err != nil || message.GetRelayAcceptRes().GetStatus() != pb.Status_OK {
+		peerStream.Close()
+		return reply(pb.Status_USER_REJECTED)
+	}
+	if err = reply(pb.Status_OK); err != nil {
+		peerStream.Close()
+		return err
+	}
+	lastActive := time.Now().UnixNano()
+	wg := &sync.WaitGroup{}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		r.pipe(stream, peerStream, id, &lastActive)
+	}()
+	go func() {
+		defer wg.Done()
+		r.pipe(peerStream, stream, req.Id, &lastActive)
+	}()
+	wg.Wait()
+	return nil
+}
+
+// pipe copies raw bytes from src to dst, charging them to the user id owning src. lastActive is shared by both
+// directions and holds the UnixNano time data last passed, so that a relay is only idle when both directions are.
+func (r *Relay) pipe(src *Stream, dst *Stream, id []byte, lastActive *int64) {
+	data := src.Buffered()
+	var readErr error
+	for {
+		if len(data) > 0 {
+			atomic.StoreInt64(lastActive, time.Now().UnixNano())
+			if !r.charge(id, int64(len(data))) {
+				Logger.Println("Relay quota exceeded")
+				src.Stream.CancelRead(StreamErrQuotaExceeded)
+				dst.Stream.CancelWrite(StreamErrQuotaExceeded)
+				return
+			}
+			if _, err := dst.Stream.Write(data); err != nil {
+				Logger.Println("Failed to write to stream:", err)
+				src.Stream.CancelRead(StreamErrRelayClosed)
+				return
+			}
+		}
+		var netErr net.Error
+		if errors.As(readErr, &netErr) && netErr.Timeout() {
+			idle := time.Since(time.Unix(0, atomic.LoadInt64(lastActive)))
+			if idle >= r.IdleTimeout {
+				Logger.Println("Relay idle for", idle)
+				src.Stream.CancelRead(StreamErrRelayIdle)
+				dst.Stream.CancelWrite(StreamErrRelayIdle)
+				return
+			}
+			readErr = nil
+		}
+		if readErr != nil {
+			dst.Close()
+			return
+		}
+		if r.IdleTimeout > 0 {
+			_ = src.Stream.SetReadDeadline(time.Now().Add(r.IdleTimeout))
+		}
+		var n int
+		n, readErr = src.Stream.Read(src.recvBuf)
+		data = src.recvBuf[:n]
+	}
+}
+
+func (r *Relay) charge(id []byte, n int64) bool {
+	if r.Quota == 0 {
+		return true
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	now := time.Now()
+	if now.After(r.sweepAt) {
+		for ida, usage := range r.usage {
+			if now.After(usage.resetAt) {
+				delete(r.usage, ida)
+			}
+		}
+		r.sweepAt = now.Add(r.QuotaWindow)
+	}
+	ida := BytesToIDA(id)
+	usage, ok := r.usage[ida]
+	if !ok || now.After(usage.resetAt) {
+		usage = &relayUsage{resetAt: now.Add(r.QuotaWindow)}
+		r.usage[ida] = usage
+	}
+	if usage.bytes+n > r.Quota {
+		return false
+	}
+	usage.bytes += n
+	return true
+}
+
+// RequestRelay asks tracker to relay to peerID and secures the relayed stream end to end.
+func RequestRelay(tracker *Session, peerID []byte, cert *tls.Certificate, recvTimeout time.Duration) (*SecureStream, error) {
+	stream, err := tracker.OpenStream()
+	if err != nil {
+		return nil, err
+	}
+	err = stream.SendMessage(&pb.NetMessage{Body: &pb.NetMessage_RelayReq{RelayReq: &pb.RelayReq{Id: peerID}}})
+	if err != nil {
+		stream.Close()
+		return nil, err
+	}
+	message, err := stream.RecvMessage(time.Now().Add(recvTimeout))
+	if err != nil {
+		stream.Close()
+		return nil, err
+	}
+	relayRes := message.GetRelayRes()
+	if relayRes == nil || relayRes.Status != pb.Status_OK {
+		Logger.Println("Failed to request relay:", relayRes.GetStatus())
+		stream.Close()
+		return nil, ErrStatus
+	}
+	secureStream, err := NewSecureStream(stream, cert, peerID, time.Now().Add(recvTimeout))
+	if err != nil {
+		stream.Close()
+		return nil, err
+	}
+	return secureStream, nil
+}
+
+// AcceptRelay accepts a RelayAcceptReq received from a tracker on stream.
+func AcceptRelay(stream *Stream, req *pb.RelayAcceptReq, cert *tls.Certificate, recvTimeout time.Duration) (*SecureStream, error) {
+	err := stream.SendMessage(&pb.NetMessage{Body: &pb.NetMessage_RelayAcceptRes{RelayAcceptRes: &pb.RelayAcceptRes{Status: pb.Status_OK}}})
+	if err != nil {
+		return nil, err
+	}
+	return NewSecureStream(stream, cert, req.Id, time.Now().Add(recvTimeout))
+}
@@ -0,0 +1,29 @@
+package pie
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRelayCharge(t *testing.T) {
+	id := []byte{1}
+	unlimited := NewRelay(NewRendezvous(), 0, time.Hour, 0)
+	if !unlimited.charge(id, 1<<40) {
+		t.Fatal("charge() = false without a quota")
+	}
+
+	relay := NewRelay(NewRendezvous(), 10, 20*time.Millisecond, 0)
+	if !relay.charge(id, 10) || relay.charge(id, 1) {
+		t.Fatal("quota is not enforced")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if !relay.charge([]byte{2}, 1) {
+		t.Fatal("charge() = false for another user")
+	}
+	if _, exists := relay.usage[BytesToIDA(id)]; exists {
+		t.Fatal("expired usage window is not deleted")
+	}
+	if !relay.charge(id, 10) {
+		t.Fatal("quota is not reset after the window")
+	}
+}
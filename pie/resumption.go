@@ -0,0 +1,156 @@
+package pie
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"github.com/lucas-clemente/quic-go"
+	"os"
+	"sync"
+)
+
+const (
+	SessionCacheSize = 64
+	TokenStoreSize   = 16
+)
+
+var (
+	ErrSessionState = errors.New("unsupported session state")
+)
+
+var (
+	defaultResumption      = NewResumption(nil)
+	defaultResumptionMutex sync.RWMutex
+)
+
+// DefaultResumption returns the resumption state shared by the sessions that connect without their own.
+func DefaultResumption() *Resumption {
+	defaultResumptionMutex.RLock()
+	defer defaultResumptionMutex.RUnlock()
+	return defaultResumption
+}
+
+// SetDefaultResumption replaces the shared resumption state; sessions connected before keep the previous one.
+func SetDefaultResumption(r *Resumption) {
+	defaultResumptionMutex.Lock()
+	defer defaultResumptionMutex.Unlock()
+	defaultResumption = r
+}
+
+// ResumptionStore persists TLS session tickets so that 0-RTT survives process restarts. QUIC address validation
+// tokens are opaque in quic-go and only live in memory.
+type ResumptionStore interface {
+	Load() (map[string][]byte, error)
+	Save(key string, state []byte) error
+}
+
+type Resumption struct {
+	SessionCache tls.ClientSessionCache
+	TokenStore   quic.TokenStore
+	store        ResumptionStore
+}
+
+func NewResumption(store ResumptionStore) *Resumption {
+	r := &Resumption{
+		SessionCache: tls.NewLRUClientSessionCache(SessionCacheSize),
+		TokenStore:   quic.NewLRUTokenStore(SessionCacheSize, TokenStoreSize),
+		store:        store,
+	}
+	if store == nil {
+		return r
+	}
+	states, err := store.Load()
+	if err != nil {
+		Logger.Println("Failed to load session tickets:", err)
+	}
+	for key, data := range states {
+		state, err := decodeSessionState(data)
+		if err != nil {
+			Logger.Println("Failed to decode session ticket:", err)
+			continue
+		}
+		r.SessionCache.Put(key, state)
+	}
+	r.SessionCache = &persistentSessionCache{ClientSessionCache: r.SessionCache, store: store}
+	return r
+}
+
+// apply returns a copy of tlsConfig using r's session cache unless the caller brought its own.
+func (r *Resumption) apply(tlsConfig *tls.Config, quicConfig *quic.Config) *tls.Config {
+	quicConfig.TokenStore = r.TokenStore
+	if tlsConfig.ClientSessionCache != nil {
+		return tlsConfig
+	}
+	tlsConfig = tlsConfig.Clone()
+	tlsConfig.ClientSessionCache = r.SessionCache
+	return tlsConfig
+}
+
+type persistentSessionCache struct {
+	tls.ClientSessionCache
+	store ResumptionStore
+}
+
+func (c *persistentSessionCache) Put(key string, state *tls.ClientSessionState) {
+	c.ClientSessionCache.Put(key, state)
+	var data []byte
+	if state != nil {
+		var err error
+		if data, err = encodeSessionState(state); err != nil {
+			Logger.Println("Failed to encode session ticket:", err)
+			return
+		}
+	}
+	if err := c.store.Save(key, data); err != nil {
+		Logger.Println("Failed to save session ticket:", err)
+	}
+}
+
+type FileResumptionStore struct {
+	Path  string
+	mutex sync.Mutex
+}
+
+func NewFileResumptionStore(path string) *FileResumptionStore {
+	return &FileResumptionStore{Path: path}
+}
+
+func (f *FileResumptionStore) Load() (map[string][]byte, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.load()
+}
+
+func (f *FileResumptionStore) Save(key string, state []byte) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	states, err := f.load()
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		delete(states, key)
+	} else {
+		states[key] = state
+	}
+	data, err := json.Marshal(states)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(f.Path, data, 0o600)
+}
+
+func (f *FileResumptionStore) load() (map[string][]byte, error) {
+	states := make(map[string][]byte)
+	data, err := os.ReadFile(f.Path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return states, nil
+		}
+		return nil, err
+	}
+	if err = json.Unmarshal(data, &states); err != nil {
+		return nil, err
+	}
+	return states, nil
+}
@@ -0,0 +1,96 @@
+package pie
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"time"
+	"unsafe"
+)
+
+// clientSessionState is the state quic-go v0.26 stores behind the *tls.ClientSessionState it passes to the session
+// cache: its qtls fork allocates this struct, which is the tls.ClientSessionState of Go 1.18, and casts it the same
+// way. The ticket nonce also carries the transport parameters quic-go needs for 0-RTT.
+type clientSessionState struct {
+	sessionTicket      []uint8
+	vers               uint16
+	cipherSuite        uint16
+	masterSecret       []byte
+	serverCertificates []*x509.Certificate
+	verifiedChains     [][]*x509.Certificate
+	receivedAt         time.Time
+	ocspResponse       []byte
+	scts               [][]byte
+	nonce              []byte
+	useBy              time.Time
+	ageAdd             uint32
+}
+
+// encodedSessionState is the persisted form of clientSessionState. The verified chains are left out, since the peer
+// certificates are verified by VerifyPeerCertificate and not against roots.
+type encodedSessionState struct {
+	Ticket       []byte    `json:"ticket"`
+	Version      uint16    `json:"version"`
+	CipherSuite  uint16    `json:"cipher_suite"`
+	MasterSecret []byte    `json:"master_secret"`
+	Certificates [][]byte  `json:"certificates"`
+	ReceivedAt   time.Time `json:"received_at"`
+	OCSPResponse []byte    `json:"ocsp_response,omitempty"`
+	SCTs         [][]byte  `json:"scts,omitempty"`
+	Nonce        []byte    `json:"nonce"`
+	UseBy        time.Time `json:"use_by"`
+	AgeAdd       uint32    `json:"age_add"`
+}
+
+func encodeSessionState(state *tls.ClientSessionState) ([]byte, error) {
+	s := (*clientSessionState)(unsafe.Pointer(state))
+	if len(s.sessionTicket) == 0 || s.vers != tls.VersionTLS13 {
+		return nil, ErrSessionState
+	}
+	encoded := encodedSessionState{
+		Ticket:       s.sessionTicket,
+		Version:      s.vers,
+		CipherSuite:  s.cipherSuite,
+		MasterSecret: s.masterSecret,
+		ReceivedAt:   s.receivedAt,
+		OCSPResponse: s.ocspResponse,
+		SCTs:         s.scts,
+		Nonce:        s.nonce,
+		UseBy:        s.useBy,
+		AgeAdd:       s.ageAdd,
+	}
+	for _, cert := range s.serverCertificates {
+		encoded.Certificates = append(encoded.Certificates, cert.Raw)
+	}
+	return json.Marshal(encoded)
+}
+
+func decodeSessionState(data []byte) (*tls.ClientSessionState, error) {
+	var encoded encodedSessionState
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return nil, err
+	}
+	if len(encoded.Ticket) == 0 || encoded.Version != tls.VersionTLS13 || len(encoded.Certificates) == 0 {
+		return nil, ErrSessionState
+	}
+	s := &clientSessionState{
+		sessionTicket: encoded.Ticket,
+		vers:          encoded.Version,
+		cipherSuite:   encoded.CipherSuite,
+		masterSecret:  encoded.MasterSecret,
+		receivedAt:    encoded.ReceivedAt,
+		ocspResponse:  encoded.OCSPResponse,
+		scts:          encoded.SCTs,
+		nonce:         encoded.Nonce,
+		useBy:         encoded.UseBy,
+		ageAdd:        encoded.AgeAdd,
+	}
+	for _, certDER := range encoded.Certificates {
+		cert, err := x509.ParseCertificate(certDER)
+		if err != nil {
+			return nil, err
+		}
+		s.serverCertificates = append(s.serverCertificates, cert)
+	}
+	return (*tls.ClientSessionState)(unsafe.Pointer(s)), nil
+}
@@ -0,0 +1,85 @@
+package pie
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// connectResumed connects to server with the tickets of store, as a process started anew would, and returns whether
+// the session resumed once its handshake completed.
+func connectResumed(t *testing.T, server *Server, store ResumptionStore) bool {
+	t.Helper()
+	SetDefaultResumption(NewResumption(store))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	tlsConfig := &tls.Config{NextProtos: []string{UserTLSProto}, InsecureSkipVerify: true}
+	session, err := Connect(ctx, tlsConfig, server.Config, server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close(SessErrNoReason)
+	select {
+	case <-session.Session.HandshakeComplete().Done():
+	case <-ctx.Done():
+		t.Fatal("handshake did not complete")
+	}
+	// The server sends the ticket after the handshake
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if states, err := store.Load(); err == nil && len(states) != 0 {
+			break
+		}
+	}
+	return session.Session.ConnectionState().TLS.DidResume
+}
+
+func TestResumptionStore(t *testing.T) {
+	// Clients do not resume with an expired cert, and GenerateKeyPair leaves NotAfter zero
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{SerialNumber: big.NewInt(1), NotAfter: time.Now().Add(time.Hour)}
+	certDER, err := x509.CreateCertificate(rand.Reader, &template, &template, publicKey, privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := tls.Certificate{Certificate: [][]byte{certDER}, PrivateKey: privateKey}
+	serverTLSConfig := &tls.Config{Certificates: []tls.Certificate{cert}, NextProtos: []string{UserTLSProto}}
+	server, err := ListenNet("127.0.0.1:0", serverTLSConfig, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Listener.Close()
+	previous := DefaultResumption()
+	defer SetDefaultResumption(previous)
+
+	store := NewFileResumptionStore(filepath.Join(t.TempDir(), "tickets.json"))
+	if connectResumed(t, server, store) {
+		t.Fatal("first session resumed")
+	}
+	states, err := store.Load()
+	if err != nil || len(states) != 1 {
+		t.Fatalf("Load() = %d tickets, %v, want 1", len(states), err)
+	}
+	// A new process loads the ticket and resumes with it
+	if !connectResumed(t, server, NewFileResumptionStore(store.Path)) {
+		t.Fatal("session with a loaded ticket did not resume")
+	}
+}
+
+func TestDefaultResumption(t *testing.T) {
+	previous := DefaultResumption()
+	defer SetDefaultResumption(previous)
+	resumption := NewResumption(nil)
+	SetDefaultResumption(resumption)
+	if DefaultResumption() != resumption {
+		t.Fatal("DefaultResumption() does not return the one set")
+	}
+}
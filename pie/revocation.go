@@ -0,0 +1,123 @@
+package pie
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"github.com/Pie-Messaging/core/pie/pb"
+	"sync"
+	"time"
+)
+
+const (
+	RevocationSignPrefix = "pie revocation:"
+	MaxRotationChain     = 16
+)
+
+var (
+	ErrRevoked = errors.New("certificate revoked")
+)
+
+// DefaultRevocations is consulted by servers and lookups unless they are given their own list.
+var DefaultRevocations = NewRevocationList()
+
+// NewRevocation revokes cert, signed by its own key. A non-empty newCertDER rotates the identity to that
+// certificate, an empty one revokes it for good.
+func NewRevocation(cert *tls.Certificate, newCertDER []byte) (*pb.Revocation, error) {
+	revocation := &pb.Revocation{
+		Id:         HashBytes(cert.Certificate[0], IDLen),
+		CertDer:    cert.Certificate[0],
+		NewCertDer: newCertDER,
+		Time:       time.Now().Unix(),
+	}
+	sign, err := cert.PrivateKey.(crypto.Signer).Sign(rand.Reader, revocationSignData(revocation), crypto.Hash(0))
+	if err != nil {
+		Logger.Println("Failed to sign revocation:", err)
+		return nil, err
+	}
+	revocation.Sign = sign
+	return revocation, nil
+}
+
+// VerifyRevocation checks that revocation is signed by the key it revokes and stored under that key's ID.
+func VerifyRevocation(revocation *pb.Revocation) error {
+	if !bytes.Equal(revocation.Id, HashBytes(revocation.CertDer, IDLen)) {
+		return ErrPeerMismatch
+	}
+	publicKey, err := certPublicKey(revocation.CertDer)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(publicKey, revocationSignData(revocation), revocation.Sign) {
+		Logger.Println("Failed to verify revocation:", ErrBadSign)
+		return ErrBadSign
+	}
+	return nil
+}
+
+// RevocationList holds verified revocations keyed by the ID of the revoked certificate.
+type RevocationList struct {
+	revocations map[IDA]*pb.Revocation
+	mutex       sync.RWMutex
+}
+
+func NewRevocationList() *RevocationList {
+	return &RevocationList{revocations: make(map[IDA]*pb.Revocation)}
+}
+
+// Add verifies and stores revocation. Two different rotations of the same key mean the key is in other hands,
+// so the certificate is then revoked with no successor.
+func (l *RevocationList) Add(revocation *pb.Revocation) error {
+	if err := VerifyRevocation(revocation); err != nil {
+		return err
+	}
+	id := BytesToIDA(revocation.Id)
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if existing, ok := l.revocations[id]; ok && !bytes.Equal(existing.NewCertDer, revocation.NewCertDer) {
+		revocation = &pb.Revocation{Id: existing.Id, CertDer: existing.CertDer, Time: existing.Time}
+	}
+	l.revocations[id] = revocation
+	return nil
+}
+
+func (l *RevocationList) Get(certDER []byte) *pb.Revocation {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	return l.revocations[BytesToIDA(HashBytes(certDER, IDLen))]
+}
+
+// Check returns ErrRevoked if certDER has been revoked or rotated away from.
+func (l *RevocationList) Check(certDER []byte) error {
+	if l.Get(certDER) != nil {
+		return ErrRevoked
+	}
+	return nil
+}
+
+// Resolve follows rotations from certDER to the current certificate of the identity.
+func (l *RevocationList) Resolve(certDER []byte) ([]byte, error) {
+	for i := 0; i < MaxRotationChain; i++ {
+		revocation := l.Get(certDER)
+		if revocation == nil {
+			return certDER, nil
+		}
+		if len(revocation.NewCertDer) == 0 {
+			return nil, ErrRevoked
+		}
+		certDER = revocation.NewCertDer
+	}
+	return nil, ErrRevoked
+}
+
+func revocationSignData(revocation *pb.Revocation) []byte {
+	data := append([]byte(RevocationSignPrefix), HashBytes(revocation.CertDer, UserCertHashLen)...)
+	data = append(data, HashBytes(revocation.NewCertDer, UserCertHashLen)...)
+	timeBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(timeBytes, uint64(revocation.Time))
+	return append(data, timeBytes...)
+}
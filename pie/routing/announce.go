@@ -0,0 +1,80 @@
+package routing
+
+import (
+	"context"
+	"github.com/Pie-Messaging/core/pie"
+	"github.com/Pie-Messaging/core/pie/pb"
+	"google.golang.org/protobuf/proto"
+	"time"
+)
+
+// Announce puts user through the trackers responsible for its ID, with the addresses the closest known trackers observe
+// for the listening socket, so that peers reach us through the NAT. The table must dial from Server.
+func (r *Table) Announce(ctx context.Context, user *pb.User, recvTimeout time.Duration) error {
+	if r.Server == nil {
+		return pie.ErrSocketMismatch
+	}
+	id := pie.BytesToIDA(user.Id)
+	var sessions []*pie.Session
+	for _, tracker := range r.GetNeighbors(id, r.Config.Routing.Alpha) {
+		if session := r.session(ctx, tracker); session != nil {
+			sessions = append(sessions, session)
+		}
+	}
+	addrs, err := r.Server.PublicAddrs(sessions, recvTimeout)
+	if err != nil {
+		return err
+	}
+	user = proto.Clone(user).(*pb.User)
+	user.Addresses = addrs
+	resource := &pb.Resource{Resource: &pb.Resource_User{User: user}}
+	return r.PutResource(ctx, id, pb.ResourceType_USER, resource, r.Config.Routing.MetaDataRedundancy, recvTimeout)
+}
+
+// ReannounceOnPathChange announces user, and again each time the path to the tracker closest to its ID changes, until
+// ctx is done. The probes of Session.WatchPath every interval keep the NAT binding of the listening socket alive. A
+// session lost to a NAT rebinding nonetheless is dialed again, and user announced at its new address. A session lost
+// sooner than interval after announcing delays the next announce, doubling up to MaxReconnectDelay, so that a tracker
+// refusing us is not dialed in a tight loop.
+func (r *Table) ReannounceOnPathChange(ctx context.Context, user *pb.User, interval time.Duration, recvTimeout time.Duration) {
+	id := pie.BytesToIDA(user.Id)
+	maxDelay := time.Duration(r.Config.Routing.MaxReconnectDelay)
+	if maxDelay < interval {
+		maxDelay = interval
+	}
+	delay := interval
+	for ctx.Err() == nil {
+		start := time.Now()
+		if err := r.Announce(ctx, user, recvTimeout); err != nil {
+			pie.Logger.Println("Failed to announce user:", err)
+		}
+		var session *pie.Session
+		for _, tracker := range r.GetNeighbors(id, r.Config.Routing.Alpha) {
+			if session = r.session(ctx, tracker); session != nil {
+				break
+			}
+		}
+		if session != nil {
+			for event := range session.WatchPath(ctx, interval, recvTimeout) {
+				if event.Type == pie.PathClosed {
+					break
+				}
+				pie.Logger.Println("Path changed:", event.Old, "->", event.New)
+				if err := r.Announce(ctx, user, recvTimeout); err != nil {
+					pie.Logger.Println("Failed to announce user:", err)
+				}
+			}
+		}
+		if time.Since(start) >= interval {
+			delay = interval
+			continue
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > maxDelay {
+			delay = maxDelay
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package routing
+
+import (
+	"context"
+	"github.com/Pie-Messaging/core/pie"
+	"github.com/Pie-Messaging/core/pie/pb"
+	"testing"
+	"time"
+)
+
+// stored reports whether any of trackers stores the record of user, and clears it on them if clear is set.
+func stored(trackers []*simTracker, user *pb.User, clear bool) bool {
+	found := false
+	for _, tracker := range trackers {
+		table := tracker.table
+		table.resourceMutex.Lock()
+		key := resourceKey{id: pie.BytesToIDA(user.Id), resourceType: pb.ResourceType_USER}
+		if _, exists := table.resources[key]; exists {
+			found = true
+			if clear {
+				delete(table.resources, key)
+			}
+		}
+		table.resourceMutex.Unlock()
+	}
+	return found
+}
+
+func waitStored(t *testing.T, trackers []*simTracker, user *pb.User) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !stored(trackers, user, false); {
+		if time.Now().After(deadline) {
+			t.Fatal("user is not announced")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReannounceOnPathChange(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	trackers := newResourceTrackers(ctx, t)
+	announcer := trackers[1]
+	announcer.table.Config.Routing.ReconnectDelay = pie.Duration(10 * time.Millisecond)
+	recvTimeout := time.Duration(announcer.table.Config.Routing.RecvTimeout)
+	user, _ := newUserResource(t)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		announcer.table.ReannounceOnPathChange(ctx, user, 20*time.Millisecond, recvTimeout)
+	}()
+	waitStored(t, trackers, user)
+
+	// Losing the watched session, as to a NAT rebinding, announces user again
+	stored(trackers, user, true)
+	for _, tracker := range announcer.table.GetNeighbors(pie.BytesToIDA(user.Id), announcer.table.Config.Routing.Alpha) {
+		if session := tracker.Session(); session != nil {
+			session.Close(pie.SessErrNoReason)
+		}
+	}
+	waitStored(t, trackers, user)
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("ReannounceOnPathChange() does not return once ctx is done")
+	}
+}
@@ -0,0 +1,111 @@
+package routing
+
+import (
+	"context"
+	"github.com/Pie-Messaging/core/pie"
+	"math/rand"
+	"time"
+)
+
+type TrackerEventType int
+
+const (
+	TrackerConnected TrackerEventType = iota
+	TrackerDisconnected
+	TrackerRemoved
+)
+
+type TrackerEvent struct {
+	Type    TrackerEventType
+	Tracker *Tracker
+	// Err is the last connection error of a removed tracker
+	Err error
+}
+
+// SetEvents makes the table report tracker events to events only, or to none if it is nil. Events are dropped while
+// a channel is full, so that a slow reader does not stall routing.
+func (r *Table) SetEvents(events chan<- TrackerEvent) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.events = nil
+	if events != nil {
+		r.events = append(r.events, events)
+	}
+}
+
+// AddEvents makes the table report tracker events to events as well as to the channels added before.
+func (r *Table) AddEvents(events chan<- TrackerEvent) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.events = append(r.events, events)
+}
+
+// RemoveEvents stops reporting tracker events to events.
+func (r *Table) RemoveEvents(events chan<- TrackerEvent) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for i, added := range r.events {
+		if added == events {
+			r.events = append(r.events[:i:i], r.events[i+1:]...)
+			return
+		}
+	}
+}
+
+func (r *Table) emit(event TrackerEvent) {
+	r.mutex.RLock()
+	subscribers := r.events
+	r.mutex.RUnlock()
+	for _, events := range subscribers {
+		select {
+		case events <- event:
+		default:
+		}
+	}
+}
+
+// requestFailed counts a failed request to tracker, and closes its session after MaxFailures of them, so that the
+// next use dials it again, or removes it if that fails too. Requests failing because ctx was cancelled say nothing
+// about tracker and are not counted.
+func (r *Table) requestFailed(ctx context.Context, tracker *Tracker) {
+	if ctx.Err() != nil || r.ctx.Err() != nil {
+		return
+	}
+	if tracker.failed() < r.Config.Routing.MaxFailures {
+		return
+	}
+	if session := tracker.Session(); session != nil {
+		session.Close(pie.SessErrNoReason)
+	}
+}
+
+// misreported penalizes reporter for a candidate that does not hold the cert its ID hashes from. An honest tracker
+// may report one that changed its key behind the same address, so reporter is only removed and banned for BanTime
+// after MaxMisreports of them.
+func (r *Table) misreported(reporter *Tracker, err error) {
+	if reporter.misreported() < r.Config.Routing.MaxMisreports || r.GetTracker(reporter.ID) != reporter {
+		return
+	}
+	pie.Logger.Println("Banning tracker reporting forged candidates:", reporter.Addr)
+	r.mutex.Lock()
+	r.banned[reporter.ID] = time.Now().Add(time.Duration(r.Config.Routing.BanTime))
+	r.mutex.Unlock()
+	r.RemoveTracker(reporter.ID)
+	r.emit(TrackerEvent{Type: TrackerRemoved, Tracker: reporter, Err: err})
+}
+
+// reconnectDelay returns the jittered backoff after failures consecutive failures.
+func (r *Table) reconnectDelay(failures int) time.Duration {
+	delay := time.Duration(r.Config.Routing.ReconnectDelay)
+	maxDelay := time.Duration(r.Config.Routing.MaxReconnectDelay)
+	for i := 1; i < failures && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
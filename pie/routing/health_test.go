@@ -0,0 +1,170 @@
+package routing
+
+import (
+	"context"
+	"github.com/Pie-Messaging/core/pie"
+	"github.com/Pie-Messaging/core/pie/memnet"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// waitEvent returns the next event of type want for tracker, skipping others, such as the connection event of the
+// initial session, which may come after the events channel is set.
+func waitEvent(t *testing.T, events <-chan TrackerEvent, tracker pie.IDA, want TrackerEventType) TrackerEvent {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case event := <-events:
+			if event.Tracker.ID == tracker && event.Type == want {
+				return event
+			}
+		case <-timeout:
+			t.Fatalf("no event %v for tracker %x", want, tracker)
+		}
+	}
+}
+
+func newHealthTrackers(ctx context.Context, t *testing.T) (*simTracker, *simTracker, chan TrackerEvent) {
+	network := memnet.NewNetwork(1)
+	trackers := simulate(ctx, t, network, 2, rand.New(rand.NewSource(1)))
+	// a bootstrapped from b, so it knows b once it joined
+	a, b := trackers[1], trackers[0]
+	a.table.Config.Routing.ReconnectDelay = pie.Duration(10 * time.Millisecond)
+	a.table.Config.Routing.MaxReconnectDelay = pie.Duration(40 * time.Millisecond)
+	a.table.Config.Routing.MaxFailures = 3
+	events := make(chan TrackerEvent, 16)
+	a.table.SetEvents(events)
+	return a, b, events
+}
+
+func TestTrackerReconnect(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a, b, events := newHealthTrackers(ctx, t)
+	defer a.close()
+	defer b.close()
+	tracker := a.table.GetTracker(b.table.ID)
+	if tracker == nil || tracker.Session() == nil {
+		t.Fatal("tracker is not connected")
+	}
+	a.table.FindTracker(ctx, b.table.ID, 1, time.Second)
+	if tracker.RTT() <= 0 || tracker.Failures() != 0 {
+		t.Fatalf("RTT() = %v and Failures() = %d after an answer", tracker.RTT(), tracker.Failures())
+	}
+
+	tracker.Session().Close(pie.SessErrNoReason)
+	waitEvent(t, events, b.table.ID, TrackerDisconnected)
+	if tracker.Session() != nil {
+		t.Fatal("closed session is not dropped")
+	}
+	a.table.FindTracker(ctx, b.table.ID, 1, time.Second)
+	waitEvent(t, events, b.table.ID, TrackerConnected)
+	if a.table.GetTracker(b.table.ID) != tracker || tracker.Session() == nil {
+		t.Fatal("tracker is not reconnected on use")
+	}
+}
+
+func TestTrackerRemovedAfterFailures(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a, b, events := newHealthTrackers(ctx, t)
+	defer a.close()
+	tracker := a.table.GetTracker(b.table.ID)
+	b.close()
+	waitEvent(t, events, b.table.ID, TrackerDisconnected)
+	// Lookups dial b again, backing off between attempts
+	lookupCtx, stop := context.WithCancel(ctx)
+	defer stop()
+	go func() {
+		for lookupCtx.Err() == nil {
+			a.table.FindTracker(lookupCtx, b.table.ID, 1, time.Second)
+			time.Sleep(5 * time.Millisecond)
+		}
+	}()
+	if event := waitEvent(t, events, b.table.ID, TrackerRemoved); event.Err == nil {
+		t.Fatal("TrackerRemoved has no error")
+	}
+	if a.table.GetTracker(b.table.ID) != nil {
+		t.Fatal("removed tracker is still in the table")
+	}
+	if failures := tracker.Failures(); failures != 3 {
+		t.Fatalf("Failures() = %d, want 3", failures)
+	}
+}
+
+func TestCancelledRequestNotCounted(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a, b, _ := newHealthTrackers(ctx, t)
+	defer a.close()
+	defer b.close()
+	tracker := a.table.GetTracker(b.table.ID)
+	if tracker == nil || tracker.Session() == nil {
+		t.Fatal("tracker is not connected")
+	}
+	cancelled, cancelLookup := context.WithCancel(ctx)
+	cancelLookup()
+	for i := 0; i < a.table.Config.Routing.MaxFailures; i++ {
+		a.table.requestFailed(cancelled, tracker)
+	}
+	if tracker.Failures() != 0 || tracker.Session() == nil {
+		t.Fatalf("Failures() = %d after requests of a cancelled lookup, want 0 and the session open", tracker.Failures())
+	}
+}
+
+func TestUnseenTrackerRemoved(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a, b, events := newHealthTrackers(ctx, t)
+	defer a.close()
+	defer b.close()
+	id := pie.IDA{1}
+	if !a.table.AddTracker(&Tracker{ID: id, Addr: Addr{"10.255.0.1:7000"}}) {
+		t.Fatal("AddTracker() = false")
+	}
+	a.table.FindTracker(ctx, id, 2, time.Second)
+	waitEvent(t, events, id, TrackerRemoved)
+}
+
+func TestReconnectDelay(t *testing.T) {
+	table := &Table{Config: pie.DefaultConfig()}
+	table.Config.Routing.ReconnectDelay = pie.Duration(time.Second)
+	table.Config.Routing.MaxReconnectDelay = pie.Duration(10 * time.Second)
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{failures: 1, want: time.Second},
+		{failures: 2, want: 2 * time.Second},
+		{failures: 4, want: 8 * time.Second},
+		{failures: 5, want: 10 * time.Second},
+		{failures: 100, want: 10 * time.Second},
+	}
+	for _, test := range tests {
+		for i := 0; i < 10; i++ {
+			if delay := table.reconnectDelay(test.failures); delay < test.want/2 || delay > test.want {
+				t.Fatalf("reconnectDelay(%d) = %v, want between %v and %v", test.failures, delay, test.want/2, test.want)
+			}
+		}
+	}
+}
+
+func TestAddEvents(t *testing.T) {
+	table := newTestTable()
+	a, b := make(chan TrackerEvent, 1), make(chan TrackerEvent, 1)
+	table.AddEvents(a)
+	table.AddEvents(b)
+	table.emit(TrackerEvent{Type: TrackerConnected})
+	if len(a) != 1 || len(b) != 1 {
+		t.Fatalf("emit() reached %d and %d subscribers, want both", len(a), len(b))
+	}
+	<-a
+	<-b
+	table.RemoveEvents(a)
+	table.emit(TrackerEvent{Type: TrackerRemoved})
+	if len(a) != 0 || len(b) != 1 {
+		t.Fatalf("emit() after RemoveEvents() reached %d and %d subscribers, want only the remaining one", len(a), len(b))
+	}
+}
@@ -0,0 +1,111 @@
+package routing
+
+import (
+	"context"
+	"github.com/Pie-Messaging/core/pie"
+	"sort"
+	"sync"
+	"time"
+)
+
+// lookupPath is one of the disjoint paths of a lookup. It only follows the trackers reported on it, so that malicious
+// trackers on other paths cannot steer it.
+type lookupPath struct {
+	index      int
+	candidates []*Tracker
+	queried    map[pie.IDA]struct{}
+}
+
+// claims assigns each tracker to the first path that queries it, and no other path queries it afterwards.
+type claims struct {
+	paths map[pie.IDA]int
+	mutex sync.Mutex
+}
+
+func (c *claims) claim(id pie.IDA, path int) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if owner, exists := c.paths[id]; exists {
+		return owner == path
+	}
+	c.paths[id] = path
+	return true
+}
+
+func (p *lookupPath) add(target pie.IDA, trackers []*Tracker) {
+	for _, tracker := range trackers {
+		if !testAny(len(p.candidates), func(i int) bool { return p.candidates[i].ID == tracker.ID }) {
+			p.candidates = append(p.candidates, tracker)
+		}
+	}
+	sort.Slice(p.candidates, func(i, j int) bool {
+		return p.candidates[i].ID.Xor(target).Cmp(p.candidates[j].ID.Xor(target)) < 0
+	})
+}
+
+// next returns the trackers to query among the num closest candidates the path can claim, or none when all of them
+// were queried.
+func (p *lookupPath) next(claims *claims, num int) []*Tracker {
+	var next []*Tracker
+	closest := 0
+	for _, tracker := range p.candidates {
+		if closest == num {
+			break
+		}
+		if !claims.claim(tracker.ID, p.index) {
+			continue
+		}
+		closest++
+		if _, queried := p.queried[tracker.ID]; !queried {
+			next = append(next, tracker)
+		}
+	}
+	return next
+}
+
+// FindTracker looks up the trackers closest to id along DisjointPaths paths that never query the same tracker, and
+// returns the number of request rounds the longest path took. Each path asks numRequest trackers per round and ends
+// when its numRequest closest trackers have answered or failed.
+func (r *Table) FindTracker(ctx context.Context, id pie.IDA, numRequest int, recvTimeout time.Duration) int {
+	numPaths := r.Config.Routing.DisjointPaths
+	if numPaths < 1 {
+		numPaths = 1
+	}
+	paths := make([]*lookupPath, numPaths)
+	for i := range paths {
+		paths[i] = &lookupPath{index: i, queried: make(map[pie.IDA]struct{})}
+	}
+	// The closest known trackers are dealt to the paths in turn, so each path starts about as close as the others
+	for i, tracker := range r.GetNeighbors(id, numPaths*numRequest) {
+		paths[i%numPaths].add(id, []*Tracker{tracker})
+	}
+	claims := &claims{paths: make(map[pie.IDA]int)}
+	wg := &sync.WaitGroup{}
+	rounds := make([]int, numPaths)
+	for _, path := range paths {
+		path := path
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				next := path.next(claims, numRequest)
+				if len(next) == 0 {
+					return
+				}
+				for _, tracker := range next {
+					path.queried[tracker.ID] = struct{}{}
+				}
+				path.add(id, r.findTrackerOnce(ctx, id, next, recvTimeout))
+				rounds[path.index]++
+			}
+		}()
+	}
+	wg.Wait()
+	maxRounds := 0
+	for _, n := range rounds {
+		if n > maxRounds {
+			maxRounds = n
+		}
+	}
+	return maxRounds
+}
@@ -4,34 +4,52 @@ import (
 	"context"
 	"github.com/Pie-Messaging/core/pie"
 	"github.com/Pie-Messaging/core/pie/pb"
-	"math/big"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-func (r *Table) FindTrackerOnce(ctx context.Context, id []byte, candidates []*Tracker, recvTimeout time.Duration) {
+func (r *Table) FindTrackerOnce(ctx context.Context, id pie.IDA, candidates []*Tracker, recvTimeout time.Duration) {
+	r.findTrackerOnce(ctx, id, candidates, recvTimeout)
+}
+
+// findTrackerOnce asks candidates for the trackers closest to id, and returns the reported ones that a lookup may query
+// once they are verified.
+func (r *Table) findTrackerOnce(ctx context.Context, id pie.IDA, candidates []*Tracker, recvTimeout time.Duration) []*Tracker {
 	wg := &sync.WaitGroup{}
+	mutex := &sync.Mutex{}
+	var learned []*Tracker
 	for _, tracker := range candidates {
 		tracker := tracker
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			stream, err := tracker.Session().OpenStream()
+			session := r.session(ctx, tracker)
+			if session == nil {
+				return
+			}
+			start := time.Now()
+			stream, err := session.OpenStream()
 			if err != nil {
+				r.requestFailed(ctx, tracker)
 				return
 			}
 			if stream.SendMessage(&pb.NetMessage{
 				Body: &pb.NetMessage_FindTrackerReq{FindTrackerReq: &pb.FindTrackerReq{
-					Id: id,
+					Id: id[:],
 				}},
 			}) != nil {
+				stream.Close()
+				r.requestFailed(ctx, tracker)
 				return
 			}
-			message, err := stream.RecvMessage(time.Now().Add(recvTimeout))
+			message, err := stream.RecvMessage(recvDeadline(ctx, recvTimeout))
 			stream.Close()
 			if err != nil {
+				r.requestFailed(ctx, tracker)
 				return
 			}
+			tracker.succeeded(time.Since(start))
 			findTrackerRes := message.GetFindTrackerRes()
 			if findTrackerRes == nil {
 				return
@@ -39,29 +57,216 @@ func (r *Table) FindTrackerOnce(ctx context.Context, id []byte, candidates []*Tr
 			if findTrackerRes.Status != pb.Status_OK {
 				return
 			}
-			for _, candidate := range findTrackerRes.Candidates {
-				tracker := &Tracker{ID: (&big.Int{}).SetBytes(candidate.Id)}
-				tracker.Addr = candidate.Addr
-				r.AddAndConnectTracker(ctx, tracker)
-			}
+			known := r.addCandidates(ctx, tracker, findTrackerRes.Candidates, recvTimeout)
+			mutex.Lock()
+			learned = append(learned, known...)
+			mutex.Unlock()
 		}()
 	}
 	wg.Wait()
+	return learned
 }
 
-func (r *Table) FindTracker(ctx context.Context, id *big.Int, numRequest int, recvTimeout time.Duration) {
-	visited := make(map[pie.IDA]struct{})
+// FindResource looks up the resource stored under id, asking numRequest trackers per round among the closest ones the
+// table knows or the lookup was told about, and fails with pie.ErrNotFound once the numRequest closest ones have
+// answered or failed.
+func (r *Table) FindResource(ctx context.Context, id pie.IDA, resourceType pb.ResourceType, numRequest int, recvTimeout time.Duration) (*pb.Resource, error) {
+	path := &lookupPath{queried: make(map[pie.IDA]struct{})}
+	path.add(id, r.GetNeighbors(id, numRequest))
+	claims := &claims{paths: make(map[pie.IDA]int)}
 	for {
-		neighbors := r.GetNeighbors(id, numRequest)
-		if testAll(len(neighbors), func(i int) bool {
-			_, exists := visited[*(*pie.IDA)(neighbors[i].ID.Bytes())]
-			return exists
-		}) {
-			return
+		next := path.next(claims, numRequest)
+		if len(next) == 0 {
+			return nil, pie.ErrNotFound
 		}
-		r.FindTrackerOnce(ctx, id.Bytes(), neighbors, recvTimeout)
-		for _, tracker := range neighbors {
-			visited[*(*pie.IDA)(tracker.ID.Bytes())] = struct{}{}
+		for _, tracker := range next {
+			path.queried[tracker.ID] = struct{}{}
 		}
+		resource, learned := r.findResourceOnce(ctx, id, resourceType, next, recvTimeout)
+		if resource != nil {
+			return resource, nil
+		}
+		path.add(id, learned)
+	}
+}
+
+func (r *Table) FindResourceOnce(ctx context.Context, id pie.IDA, resourceType pb.ResourceType, candidates []*Tracker, recvTimeout time.Duration) *pb.Resource {
+	resource, _ := r.findResourceOnce(ctx, id, resourceType, candidates, recvTimeout)
+	return resource
+}
+
+// findResourceOnce asks candidates for the resource stored under id, and returns it, or else the trackers they reported
+// that a lookup may query once they are verified.
+func (r *Table) findResourceOnce(ctx context.Context, id pie.IDA, resourceType pb.ResourceType, candidates []*Tracker, recvTimeout time.Duration) (*pb.Resource, []*Tracker) {
+	wg := &sync.WaitGroup{}
+	mutex := &sync.Mutex{}
+	var result *pb.Resource
+	var learned []*Tracker
+	for _, tracker := range candidates {
+		tracker := tracker
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			session := r.session(ctx, tracker)
+			if session == nil {
+				return
+			}
+			start := time.Now()
+			stream, err := session.OpenStream()
+			if err != nil {
+				r.requestFailed(ctx, tracker)
+				return
+			}
+			if stream.SendMessage(&pb.NetMessage{
+				Body: &pb.NetMessage_FindResourceReq{FindResourceReq: &pb.FindResourceReq{
+					Id:   id[:],
+					Type: resourceType,
+				}},
+			}) != nil {
+				stream.Close()
+				r.requestFailed(ctx, tracker)
+				return
+			}
+			message, err := stream.RecvMessage(recvDeadline(ctx, recvTimeout))
+			stream.Close()
+			if err != nil {
+				r.requestFailed(ctx, tracker)
+				return
+			}
+			tracker.succeeded(time.Since(start))
+			findResourceRes := message.GetFindResourceRes()
+			if findResourceRes == nil {
+				return
+			}
+			if findResourceRes.Status == pb.Status_OK && findResourceRes.Resource != nil {
+				mutex.Lock()
+				result = findResourceRes.Resource
+				mutex.Unlock()
+				return
+			}
+			known := r.addCandidates(ctx, tracker, findResourceRes.CandidateTrackers, recvTimeout)
+			mutex.Lock()
+			learned = append(learned, known...)
+			mutex.Unlock()
+		}()
+	}
+	wg.Wait()
+	return result, learned
+}
+
+// PutResource stores resource on the redundancy trackers closest to id, and succeeds if any of them accepts it.
+func (r *Table) PutResource(ctx context.Context, id pie.IDA, resourceType pb.ResourceType, resource *pb.Resource, redundancy int, recvTimeout time.Duration) error {
+	r.FindTracker(ctx, id, r.Config.Routing.Alpha, recvTimeout)
+	neighbors := r.GetNeighbors(id, redundancy)
+	if len(neighbors) == 0 {
+		return pie.ErrNoAddr
+	}
+	wg := &sync.WaitGroup{}
+	var stored int32
+	for _, tracker := range neighbors {
+		tracker := tracker
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			session := r.session(ctx, tracker)
+			if session == nil {
+				return
+			}
+			start := time.Now()
+			err := session.PutResource(resourceType, resource, recvTimeout)
+			if err == nil {
+				atomic.AddInt32(&stored, 1)
+			}
+			// A refusal is still an answer
+			if err == nil || err == pie.ErrStatus {
+				tracker.succeeded(time.Since(start))
+			} else {
+				r.requestFailed(ctx, tracker)
+			}
+		}()
+	}
+	wg.Wait()
+	if stored == 0 {
+		return pie.ErrStatus
+	}
+	return nil
+}
+
+// addCandidates adds the trackers reporter reported once they proved their IDs by connecting, and returns the reported
+// trackers a lookup may query: those in the table then, and the verified ones the table does not admit. Malformed IDs
+// and trackers that may not be queried are skipped without dialing them, and candidates holding another cert than
+// their ID claims count against reporter.
+func (r *Table) addCandidates(ctx context.Context, reporter *Tracker, candidates []*pb.Tracker, recvTimeout time.Duration) []*Tracker {
+	wg := &sync.WaitGroup{}
+	mutex := &sync.Mutex{}
+	var known []*Tracker
+	reported := make(map[pie.IDA]struct{}, len(candidates))
+	for _, candidate := range candidates {
+		if len(candidate.Id) != pie.IDLen {
+			continue
+		}
+		id := pie.BytesToIDA(candidate.Id)
+		if _, exists := reported[id]; exists {
+			continue
+		}
+		reported[id] = struct{}{}
+		tracker := r.GetTracker(id)
+		if tracker == nil {
+			// A tracker the table did not admit is still pooled if an earlier lookup queried it
+			tracker = r.pooled(id)
+		}
+		if tracker != nil {
+			mutex.Lock()
+			known = append(known, tracker)
+			mutex.Unlock()
+			continue
+		}
+		tracker = &Tracker{ID: id, Addr: candidate.Addr}
+		if !r.canQuery(tracker) {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := r.addVerified(ctx, tracker, recvTimeout)
+			if err == pie.ErrPeerMismatch {
+				r.misreported(reporter, err)
+			}
+			if err != nil {
+				return
+			}
+			queried := tracker
+			if added := r.GetTracker(tracker.ID); added != nil {
+				queried = added
+			}
+			mutex.Lock()
+			known = append(known, queried)
+			mutex.Unlock()
+		}()
+	}
+	wg.Wait()
+	return known
+}
+
+// addVerified connects tracker, which fails with pie.ErrPeerMismatch unless it holds the cert its ID hashes from, then
+// adds it to the table, and returns whether it did. The session of a tracker the table does not admit goes to the pool
+// as well, so that a lookup may query it, and the pool closes it once unused.
+func (r *Table) addVerified(ctx context.Context, tracker *Tracker, timeout time.Duration) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if err := r.connectTracker(ctx, tracker); err != nil {
+		return false, err
+	}
+	session := tracker.Session()
+	if r.AddTracker(tracker) {
+		r.addSession(tracker, session)
+		return true, nil
+	}
+	if r.GetTracker(tracker.ID) != nil {
+		// A concurrent lookup added it first
+		session.Close(pie.SessErrNoReason)
+		return false, nil
 	}
+	r.addSession(tracker, session)
+	return false, nil
 }
@@ -0,0 +1,146 @@
+package routing
+
+import (
+	"context"
+	"github.com/Pie-Messaging/core/pie"
+	"time"
+)
+
+// session returns an open session to tracker, dialing it on first use and again after its session was closed. It
+// returns nil if the dial failed or the tracker is backing off after failures.
+func (r *Table) session(ctx context.Context, tracker *Tracker) *pie.Session {
+	if session := tracker.Session(); session != nil {
+		r.touch(tracker)
+		return session
+	}
+	if !tracker.canDial() {
+		return nil
+	}
+	session, err := r.dial(ctx, tracker)
+	if err != nil {
+		r.dialFailed(tracker, err)
+		return nil
+	}
+	return session
+}
+
+// dial connects tracker unless a concurrent dial did, and adds the session to the pool.
+func (r *Table) dial(ctx context.Context, tracker *Tracker) (*pie.Session, error) {
+	tracker.dialMutex.Lock()
+	defer tracker.dialMutex.Unlock()
+	if session := tracker.Session(); session != nil {
+		r.touch(tracker)
+		return session, nil
+	}
+	if err := r.connectTracker(ctx, tracker); err != nil {
+		return nil, err
+	}
+	session := tracker.Session()
+	r.addSession(tracker, session)
+	return session, nil
+}
+
+// addSession makes session of tracker the most recently used one, closes the least recently used sessions beyond
+// MaxSessions, and watches session until it is closed.
+func (r *Table) addSession(tracker *Tracker, session *pie.Session) {
+	var idle []*pie.Session
+	r.sessionMutex.Lock()
+	if tracker.poolElement == nil {
+		tracker.poolElement = r.sessions.PushFront(tracker)
+	} else {
+		r.sessions.MoveToFront(tracker.poolElement)
+	}
+	for maxSessions := r.Config.Routing.MaxSessions; maxSessions > 0 && r.sessions.Len() > maxSessions; {
+		evicted := r.sessions.Remove(r.sessions.Back()).(*Tracker)
+		evicted.poolElement = nil
+		if evictedSession := evicted.Session(); evictedSession != nil && evicted.dropSession(evictedSession) {
+			idle = append(idle, evictedSession)
+		}
+	}
+	r.sessionMutex.Unlock()
+	for _, idleSession := range idle {
+		idleSession.Close(pie.SessErrIdle)
+	}
+	r.emit(TrackerEvent{Type: TrackerConnected, Tracker: tracker})
+	go r.watch(tracker, session)
+}
+
+// touch marks the session of tracker as used.
+func (r *Table) touch(tracker *Tracker) {
+	r.sessionMutex.Lock()
+	defer r.sessionMutex.Unlock()
+	if tracker.poolElement != nil {
+		r.sessions.MoveToFront(tracker.poolElement)
+	}
+}
+
+// watch removes session from the pool once it is closed other than by eviction. The tracker is dialed again when it
+// is used next.
+func (r *Table) watch(tracker *Tracker, session *pie.Session) {
+	select {
+	case <-r.ctx.Done():
+		return
+	case <-session.Session.Context().Done():
+	}
+	r.sessionMutex.Lock()
+	dropped := tracker.dropSession(session)
+	if dropped && tracker.poolElement != nil {
+		r.sessions.Remove(tracker.poolElement)
+		tracker.poolElement = nil
+	}
+	r.sessionMutex.Unlock()
+	if dropped {
+		r.emit(TrackerEvent{Type: TrackerDisconnected, Tracker: tracker})
+	}
+}
+
+// dialFailed counts a failed dial of tracker, and removes it after MaxFailures consecutive failures, or at the first
+// one if it was never seen, since nothing suggests it exists then. Otherwise it is not dialed again before the
+// backoff delay.
+func (r *Table) dialFailed(tracker *Tracker, err error) {
+	if r.ctx.Err() != nil {
+		return
+	}
+	failures := tracker.failed()
+	if tracker.LastSeen().IsZero() || failures >= r.Config.Routing.MaxFailures {
+		r.RemoveTracker(tracker.ID)
+		r.emit(TrackerEvent{Type: TrackerRemoved, Tracker: tracker, Err: err})
+		return
+	}
+	tracker.setNextDial(time.Now().Add(r.reconnectDelay(failures)))
+}
+
+// removeSession removes tracker from the pool and closes its session without reporting it as disconnected, since the
+// tracker was removed.
+func (r *Table) removeSession(tracker *Tracker) {
+	r.sessionMutex.Lock()
+	if tracker.poolElement != nil {
+		r.sessions.Remove(tracker.poolElement)
+		tracker.poolElement = nil
+	}
+	session := tracker.Session()
+	dropped := session != nil && tracker.dropSession(session)
+	r.sessionMutex.Unlock()
+	if dropped {
+		session.Close(pie.SessErrNoReason)
+	}
+}
+
+// pooled returns the tracker with id whose session is in the pool, or nil.
+func (r *Table) pooled(id pie.IDA) *Tracker {
+	r.sessionMutex.Lock()
+	defer r.sessionMutex.Unlock()
+	for element := r.sessions.Front(); element != nil; element = element.Next() {
+		if tracker := element.Value.(*Tracker); tracker.ID == id {
+			return tracker
+		}
+	}
+	return nil
+}
+
+// SessionCount returns the number of open tracker sessions in the pool.
+func (r *Table) SessionCount() int {
+	r.sessionMutex.Lock()
+	defer r.sessionMutex.Unlock()
+	return r.sessions.Len()
+}
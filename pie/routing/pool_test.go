@@ -0,0 +1,80 @@
+package routing
+
+import (
+	"context"
+	"github.com/Pie-Messaging/core/pie/memnet"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestSessionPool(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	network := memnet.NewNetwork(1)
+	trackers := simulate(ctx, t, network, 5, rand.New(rand.NewSource(1)))
+	defer func() {
+		for _, tracker := range trackers {
+			tracker.close()
+		}
+	}()
+	// a knows the trackers without being connected to any
+	a := newSimTracker(ctx, t, network)
+	defer a.close()
+	const maxSessions = 2
+	a.table.Config.Routing.MaxSessions = maxSessions
+	a.table.Init(ctx, nil)
+	events := make(chan TrackerEvent, 64)
+	a.table.SetEvents(events)
+
+	var used []*Tracker
+	for _, peer := range trackers {
+		tracker := &Tracker{ID: peer.table.ID, Addr: Addr{peer.addr()}}
+		a.table.AddTracker(tracker)
+		if tracker.Session() != nil {
+			t.Fatalf("tracker %x is connected before use", tracker.ID)
+		}
+		if a.table.session(ctx, tracker) == nil {
+			t.Fatalf("no session to tracker %x", tracker.ID)
+		}
+		used = append(used, tracker)
+		if count := a.table.SessionCount(); count > maxSessions {
+			t.Fatalf("SessionCount() = %d, want at most %d", count, maxSessions)
+		}
+	}
+	for i, tracker := range used {
+		if connected := tracker.Session() != nil; connected != (i >= len(used)-maxSessions) {
+			t.Errorf("tracker %d connected = %v after using %d trackers", i, connected, len(used))
+		}
+	}
+
+	// Evicted sessions are not reported as disconnected, and using one dials it again
+	time.Sleep(50 * time.Millisecond)
+	for len(events) > 0 {
+		if event := <-events; event.Type != TrackerConnected {
+			t.Fatalf("event %v for tracker %x after evictions", event.Type, event.Tracker.ID)
+		}
+	}
+	if a.table.session(ctx, used[0]) == nil || used[0].Session() == nil {
+		t.Fatal("evicted tracker is not dialed again on use")
+	}
+	if count := a.table.SessionCount(); count != maxSessions {
+		t.Fatalf("SessionCount() = %d, want %d", count, maxSessions)
+	}
+
+	// Removing a tracker closes its session and frees its place in the pool
+	session := used[0].Session()
+	a.table.RemoveTracker(used[0].ID)
+	if count := a.table.SessionCount(); count != maxSessions-1 {
+		t.Fatalf("SessionCount() = %d after a removal, want %d", count, maxSessions-1)
+	}
+	select {
+	case <-session.Session.Context().Done():
+	case <-time.After(time.Second):
+		t.Fatal("session of a removed tracker is not closed")
+	}
+}
+
+func TestCloseBeforeInit(t *testing.T) {
+	(&Table{}).Close()
+}
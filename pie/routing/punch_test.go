@@ -0,0 +1,198 @@
+package routing
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/Pie-Messaging/core/pie"
+	"github.com/Pie-Messaging/core/pie/memnet"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+type simUser struct {
+	id     []byte
+	cert   *tls.Certificate
+	server *pie.Server
+}
+
+func newSimUser(t *testing.T, network *memnet.Network) *simUser {
+	t.Helper()
+	cert, _, _, err := pie.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := pie.DefaultConfig()
+	config.Transport = network
+	config.QUIC.HandshakeIdleTimeout = pie.Duration(100 * time.Millisecond)
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{*cert}, NextProtos: []string{pie.UserTLSProto}}
+	server, err := pie.ListenNet(":7000", tlsConfig, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Close)
+	return &simUser{id: pie.HashBytes(cert.Certificate[0], pie.IDLen), cert: cert, server: server}
+}
+
+// tlsConfig dials a user holding the cert id hashes from.
+func (u *simUser) tlsConfig(id []byte) *tls.Config {
+	return &tls.Config{
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 || !bytes.Equal(pie.HashBytes(rawCerts[0], pie.IDLen), id) {
+				return pie.ErrPeerMismatch
+			}
+			return nil
+		},
+		NextProtos:         []string{pie.UserTLSProto},
+		InsecureSkipVerify: true,
+	}
+}
+
+// register dials tracker from the listening socket of u and authenticates, and waits until tracker registered u.
+func (u *simUser) register(ctx context.Context, t *testing.T, tracker *simTracker) *pie.Session {
+	t.Helper()
+	tlsConfig := &tls.Config{NextProtos: []string{pie.UserTLSProto}, InsecureSkipVerify: true}
+	session, err := u.server.Dial(ctx, tlsConfig, tracker.addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := session.SendCert(u.cert, u.id); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(time.Second); tracker.table.Rendezvous.Session(u.id) == nil; {
+		if time.Now().After(deadline) {
+			t.Fatal("user is not registered")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return session
+}
+
+func TestPunch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	network := memnet.NewNetwork(1)
+	trackers := simulate(ctx, t, network, 1, rand.New(rand.NewSource(1)))
+	tracker := trackers[0]
+	defer tracker.close()
+	a, b := newSimUser(t, network), newSimUser(t, network)
+	recvTimeout := time.Duration(tracker.table.Config.Routing.RecvTimeout)
+
+	// A tracker session from another socket would advertise a mapping nobody punches from
+	trackerConfig := &tls.Config{NextProtos: []string{pie.UserTLSProto}, InsecureSkipVerify: true}
+	other, err := pie.Connect(ctx, trackerConfig, a.server.Config, tracker.addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close(pie.SessErrNoReason)
+	if _, err := a.server.RequestPunch(ctx, other, b.id, a.tlsConfig(b.id), recvTimeout); err != pie.ErrSocketMismatch {
+		t.Fatalf("RequestPunch() on a session of another socket = %v, want %v", err, pie.ErrSocketMismatch)
+	}
+
+	trackerA := a.register(ctx, t, tracker)
+	trackerB := b.register(ctx, t, tracker)
+	notified := make(chan error, 1)
+	go func() {
+		stream, err := trackerB.AcceptStream(ctx, nil)
+		if err != nil {
+			notified <- err
+			return
+		}
+		defer stream.Close()
+		message, err := stream.RecvMessage(time.Now().Add(recvTimeout))
+		if err != nil {
+			notified <- err
+			return
+		}
+		notify := message.GetPunchNotify()
+		if notify == nil || !bytes.Equal(notify.Id, a.id) {
+			notified <- pie.ErrInvalidMsg
+			return
+		}
+		notified <- b.server.HandlePunchNotify(ctx, b.tlsConfig(notify.Id), notify)
+	}()
+	session, err := a.server.RequestPunch(ctx, trackerA, b.id, a.tlsConfig(b.id), recvTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close(pie.SessErrNoReason)
+	if err := <-notified; err != nil {
+		t.Fatal("HandlePunchNotify() =", err)
+	}
+	// Each side accepts the session the other dialed, and only the one of the requester stays open
+	accepted, err := b.server.AcceptSession(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if accepted.Session.RemoteAddr().String() != a.server.Listener.Addr().String() {
+		t.Fatalf("b accepted a session from %v, want %v", accepted.Session.RemoteAddr(), a.server.Listener.Addr())
+	}
+	duplicate, err := a.server.AcceptSession(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-duplicate.Session.Context().Done():
+	case <-time.After(time.Second):
+		t.Fatal("session dialed by the notified peer is not closed")
+	}
+	if session.Session.Context().Err() != nil {
+		t.Fatal("session dialed by the requester is closed")
+	}
+}
+
+// waitDevices waits until tracker has n devices of the user with id registered.
+func waitDevices(t *testing.T, tracker *simTracker, id []byte, n int) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); len(tracker.table.Rendezvous.Sessions(id)) != n; {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d devices registered, want %d", len(tracker.table.Rendezvous.Sessions(id)), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestDeviceRendezvous(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	network := memnet.NewNetwork(1)
+	tracker := simulate(ctx, t, network, 1, rand.New(rand.NewSource(1)))[0]
+	defer tracker.close()
+	user, other := newSimUser(t, network), newSimUser(t, network)
+	tlsConfig := &tls.Config{NextProtos: []string{pie.UserTLSProto}, InsecureSkipVerify: true}
+	connectDevice := func(signer *simUser) *pie.Session {
+		device := newSimUser(t, network)
+		link, err := pie.LinkDevice(signer.cert, device.cert.Certificate[0], "device")
+		if err != nil {
+			t.Fatal(err)
+		}
+		session, err := device.server.Dial(ctx, tlsConfig, tracker.addr())
+		if err != nil {
+			t.Fatal(err)
+		}
+		linked := &pie.Device{UserCertDER: user.cert.Certificate[0], Cert: device.cert, Link: link}
+		if err := linked.SendCert(session, user.id); err != nil {
+			t.Fatal(err)
+		}
+		return session
+	}
+
+	// A device linked by another user does not authenticate as the user
+	forged := connectDevice(other)
+	defer forged.Close(pie.SessErrNoReason)
+	first := connectDevice(user)
+	second := connectDevice(user)
+	defer second.Close(pie.SessErrNoReason)
+	waitDevices(t, tracker, user.id, 2)
+	time.Sleep(50 * time.Millisecond)
+	waitDevices(t, tracker, user.id, 2)
+
+	// A closed device is unregistered without affecting the others
+	first.Close(pie.SessErrNoReason)
+	waitDevices(t, tracker, user.id, 1)
+	if session := tracker.table.Rendezvous.Session(user.id); session == nil || session.Session.RemoteAddr().String() != second.Session.LocalAddr().String() {
+		t.Fatal("Session() is not the remaining device")
+	}
+}
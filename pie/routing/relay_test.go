@@ -0,0 +1,82 @@
+package routing
+
+import (
+	"bytes"
+	"context"
+	"github.com/Pie-Messaging/core/pie"
+	"github.com/Pie-Messaging/core/pie/memnet"
+	"github.com/Pie-Messaging/core/pie/pb"
+	"testing"
+	"time"
+)
+
+func TestRelay(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	network := memnet.NewNetwork(1)
+	tracker := newSimTracker(ctx, t, network)
+	defer tracker.close()
+	tracker.table.Config.Routing.EnableRelay = true
+	tracker.table.Config.Routing.RelayQuota = 0
+	tracker.table.Config.Routing.RelayIdleTimeout = pie.Duration(200 * time.Millisecond)
+	tracker.join(ctx)
+	a, b := newSimUser(t, network), newSimUser(t, network)
+	trackerA := a.register(ctx, t, tracker)
+	trackerB := b.register(ctx, t, tracker)
+	recvTimeout := time.Duration(tracker.table.Config.Routing.RecvTimeout)
+
+	accepted := make(chan *pie.SecureStream, 1)
+	go func() {
+		defer close(accepted)
+		stream, err := trackerB.AcceptStream(ctx, nil)
+		if err != nil {
+			return
+		}
+		message, err := stream.RecvMessage(time.Now().Add(recvTimeout))
+		if err != nil || message.GetRelayAcceptReq() == nil {
+			stream.Close()
+			return
+		}
+		secureStream, err := pie.AcceptRelay(stream, message.GetRelayAcceptReq(), b.cert, recvTimeout)
+		if err != nil {
+			stream.Close()
+			return
+		}
+		accepted <- secureStream
+	}()
+	streamA, err := pie.RequestRelay(trackerA, b.id, a.cert, recvTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer streamA.Close()
+	streamB := <-accepted
+	if streamB == nil {
+		t.Fatal("b did not accept the relay")
+	}
+	defer streamB.Close()
+
+	// A quota of 0 is unlimited
+	sent := &pb.NetMessage{Body: &pb.NetMessage_GetAddrRes{GetAddrRes: &pb.GetAddrRes{Addresses: []string{"10.0.0.1:7000"}}}}
+	if err := streamA.SendMessage(sent, time.Now().Add(recvTimeout)); err != nil {
+		t.Fatal(err)
+	}
+	received, err := streamB.RecvMessage(time.Now().Add(recvTimeout))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addrList := received.GetGetAddrRes().GetAddresses(); len(addrList) != 1 || addrList[0] != "10.0.0.1:7000" {
+		t.Fatalf("relayed message = %v, want %v", received, sent)
+	}
+
+	// Once neither side sends, the relay closes the streams
+	start := time.Now()
+	if _, err := streamB.RecvMessage(time.Now().Add(recvTimeout)); err == nil {
+		t.Fatal("RecvMessage() on an idle relay succeeded")
+	}
+	if elapsed := time.Since(start); elapsed >= recvTimeout {
+		t.Fatalf("idle relay was closed after %v", elapsed)
+	}
+	if !bytes.Equal(streamB.PeerCert.Raw, a.cert.Certificate[0]) {
+		t.Fatal("relayed stream is not secured with the cert of a")
+	}
+}
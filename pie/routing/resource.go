@@ -0,0 +1,105 @@
+package routing
+
+import (
+	"bytes"
+	"github.com/Pie-Messaging/core/pie"
+	"github.com/Pie-Messaging/core/pie/pb"
+	"google.golang.org/protobuf/proto"
+)
+
+// resourceKey identifies a stored resource by the ID it is stored under and its type.
+type resourceKey struct {
+	id           pie.IDA
+	resourceType pb.ResourceType
+}
+
+// resourceID checks resource against resourceType and returns the ID it is stored under: the ID of the user
+// certificate for a user record, and the ID of the revoked certificate for a verified revocation.
+func resourceID(resourceType pb.ResourceType, resource *pb.Resource) (pie.IDA, error) {
+	switch resourceType {
+	case pb.ResourceType_USER:
+		user := resource.GetUser()
+		if user == nil {
+			return pie.IDA{}, pie.ErrInvalidMsg
+		}
+		if !bytes.Equal(user.Id, pie.HashBytes(user.CertDer, pie.IDLen)) {
+			return pie.IDA{}, pie.ErrPeerMismatch
+		}
+		return pie.BytesToIDA(user.Id), nil
+	case pb.ResourceType_REVOCATION:
+		revocation := resource.GetRevocation()
+		if revocation == nil {
+			return pie.IDA{}, pie.ErrInvalidMsg
+		}
+		if err := pie.VerifyRevocation(revocation); err != nil {
+			return pie.IDA{}, err
+		}
+		return pie.BytesToIDA(revocation.Id), nil
+	}
+	return pie.IDA{}, pie.ErrInvalidMsg
+}
+
+// StoreResource keeps resource for the peers looking it up and returns the status to answer the put with. A user
+// record is only bound to its certificate, which peers authenticate when connecting to its addresses, and replaces
+// the previous one unless the certificate has been revoked. A revocation is kept once stored, except
+// that one without successor replaces a rotation, since two holders of the key may disagree on the successor.
+func (r *Table) StoreResource(resourceType pb.ResourceType, resource *pb.Resource) pb.Status {
+	id, err := resourceID(resourceType, resource)
+	if err != nil {
+		return pb.Status_CERT_ERROR
+	}
+	key := resourceKey{id: id, resourceType: resourceType}
+	r.resourceMutex.Lock()
+	defer r.resourceMutex.Unlock()
+	existing, exists := r.resources[key]
+	switch resourceType {
+	case pb.ResourceType_USER:
+		if _, revoked := r.resources[resourceKey{id: id, resourceType: pb.ResourceType_REVOCATION}]; revoked {
+			return pb.Status_CERT_ERROR
+		}
+	case pb.ResourceType_REVOCATION:
+		if exists && proto.Equal(existing, resource) {
+			return pb.Status_OK
+		}
+		if exists && (len(existing.GetRevocation().NewCertDer) == 0 || len(resource.GetRevocation().NewCertDer) != 0) {
+			return pb.Status_ALREADY_DONE
+		}
+	}
+	if !exists && r.Config.Routing.MaxResources > 0 && len(r.resources) >= r.Config.Routing.MaxResources {
+		return pb.Status_TOO_MANY_REQUESTS
+	}
+	if r.resources == nil {
+		r.resources = make(map[resourceKey]*pb.Resource)
+	}
+	r.resources[key] = resource
+	return pb.Status_OK
+}
+
+// LoadResource returns the resource of resourceType stored under id, or nil.
+func (r *Table) LoadResource(id pie.IDA, resourceType pb.ResourceType) *pb.Resource {
+	r.resourceMutex.Lock()
+	defer r.resourceMutex.Unlock()
+	return r.resources[resourceKey{id: id, resourceType: resourceType}]
+}
+
+// HandlePutResourceReq stores the requested resource and answers with the outcome.
+func (r *Table) HandlePutResourceReq(stream *pie.Stream, req *pb.PutResourceReq) {
+	_ = stream.SendMessage(&pb.NetMessage{Body: &pb.NetMessage_PutResourceRes{PutResourceRes: &pb.PutResourceRes{
+		Status: r.StoreResource(req.Type, req.Resource),
+	}}})
+}
+
+// HandleFindResourceReq answers with the requested resource if it is stored here, and otherwise with the KSize known
+// trackers closest to its ID. Malformed IDs are ignored.
+func (r *Table) HandleFindResourceReq(stream *pie.Stream, req *pb.FindResourceReq) {
+	if len(req.Id) != pie.IDLen {
+		return
+	}
+	id := pie.BytesToIDA(req.Id)
+	res := &pb.FindResourceRes{Status: pb.Status_OK, Resource: r.LoadResource(id, req.Type)}
+	if res.Resource == nil {
+		res.Status = pb.Status_NOT_FOUND
+		res.CandidateTrackers = r.candidates(id)
+	}
+	_ = stream.SendMessage(&pb.NetMessage{Body: &pb.NetMessage_FindResourceRes{FindResourceRes: res}})
+}
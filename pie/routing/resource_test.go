@@ -0,0 +1,139 @@
+package routing
+
+import (
+	"bytes"
+	"context"
+	"github.com/Pie-Messaging/core/pie"
+	"github.com/Pie-Messaging/core/pie/memnet"
+	"github.com/Pie-Messaging/core/pie/pb"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func newResourceTrackers(ctx context.Context, t *testing.T) []*simTracker {
+	trackers := simulate(ctx, t, memnet.NewNetwork(1), 8, rand.New(rand.NewSource(1)))
+	t.Cleanup(func() {
+		for _, tracker := range trackers {
+			tracker.close()
+		}
+	})
+	return trackers
+}
+
+func newUserResource(t *testing.T) (*pb.User, *pb.Resource) {
+	t.Helper()
+	cert, _, _, err := pie.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &pb.User{Id: pie.HashBytes(cert.Certificate[0], pie.IDLen), CertDer: cert.Certificate[0], Name: "user"}
+	return user, &pb.Resource{Resource: &pb.Resource_User{User: user}}
+}
+
+func TestPutFindResource(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	trackers := newResourceTrackers(ctx, t)
+	config := trackers[0].table.Config.Routing
+	recvTimeout := time.Duration(config.RecvTimeout)
+
+	user, resource := newUserResource(t)
+	id := pie.BytesToIDA(user.Id)
+	if err := trackers[1].table.PutResource(ctx, id, pb.ResourceType_USER, resource, config.MetaDataRedundancy, recvTimeout); err != nil {
+		t.Fatal(err)
+	}
+	found, err := trackers[len(trackers)-1].table.FindResource(ctx, id, pb.ResourceType_USER, config.Alpha, recvTimeout)
+	if err != nil || found.GetUser().GetName() != user.Name {
+		t.Fatalf("FindResource() = %v, %v, want %v", found, err, user)
+	}
+	if _, err := trackers[1].table.FindResource(ctx, id, pb.ResourceType_REVOCATION, config.Alpha, recvTimeout); err != pie.ErrNotFound {
+		t.Fatalf("FindResource() of another type = %v, want %v", err, pie.ErrNotFound)
+	}
+
+	// A user record is stored under the ID of its certificate only
+	forged, _ := newUserResource(t)
+	forged.Id = user.Id
+	resource = &pb.Resource{Resource: &pb.Resource_User{User: forged}}
+	if err := trackers[1].table.PutResource(ctx, id, pb.ResourceType_USER, resource, config.MetaDataRedundancy, recvTimeout); err != pie.ErrStatus {
+		t.Fatalf("PutResource() of a forged user = %v, want %v", err, pie.ErrStatus)
+	}
+}
+
+func TestPutRevocation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	trackers := newResourceTrackers(ctx, t)
+	config := trackers[0].table.Config.Routing
+	recvTimeout := time.Duration(config.RecvTimeout)
+
+	cert, _, _, err := pie.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	newCert, _, _, err := pie.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	revocation, err := pie.NewRevocation(cert, newCert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := trackers[1].table.PutRevocation(ctx, revocation, recvTimeout); err != nil {
+		t.Fatal(err)
+	}
+	// Putting the same revocation again succeeds
+	if err := trackers[2].table.PutRevocation(ctx, revocation, recvTimeout); err != nil {
+		t.Fatal(err)
+	}
+	resolved, err := trackers[len(trackers)-1].table.ResolveCert(ctx, cert.Certificate[0], recvTimeout)
+	if err != nil || !bytes.Equal(resolved, newCert.Certificate[0]) {
+		t.Fatalf("ResolveCert() = %v, want the new certificate", err)
+	}
+
+	// The revoked certificate cannot be announced anymore
+	user := &pb.User{Id: revocation.Id, CertDer: cert.Certificate[0]}
+	resource := &pb.Resource{Resource: &pb.Resource_User{User: user}}
+	err = trackers[1].table.PutResource(ctx, pie.BytesToIDA(user.Id), pb.ResourceType_USER, resource, config.MetaDataRedundancy, recvTimeout)
+	if err != pie.ErrStatus {
+		t.Fatalf("PutResource() of a revoked user = %v, want %v", err, pie.ErrStatus)
+	}
+}
+
+func TestAnnounce(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	trackers := newResourceTrackers(ctx, t)
+	config := trackers[0].table.Config.Routing
+	recvTimeout := time.Duration(config.RecvTimeout)
+
+	user, _ := newUserResource(t)
+	announcer := trackers[1]
+	if err := announcer.table.Announce(ctx, user, recvTimeout); err != nil {
+		t.Fatal(err)
+	}
+	found, err := trackers[len(trackers)-1].table.FindResource(ctx, pie.BytesToIDA(user.Id), pb.ResourceType_USER, config.Alpha, recvTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addrs := found.GetUser().GetAddresses(); len(addrs) != 1 || addrs[0] != announcer.addr() {
+		t.Fatalf("announced addresses = %v, want [%v]", addrs, announcer.addr())
+	}
+}
+
+func TestStoreResourceLimit(t *testing.T) {
+	table := newTestTable()
+	table.Config.Routing.MaxResources = 1
+	_, first := newUserResource(t)
+	_, second := newUserResource(t)
+	if status := table.StoreResource(pb.ResourceType_USER, first); status != pb.Status_OK {
+		t.Fatalf("StoreResource() = %v, want %v", status, pb.Status_OK)
+	}
+	if status := table.StoreResource(pb.ResourceType_USER, second); status != pb.Status_TOO_MANY_REQUESTS {
+		t.Fatalf("StoreResource() beyond the cap = %v, want %v", status, pb.Status_TOO_MANY_REQUESTS)
+	}
+	// Replacing a stored record does not count against the cap
+	if status := table.StoreResource(pb.ResourceType_USER, first); status != pb.Status_OK {
+		t.Fatalf("StoreResource() of a stored record = %v, want %v", status, pb.Status_OK)
+	}
+}
@@ -0,0 +1,44 @@
+package routing
+
+import (
+	"bytes"
+	"context"
+	"github.com/Pie-Messaging/core/pie"
+	"github.com/Pie-Messaging/core/pie/pb"
+	"time"
+)
+
+// PutRevocation stores revocation under the ID of the certificate it revokes.
+func (r *Table) PutRevocation(ctx context.Context, revocation *pb.Revocation, recvTimeout time.Duration) error {
+	if err := pie.VerifyRevocation(revocation); err != nil {
+		return err
+	}
+	resource := &pb.Resource{Resource: &pb.Resource_Revocation{Revocation: revocation}}
+	return r.PutResource(ctx, pie.BytesToIDA(revocation.Id), pb.ResourceType_REVOCATION, resource, r.Config.Routing.MetaDataRedundancy, recvTimeout)
+}
+
+// ResolveCert looks up revocations of certDER and follows rotations to the current certificate of the identity.
+// Verified revocations are remembered in pie.DefaultRevocations.
+func (r *Table) ResolveCert(ctx context.Context, certDER []byte, recvTimeout time.Duration) ([]byte, error) {
+	for i := 0; i < pie.MaxRotationChain; i++ {
+		id := pie.HashBytes(certDER, pie.IDLen)
+		resource, err := r.FindResource(ctx, pie.BytesToIDA(id), pb.ResourceType_REVOCATION, r.Config.Routing.Alpha, recvTimeout)
+		if err != nil && err != pie.ErrNotFound {
+			return nil, err
+		}
+		if revocation := resource.GetRevocation(); revocation != nil && bytes.Equal(revocation.Id, id) {
+			if err := pie.DefaultRevocations.Add(revocation); err != nil {
+				pie.Logger.Println("Ignored invalid revocation:", err)
+			}
+		}
+		revocation := pie.DefaultRevocations.Get(certDER)
+		if revocation == nil {
+			return certDER, nil
+		}
+		if len(revocation.NewCertDer) == 0 {
+			return nil, pie.ErrRevoked
+		}
+		certDER = revocation.NewCertDer
+	}
+	return nil, pie.ErrRevoked
+}
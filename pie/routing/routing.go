@@ -3,10 +3,12 @@ package routing
 import (
 	"container/list"
 	"context"
+	"crypto/tls"
 	"github.com/Pie-Messaging/core/pie"
+	"github.com/Pie-Messaging/core/pie/pb"
 	"math"
-	"math/big"
 	"sync"
+	"time"
 )
 
 var (
@@ -14,11 +16,39 @@ var (
 )
 
 type Table struct {
-	Protocol    string
+	Protocol string
+	Config   *pie.Config
+	// ID is the own tracker ID, which is never added to the table. The zero ID is unset.
+	ID pie.IDA
+	// Server and Cert make the table dial trackers from the listening socket and authenticate as a tracker
+	Server *pie.Server
+	Cert   *tls.Certificate
+	// Store defaults to a FileStore at Config.Routing.StorePath if that is set
+	Store Store
+	// Rendezvous holds the users that authenticated on the served sessions, so that they can punch to each other.
+	// Init creates it if it is nil.
+	Rendezvous *pie.Rendezvous
+	// Relay splices streams between the users in Rendezvous. Init creates it if it is nil and EnableRelay is set.
+	Relay       *pie.Relay
 	trackerMap  map[pie.IDA]*list.Element
 	trackerList *list.List
 	trackerTree *TreeNode
-	mutex       sync.RWMutex
+	// subnets counts the trackers per bucket and subnet, see MaxSubnetTrackers
+	subnets map[subnetKey]int
+	// banned maps trackers removed for reporting forged candidates to when they may be added again
+	banned map[pie.IDA]time.Time
+	// events are the channels tracker events are reported to, see AddEvents
+	events []chan<- TrackerEvent
+	// resources holds what peers put on this tracker, see StoreResource
+	resources     map[resourceKey]*pb.Resource
+	resourceMutex sync.Mutex
+	// sessions lists the trackers with an open session, most recently used first
+	sessions     *list.List
+	sessionMutex sync.Mutex
+	// ctx lives until Close and bounds the connections to trackers
+	ctx    context.Context
+	cancel context.CancelFunc
+	mutex  sync.RWMutex
 }
 
 type TreeNode struct {
@@ -29,88 +59,161 @@ type TreeNode struct {
 }
 
 func (r *Table) Init(ctx context.Context, trackers []*Tracker) {
-	if len(trackers) == 0 {
+	if r.Config == nil {
+		r.Config = pie.DefaultConfig()
+	}
+	if r.Store == nil && r.Config.Routing.StorePath != "" {
+		r.Store = NewFileStore(r.Config.Routing.StorePath)
+	}
+	stored := r.loadTrackers()
+	if len(trackers) == 0 && len(stored) == 0 {
 		pie.Logger.Println("No tracker for bootstrap, so I have to wait other trackers to join my network")
 	}
 	r.trackerMap = make(map[pie.IDA]*list.Element, len(trackers))
 	r.trackerList = list.New()
+	r.trackerTree = &TreeNode{}
+	r.subnets = make(map[subnetKey]int)
+	r.banned = make(map[pie.IDA]time.Time)
+	r.sessions = list.New()
+	if r.Rendezvous == nil {
+		r.Rendezvous = pie.NewRendezvous()
+	}
+	if r.Relay == nil && r.Config.Routing.EnableRelay {
+		config := r.Config.Routing
+		r.Relay = pie.NewRelay(r.Rendezvous, config.RelayQuota, time.Duration(config.RelayQuotaWindow), time.Duration(config.RelayIdleTimeout))
+	}
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+	// Known trackers are dialed when a lookup first uses them
+	for _, tracker := range stored {
+		r.AddTracker(tracker)
+	}
 	wg := &sync.WaitGroup{}
 	for _, tracker := range trackers {
-		if tracker.ID.BitLen() == 0 {
+		tracker := tracker
+		if tracker.ID.IsZero() {
 			wg.Add(1)
 			pie.Logger.Println("Connecting to tracker:", tracker.Addr)
 			go func() {
 				defer wg.Done()
-				err := tracker.Connect(ctx, r.Protocol)
-				if err == nil {
-					r.AddTracker(tracker)
+				if err := r.connectTracker(ctx, tracker); err != nil {
+					return
+				}
+				if r.AddTracker(tracker) {
+					r.addSession(tracker, tracker.Session())
+				} else {
+					// The tracker is already known, banned or not admitted, so its session would never be used
+					tracker.Session().Close(pie.SessErrNoReason)
 				}
 			}()
 		} else {
-			r.AddAndConnectTracker(ctx, tracker)
+			r.AddTracker(tracker)
 		}
 	}
 	wg.Wait()
 }
 
-func (r *Table) GetTracker(id []byte) *Tracker {
+func (r *Table) GetTracker(id pie.IDA) *Tracker {
 	r.mutex.RLock()
 	defer r.mutex.RUnlock()
-	if element, exists := r.trackerMap[*(*pie.IDA)(id)]; exists {
+	if element, exists := r.trackerMap[id]; exists {
 		return element.Value.(*Tracker)
 	}
 	return nil
 }
 
-func (r *Table) AddAndConnectTracker(ctx context.Context, tracker *Tracker) {
-	r.AddTracker(tracker)
-	go func() {
-		err := tracker.Connect(ctx, r.Protocol)
-		if err != nil {
-			r.RemoveTracker(tracker.ID)
-		}
-	}()
-}
-
-func (r *Table) AddTracker(tracker *Tracker) {
+// AddTracker returns false and leaves the table unchanged if a tracker with the same ID is known, the ID is the
+// table's own or banned, or the table does not admit the tracker, see MaxSubnetTrackers and IDDifficulty. The tracker
+// is dialed when it is first used.
+func (r *Table) AddTracker(tracker *Tracker) bool {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
+	if !r.ID.IsZero() && tracker.ID == r.ID {
+		return false
+	}
+	if _, exists := r.trackerMap[tracker.ID]; exists || !r.admits(tracker) {
+		return false
+	}
+	if until, banned := r.banned[tracker.ID]; banned {
+		if time.Now().Before(until) {
+			return false
+		}
+		delete(r.banned, tracker.ID)
+	}
+	if key, ok := r.subnetKey(tracker); ok {
+		r.subnets[key]++
+		tracker.subnet = &key
+	}
 	r.trackerList.PushFront(tracker)
-	r.trackerMap[*(*pie.IDA)(tracker.ID.Bytes())] = r.trackerList.Front()
+	r.trackerMap[tracker.ID] = r.trackerList.Front()
 	node := r.trackerTree
-	for i := 0; i < pie.IDLen; i++ {
-		if node.children[tracker.ID.Bit(i)] == nil {
-			node.children[tracker.ID.Bit(i)] = &TreeNode{parent: node, depth: i + 1}
+	for i := 0; i < pie.IDBits; i++ {
+		bit := tracker.ID.Bit(i)
+		if node.children[bit] == nil {
+			node.children[bit] = &TreeNode{parent: node, depth: i + 1}
 		}
-		node = node.children[tracker.ID.Bit(i)]
+		node = node.children[bit]
 	}
 	node.value = tracker
+	return true
+}
+
+func (r *Table) connectTracker(ctx context.Context, tracker *Tracker) error {
+	var cert []*tls.Certificate
+	if r.Cert != nil {
+		cert = append(cert, r.Cert)
+	}
+	if r.Server != nil {
+		if err := tracker.ConnectFrom(ctx, r.Server, r.Protocol, cert...); err != nil {
+			return err
+		}
+		// The tracker may send requests on the session too, since it added us to its table
+		go r.HandleSession(r.ctx, r.Server, tracker.Session())
+		return nil
+	}
+	return tracker.Connect(ctx, r.Protocol, r.Config, cert...)
+}
+
+// RemoveTracker removes the tracker with id from the table and the session pool, and closes its session.
+func (r *Table) RemoveTracker(id pie.IDA) {
+	if tracker := r.removeTracker(id); tracker != nil {
+		r.removeSession(tracker)
+	}
 }
 
-func (r *Table) RemoveTracker(id *big.Int) {
+func (r *Table) removeTracker(id pie.IDA) *Tracker {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
-	ida := *(*pie.IDA)(id.Bytes())
-	if element, ok := r.trackerMap[ida]; ok {
+	if element, ok := r.trackerMap[id]; ok {
 		r.trackerList.Remove(element)
-		delete(r.trackerMap, ida)
+		delete(r.trackerMap, id)
+		if subnet := element.Value.(*Tracker).subnet; subnet != nil {
+			if r.subnets[*subnet]--; r.subnets[*subnet] == 0 {
+				delete(r.subnets, *subnet)
+			}
+		}
+		// remove returns whether node is left empty, so that its parent drops it
 		var remove func(*TreeNode, int) bool
 		remove = func(node *TreeNode, depth int) bool {
+			if node == nil {
+				return false
+			}
 			if node.value != nil {
 				node.value = nil
 				return true
 			}
-			if remove(node.children[id.Bit(depth)], depth+1) && node.children[-id.Bit(depth)+1] == nil {
-				node.children[id.Bit(depth)] = nil
-				return true
+			bit := id.Bit(depth)
+			if remove(node.children[bit], depth+1) {
+				node.children[bit] = nil
 			}
-			return false
+			return node.children[0] == nil && node.children[1] == nil
 		}
 		remove(r.trackerTree, 0)
+		return element.Value.(*Tracker)
 	}
+	return nil
 }
 
-func (r *Table) GetNeighbors(targetIDInt *big.Int, num int, excludeID ...*big.Int) []*Tracker {
+func (r *Table) GetNeighbors(targetID pie.IDA, num int, excludeID ...pie.IDA) []*Tracker {
 	r.mutex.RLock()
 	defer r.mutex.RUnlock()
 	result := make([]*Tracker, 0, num)
@@ -119,31 +222,94 @@ func (r *Table) GetNeighbors(targetIDInt *big.Int, num int, excludeID ...*big.In
 		if len(result) == num || node == nil {
 			return
 		}
-		if node.value != nil && (len(excludeID) == 0 || node.value.ID.Cmp(excludeID[0]) != 0) {
-			result = append(result, node.value)
+		if node.value != nil {
+			if len(excludeID) == 0 || node.value.ID != excludeID[0] {
+				result = append(result, node.value)
+			}
 			return
 		}
-		next(node.children[targetIDInt.Bit(node.depth)])
-		next(node.children[-targetIDInt.Bit(node.depth)+1])
+		bit := targetID.Bit(node.depth)
+		next(node.children[bit])
+		next(node.children[1-bit])
 	}
 	next(r.trackerTree)
 	return result
 }
 
-func (t *TreeNode) iterateNeighbors(ctx context.Context, c chan *Tracker, targetIDInt *big.Int) {
-	// TODO: lock
-	if t.value != nil {
-		select {
-		case <-ctx.Done():
-		case c <- t.value:
-		}
+// Close stops reconnecting, saves the known trackers and closes their sessions. It does nothing if the table was not
+// initialized.
+func (r *Table) Close() {
+	if r.cancel == nil {
 		return
 	}
-	children := append(make([]int, len(t.children)), int(targetIDInt.Bit(t.depth)), -int(targetIDInt.Bit(t.depth))+1)
-	for _, child := range children {
-		treeNode := t.children[child]
-		if treeNode != nil {
-			treeNode.iterateNeighbors(ctx, c, targetIDInt)
+	r.cancel()
+	_ = r.Save()
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	for element := r.trackerList.Front(); element != nil; element = element.Next() {
+		if session := element.Value.(*Tracker).Session(); session != nil {
+			session.Close(pie.SessErrNoReason)
+		}
+	}
+}
+
+// Neighbors yields the known trackers in increasing XOR distance from target and closes the channel when all are
+// yielded or ctx is done. The table is not locked between trackers, so trackers added closer than the last yielded one
+// are skipped, and removed ones are not yielded anymore.
+func (r *Table) Neighbors(ctx context.Context, target pie.IDA) <-chan *Tracker {
+	c := make(chan *Tracker)
+	go func() {
+		defer close(c)
+		var last *pie.IDA
+		for {
+			tracker := r.nextNeighbor(target, last)
+			if tracker == nil {
+				return
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case c <- tracker:
+			}
+			distance := tracker.ID.Xor(target)
+			last = &distance
+		}
+	}()
+	return c
+}
+
+// nextNeighbor returns the tracker closest to target whose distance is greater than after, or the closest one if after
+// is nil.
+func (r *Table) nextNeighbor(target pie.IDA, after *pie.IDA) *Tracker {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	// bound means the distances below node share their prefix with after, so only greater ones qualify
+	var next func(node *TreeNode, bound bool) *Tracker
+	next = func(node *TreeNode, bound bool) *Tracker {
+		if node == nil {
+			return nil
+		}
+		if node.value != nil {
+			if bound {
+				return nil
+			}
+			return node.value
+		}
+		bit := target.Bit(node.depth)
+		for _, child := range []uint{bit, 1 - bit} {
+			childBound := false
+			if bound {
+				distanceBit, afterBit := child^bit, after.Bit(node.depth)
+				if distanceBit < afterBit {
+					continue
+				}
+				childBound = distanceBit == afterBit
+			}
+			if tracker := next(node.children[child], childBound); tracker != nil {
+				return tracker
+			}
 		}
+		return nil
 	}
+	return next(r.trackerTree, after != nil)
 }
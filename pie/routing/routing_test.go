@@ -0,0 +1,242 @@
+package routing
+
+import (
+	"context"
+	"github.com/Pie-Messaging/core/pie"
+	"math/big"
+	"math/rand"
+	"sort"
+	"testing"
+	"testing/quick"
+)
+
+func newTestTable() *Table {
+	table := &Table{}
+	table.Init(context.Background(), nil)
+	return table
+}
+
+func idFromHex(t *testing.T, s string) pie.IDA {
+	t.Helper()
+	id, ok := (&big.Int{}).SetString(s, 16)
+	if !ok {
+		t.Fatalf("invalid id %q", s)
+	}
+	return pie.BytesToIDA(id.Bytes())
+}
+
+func randomID(rng *rand.Rand) pie.IDA {
+	var id pie.IDA
+	rng.Read(id[:])
+	// Leading zero bytes used to shorten IDs taken from big.Int.Bytes
+	id[0] &= byte(rng.Intn(2)) * 0xff
+	return id
+}
+
+// treeSize returns the number of trackers and of empty leaves below the root in the trie.
+func treeSize(node *TreeNode) (trackers int, emptyLeaves int) {
+	if node == nil {
+		return 0, 0
+	}
+	if node.value != nil {
+		return 1, 0
+	}
+	if node.children[0] == nil && node.children[1] == nil && node.depth > 0 {
+		return 0, 1
+	}
+	for _, child := range node.children {
+		n, e := treeSize(child)
+		trackers += n
+		emptyLeaves += e
+	}
+	return trackers, emptyLeaves
+}
+
+func TestAddRemoveTracker(t *testing.T) {
+	const (
+		zero   = "0"
+		low    = "1"
+		high   = "800000000000000000000000000000000000000000000000"
+		leadup = "000000ff0000000000000000000000000000000000000000"
+	)
+	tests := []struct {
+		name    string
+		add     []string
+		remove  []string
+		added   []bool
+		present []string
+	}{
+		{name: "single", add: []string{low}, added: []bool{true}, present: []string{low}},
+		{name: "duplicate", add: []string{low, low}, added: []bool{true, false}, present: []string{low}},
+		{name: "zero id", add: []string{zero, low}, added: []bool{true, true}, present: []string{zero, low}},
+		{name: "leading zero bytes", add: []string{leadup, high}, added: []bool{true, true}, present: []string{leadup, high}},
+		{name: "remove only", add: []string{high}, remove: []string{high}, added: []bool{true}},
+		{name: "remove with sibling", add: []string{zero, low}, remove: []string{low}, added: []bool{true, true}, present: []string{zero}},
+		{name: "remove other subtree", add: []string{low, high}, remove: []string{low}, added: []bool{true, true}, present: []string{high}},
+		{name: "remove unknown", add: []string{low}, remove: []string{high}, added: []bool{true}, present: []string{low}},
+		{name: "remove twice", add: []string{low, high}, remove: []string{high, high}, added: []bool{true, true}, present: []string{low}},
+		{name: "remove all", add: []string{zero, low, high, leadup}, remove: []string{leadup, zero, high, low}, added: []bool{true, true, true, true}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			table := newTestTable()
+			for i, id := range test.add {
+				if added := table.AddTracker(&Tracker{ID: idFromHex(t, id)}); added != test.added[i] {
+					t.Fatalf("AddTracker(%s) = %v, want %v", id, added, test.added[i])
+				}
+			}
+			for _, id := range test.remove {
+				table.RemoveTracker(idFromHex(t, id))
+			}
+			for _, id := range test.present {
+				if tracker := table.GetTracker(idFromHex(t, id)); tracker == nil {
+					t.Fatalf("GetTracker(%s) = nil", id)
+				}
+			}
+			if trackers, emptyLeaves := treeSize(table.trackerTree); trackers != len(test.present) || emptyLeaves != 0 {
+				t.Fatalf("tree holds %d trackers and %d empty leaves, want %d and 0", trackers, emptyLeaves, len(test.present))
+			}
+			if got := len(table.GetNeighbors(pie.IDA{}, len(test.add))); got != len(test.present) {
+				t.Fatalf("GetNeighbors() returned %d trackers, want %d", got, len(test.present))
+			}
+			if len(test.present) == 0 && (table.trackerTree.children[0] != nil || table.trackerTree.children[1] != nil) {
+				t.Fatal("empty table keeps tree nodes")
+			}
+		})
+	}
+}
+
+func TestGetNeighborsExclude(t *testing.T) {
+	table := newTestTable()
+	for _, id := range []byte{1, 2, 3} {
+		table.AddTracker(&Tracker{ID: pie.BytesToIDA([]byte{id})})
+	}
+	neighbors := table.GetNeighbors(pie.BytesToIDA([]byte{1}), 2, pie.BytesToIDA([]byte{1}))
+	if len(neighbors) != 2 || neighbors[0].ID[pie.IDLen-1] != 3 || neighbors[1].ID[pie.IDLen-1] != 2 {
+		t.Fatalf("GetNeighbors() = %v, want [3 2]", neighbors)
+	}
+}
+
+// TestGetNeighborsOrder checks that GetNeighbors returns the num trackers closest to the target by XOR, closest first,
+// while trackers come and go.
+func TestGetNeighborsOrder(t *testing.T) {
+	property := func(seed int64, size uint8, num uint8) bool {
+		rng := rand.New(rand.NewSource(seed))
+		table := newTestTable()
+		var ids []pie.IDA
+		for i := 0; i < int(size); i++ {
+			id := randomID(rng)
+			if table.AddTracker(&Tracker{ID: id}) {
+				ids = append(ids, id)
+			}
+		}
+		for i := 0; i < len(ids)/4; i++ {
+			j := rng.Intn(len(ids))
+			table.RemoveTracker(ids[j])
+			ids = append(ids[:j], ids[j+1:]...)
+		}
+		target := randomID(rng)
+		sort.Slice(ids, func(i, j int) bool {
+			return ids[i].Xor(target).Cmp(ids[j].Xor(target)) < 0
+		})
+		want := ids[:pie.MinInt(int(num), len(ids))]
+		neighbors := table.GetNeighbors(target, int(num))
+		if len(neighbors) != len(want) {
+			t.Logf("GetNeighbors() returned %d trackers, want %d", len(neighbors), len(want))
+			return false
+		}
+		for i, neighbor := range neighbors {
+			if neighbor.ID != want[i] {
+				t.Logf("neighbor %d = %x, want %x", i, neighbor.ID, want[i])
+				return false
+			}
+		}
+		return true
+	}
+	if err := quick.Check(property, &quick.Config{MaxCount: 200}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNeighbors(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	table := newTestTable()
+	for i := 0; i < 100; i++ {
+		table.AddTracker(&Tracker{ID: randomID(rng)})
+	}
+	target := randomID(rng)
+	want := table.GetNeighbors(target, 100)
+	i := 0
+	for tracker := range table.Neighbors(context.Background(), target) {
+		if i >= len(want) || tracker != want[i] {
+			t.Fatalf("Neighbors() yielded %x at %d", tracker.ID, i)
+		}
+		i++
+	}
+	if i != len(want) {
+		t.Fatalf("Neighbors() yielded %d trackers, want %d", i, len(want))
+	}
+}
+
+func TestNeighborsCancel(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	table := newTestTable()
+	for i := 0; i < 10; i++ {
+		table.AddTracker(&Tracker{ID: randomID(rng)})
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	neighbors := table.Neighbors(ctx, randomID(rng))
+	<-neighbors
+	cancel()
+	for range neighbors {
+	}
+	if _, ok := <-neighbors; ok {
+		t.Fatal("Neighbors() is not closed after cancel")
+	}
+}
+
+// TestNeighborsConcurrent checks that trackers present during the whole iteration are yielded in order while others
+// are added and removed.
+func TestNeighborsConcurrent(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	table := newTestTable()
+	stable := make(map[pie.IDA]bool)
+	for i := 0; i < 200; i++ {
+		id := randomID(rng)
+		table.AddTracker(&Tracker{ID: id})
+		stable[id] = true
+	}
+	target := randomID(rng)
+	churn := make([]pie.IDA, 200)
+	for i := range churn {
+		churn[i] = randomID(rng)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for round := 0; round < 20; round++ {
+			for _, id := range churn {
+				table.AddTracker(&Tracker{ID: id})
+			}
+			for _, id := range churn {
+				table.RemoveTracker(id)
+			}
+		}
+	}()
+	var last *pie.IDA
+	yielded := 0
+	for tracker := range table.Neighbors(context.Background(), target) {
+		distance := tracker.ID.Xor(target)
+		if last != nil && distance.Cmp(*last) <= 0 {
+			t.Fatalf("Neighbors() yielded %x out of order", tracker.ID)
+		}
+		last = &distance
+		if stable[tracker.ID] {
+			yielded++
+		}
+	}
+	<-done
+	if yielded != len(stable) {
+		t.Fatalf("Neighbors() yielded %d stable trackers, want %d", yielded, len(stable))
+	}
+}
@@ -0,0 +1,118 @@
+package routing
+
+import (
+	"context"
+	"github.com/Pie-Messaging/core/pie"
+	"github.com/Pie-Messaging/core/pie/pb"
+	"time"
+)
+
+// Serve answers routing requests on the sessions accepted by server until ctx is done or server is closed.
+func (r *Table) Serve(ctx context.Context, server *pie.Server) {
+	for {
+		session, err := server.AcceptSession(ctx, true)
+		if err != nil {
+			return
+		}
+		go r.HandleSession(ctx, server, session)
+	}
+}
+
+func (r *Table) HandleSession(ctx context.Context, server *pie.Server, session *pie.Session) {
+	for {
+		stream, err := session.AcceptStream(ctx, nil, true)
+		if err != nil {
+			return
+		}
+		go r.HandleStream(server, session, stream)
+	}
+}
+
+func (r *Table) HandleStream(server *pie.Server, session *pie.Session, stream *pie.Stream) {
+	defer stream.Close()
+	message, err := stream.RecvMessage(time.Now().Add(time.Duration(r.Config.Routing.RecvTimeout)))
+	if err != nil {
+		return
+	}
+	switch body := message.Body.(type) {
+	case *pb.NetMessage_FindTrackerReq:
+		r.HandleFindTrackerReq(stream, body.FindTrackerReq)
+	case *pb.NetMessage_FindResourceReq:
+		r.HandleFindResourceReq(stream, body.FindResourceReq)
+	case *pb.NetMessage_PutResourceReq:
+		r.HandlePutResourceReq(stream, body.PutResourceReq)
+	case *pb.NetMessage_GetAddrReq:
+		server.HandleGetAddrReq(session, stream)
+	case *pb.NetMessage_ClientCertReq:
+		r.HandleClientCertReq(server, session, body.ClientCertReq)
+	case *pb.NetMessage_PunchReq:
+		_ = r.Rendezvous.HandlePunchReq(session, stream, body.PunchReq)
+	case *pb.NetMessage_RelayReq:
+		if r.Relay != nil {
+			_ = r.Relay.HandleRelayReq(session, stream, body.RelayReq, time.Duration(r.Config.Routing.RecvTimeout))
+		}
+	}
+}
+
+// HandleFindTrackerReq answers with the KSize known trackers closest to the requested ID, and ignores malformed IDs.
+func (r *Table) HandleFindTrackerReq(stream *pie.Stream, req *pb.FindTrackerReq) {
+	if len(req.Id) != pie.IDLen {
+		return
+	}
+	_ = stream.SendMessage(&pb.NetMessage{Body: &pb.NetMessage_FindTrackerRes{FindTrackerRes: &pb.FindTrackerRes{
+		Status:     pb.Status_OK,
+		Candidates: r.candidates(pie.BytesToIDA(req.Id)),
+	}}})
+}
+
+// candidates lists the KSize known trackers closest to id for a response.
+func (r *Table) candidates(id pie.IDA) []*pb.Tracker {
+	neighbors := r.GetNeighbors(id, r.Config.Routing.KSize)
+	candidates := make([]*pb.Tracker, 0, len(neighbors))
+	for _, tracker := range neighbors {
+		candidates = append(candidates, tracker.Proto())
+	}
+	return candidates
+}
+
+// HandleClientCertReq adds a tracker that authenticated on session, reachable at the address it dialed from. A known
+// tracker without a session adopts this one. A user that authenticated, possibly from a linked device, is registered
+// in the Rendezvous instead, once per device.
+func (r *Table) HandleClientCertReq(server *pie.Server, session *pie.Session, req *pb.ClientCertReq) {
+	switch session.Session.ConnectionState().TLS.NegotiatedProtocol {
+	case pie.UserTLSProto:
+		if server.VerifyClientCert(req.CertDer, req.ServerCertSign, req.Device) != nil {
+			return
+		}
+		id := pie.HashBytes(req.CertDer, pie.IDLen)
+		if req.Device != nil {
+			// Each device of the user is registered on its own session
+			r.Rendezvous.Register(id, session, pie.HashBytes(req.Device.CertDer, pie.IDLen))
+		} else {
+			r.Rendezvous.Register(id, session)
+		}
+		return
+	case pie.TrackerTLSProto:
+	default:
+		return
+	}
+	if server.VerifyClientCert(req.CertDer, req.ServerCertSign) != nil {
+		return
+	}
+	tracker := &Tracker{
+		ID:       pie.BytesToIDA(pie.HashBytes(req.CertDer, pie.IDLen)),
+		Addr:     Addr{session.Session.RemoteAddr().String()},
+		session:  session,
+		lastSeen: time.Now(),
+	}
+	if tracker.ID == r.ID {
+		return
+	}
+	if r.AddTracker(tracker) {
+		r.addSession(tracker, session)
+	} else if known := r.GetTracker(tracker.ID); known != nil && known.adopt(session) {
+		// A known tracker that lost its session is reachable again
+		pie.Logger.Println("Tracker reconnected:", known.Addr)
+		r.addSession(known, session)
+	}
+}
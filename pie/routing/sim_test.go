@@ -0,0 +1,191 @@
+package routing
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/Pie-Messaging/core/pie"
+	"github.com/Pie-Messaging/core/pie/memnet"
+	"io"
+	"math/rand"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	pie.Logger.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+type simTracker struct {
+	table  *Table
+	server *pie.Server
+}
+
+func newSimTracker(ctx context.Context, t testing.TB, network *memnet.Network) *simTracker {
+	t.Helper()
+	cert, _, _, err := pie.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := pie.DefaultConfig()
+	config.Transport = network
+	config.MaxMessageLen = 16 * 1024
+	config.QUIC.HandshakeIdleTimeout = pie.Duration(100 * time.Millisecond)
+	config.Routing.RecvTimeout = pie.Duration(500 * time.Millisecond)
+	// memnet numbers its hosts from 10.0.0.1, so all trackers share a few /24s
+	config.Routing.MaxSubnetTrackers = 0
+	// Users connect as well, to punch and relay through the tracker
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{*cert},
+		NextProtos:   []string{pie.TrackerTLSProto, pie.UserTLSProto},
+	}
+	server, err := pie.ListenNet(":7000", tlsConfig, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	table := &Table{
+		Protocol: pie.TrackerTLSProto,
+		Config:   config,
+		ID:       pie.BytesToIDA(pie.HashBytes(cert.Certificate[0], pie.IDLen)),
+		Server:   server,
+		Cert:     cert,
+	}
+	go table.Serve(ctx, server)
+	return &simTracker{table: table, server: server}
+}
+
+func (s *simTracker) addr() string {
+	return s.server.Listener.Addr().String()
+}
+
+func (s *simTracker) close() {
+	s.server.Close()
+	s.table.Close()
+}
+
+// join bootstraps from addr and looks up its own ID, so that the trackers close to it learn about it.
+func (s *simTracker) join(ctx context.Context, addr ...string) {
+	s.table.Init(ctx, NewBootstrapTrackers(addr))
+	s.table.FindTracker(ctx, s.table.ID, s.table.Config.Routing.Alpha, time.Duration(s.table.Config.Routing.RecvTimeout))
+}
+
+// simulate starts n trackers joining through random earlier ones, a batch at a time.
+func simulate(ctx context.Context, t testing.TB, network *memnet.Network, n int, rng *rand.Rand) []*simTracker {
+	trackers := make([]*simTracker, n)
+	for i := range trackers {
+		trackers[i] = newSimTracker(ctx, t, network)
+	}
+	trackers[0].join(ctx)
+	const batch = 50
+	for start := 1; start < n; start += batch {
+		wg := sync.WaitGroup{}
+		for i := start; i < start+batch && i < n; i++ {
+			bootstrap := trackers[rng.Intn(start)].addr()
+			wg.Add(1)
+			go func(tracker *simTracker) {
+				defer wg.Done()
+				tracker.join(ctx, bootstrap)
+			}(trackers[i])
+		}
+		wg.Wait()
+	}
+	return trackers
+}
+
+// oracle is a table knowing exactly the given trackers, which gives the true neighbors of any ID.
+func oracle(trackers []*simTracker) *Table {
+	table := &Table{}
+	table.Init(context.Background(), nil)
+	for _, tracker := range trackers {
+		table.AddTracker(&Tracker{ID: tracker.table.ID})
+	}
+	return table
+}
+
+// lookup runs FindTracker from tracker and returns the rounds and the share of the true k closest live
+// trackers among the k closest live ones it knows afterwards.
+func lookup(ctx context.Context, tracker *simTracker, truth *Table, live map[pie.IDA]bool, target pie.IDA, k int) (int, float64) {
+	config := tracker.table.Config
+	rounds := tracker.table.FindTracker(ctx, target, config.Routing.Alpha, time.Duration(config.Routing.RecvTimeout))
+	expected := make(map[pie.IDA]bool, k)
+	for _, neighbor := range truth.GetNeighbors(target, k) {
+		expected[neighbor.ID] = true
+	}
+	found := 0
+	known := 0
+	for _, neighbor := range tracker.table.GetNeighbors(target, 4*k) {
+		id := neighbor.ID
+		if !live[id] {
+			continue
+		}
+		if expected[id] {
+			found++
+		}
+		if known++; known == k {
+			break
+		}
+	}
+	return rounds, float64(found) / float64(len(expected))
+}
+
+func TestSimulatedNetwork(t *testing.T) {
+	if testing.Short() {
+		t.Skip("simulates 1000 trackers")
+	}
+	const (
+		n       = 1000
+		k       = 8
+		lookups = 100
+	)
+	network := memnet.NewNetwork(1)
+	network.Latency = 200 * time.Microsecond
+	network.Jitter = 200 * time.Microsecond
+	network.Loss = 0.01
+	rng := rand.New(rand.NewSource(1))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	trackers := simulate(ctx, t, network, n, rng)
+	defer func() {
+		for _, tracker := range trackers {
+			tracker.close()
+		}
+	}()
+
+	check := func(name string, alive []*simTracker, minOverlap float64, maxRounds int) {
+		truth := oracle(alive)
+		live := make(map[pie.IDA]bool, len(alive))
+		for _, tracker := range alive {
+			live[tracker.table.ID] = true
+		}
+		totalRounds, worstRounds, totalOverlap := 0, 0, 0.0
+		for i := 0; i < lookups; i++ {
+			target := pie.BytesToIDA(pie.HashBytes([]byte{byte(i), byte(i >> 8)}, pie.IDLen))
+			rounds, overlap := lookup(ctx, alive[rng.Intn(len(alive))], truth, live, target, k)
+			totalRounds += rounds
+			totalOverlap += overlap
+			if rounds > worstRounds {
+				worstRounds = rounds
+			}
+		}
+		meanOverlap := totalOverlap / lookups
+		t.Logf("%s: mean overlap %.3f, mean rounds %.2f, worst rounds %d",
+			name, meanOverlap, float64(totalRounds)/lookups, worstRounds)
+		if meanOverlap < minOverlap {
+			t.Errorf("%s: mean overlap with the true %d closest is %.3f, want at least %.2f", name, k, meanOverlap, minOverlap)
+		}
+		if worstRounds > maxRounds {
+			t.Errorf("%s: a lookup took %d rounds, want at most %d", name, worstRounds, maxRounds)
+		}
+	}
+
+	check("stable", trackers, 0.9, 10)
+
+	// Churn: a fifth of the trackers leave, lookups must route around them
+	rng.Shuffle(len(trackers), func(i, j int) { trackers[i], trackers[j] = trackers[j], trackers[i] })
+	for _, tracker := range trackers[:n/5] {
+		tracker.close()
+	}
+	check("churn", trackers[n/5:], 0.8, 15)
+}
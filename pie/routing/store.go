@@ -0,0 +1,113 @@
+package routing
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/Pie-Messaging/core/pie"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// TrackerRecord is a known tracker as it is persisted between runs.
+type TrackerRecord struct {
+	ID       pie.IDA   `json:"id"`
+	Addr     Addr      `json:"addr"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// Store persists the known trackers, so a restarted table does not depend on bootstrap trackers only.
+type Store interface {
+	Load() ([]TrackerRecord, error)
+	Save(records []TrackerRecord) error
+}
+
+// FileStore keeps a JSON snapshot of the trackers in a single file.
+type FileStore struct {
+	Path  string
+	mutex sync.Mutex
+}
+
+func NewFileStore(path string) *FileStore {
+	return &FileStore{Path: path}
+}
+
+func (f *FileStore) Load() ([]TrackerRecord, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	data, err := os.ReadFile(f.Path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var records []TrackerRecord
+	if err = json.Unmarshal(data, &records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// Save replaces the snapshot atomically, so a crash keeps the previous one.
+func (f *FileStore) Save(records []TrackerRecord) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	data, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(f.Path), filepath.Base(f.Path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.Path)
+}
+
+// Save writes the known trackers to the table's store, if it has one.
+func (r *Table) Save() error {
+	if r.Store == nil {
+		return nil
+	}
+	r.mutex.RLock()
+	records := make([]TrackerRecord, 0, r.trackerList.Len())
+	for element := r.trackerList.Front(); element != nil; element = element.Next() {
+		tracker := element.Value.(*Tracker)
+		records = append(records, TrackerRecord{ID: tracker.ID, Addr: tracker.Addr, LastSeen: tracker.LastSeen()})
+	}
+	r.mutex.RUnlock()
+	if err := r.Store.Save(records); err != nil {
+		pie.Logger.Println("Failed to save trackers:", err)
+		return err
+	}
+	return nil
+}
+
+// loadTrackers returns the trackers saved in the table's store, or none if it cannot be read.
+func (r *Table) loadTrackers() []*Tracker {
+	if r.Store == nil {
+		return nil
+	}
+	records, err := r.Store.Load()
+	if err != nil {
+		pie.Logger.Println("Failed to load trackers:", err)
+		return nil
+	}
+	trackers := make([]*Tracker, 0, len(records))
+	for _, record := range records {
+		if record.ID.IsZero() || len(record.Addr) == 0 {
+			continue
+		}
+		trackers = append(trackers, &Tracker{ID: record.ID, Addr: record.Addr, lastSeen: record.LastSeen})
+	}
+	return trackers
+}
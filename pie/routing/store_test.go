@@ -0,0 +1,82 @@
+package routing
+
+import (
+	"context"
+	"github.com/Pie-Messaging/core/pie/memnet"
+	"math/rand"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFileStore(t *testing.T) {
+	store := NewFileStore(filepath.Join(t.TempDir(), "trackers.json"))
+	records, err := store.Load()
+	if err != nil || records != nil {
+		t.Fatalf("Load() of a missing file = %v, %v, want nil, nil", records, err)
+	}
+	rng := rand.New(rand.NewSource(1))
+	want := []TrackerRecord{
+		{ID: randomID(rng), Addr: Addr{"10.0.0.1:7000", "[fd00::1]:7000"}, LastSeen: time.Unix(1600000000, 0).UTC()},
+		{ID: randomID(rng), Addr: Addr{"10.0.0.2:7000"}},
+	}
+	if err = store.Save(want); err != nil {
+		t.Fatal(err)
+	}
+	if records, err = store.Load(); err != nil || !reflect.DeepEqual(records, want) {
+		t.Fatalf("Load() = %v, %v, want %v", records, err, want)
+	}
+}
+
+// TestTableRestart checks that a tracker restarting without bootstrap trackers knows the ones it saved and reconnects
+// to them when it looks them up.
+func TestTableRestart(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	network := memnet.NewNetwork(1)
+	trackers := simulate(ctx, t, network, 8, rand.New(rand.NewSource(1)))
+	defer func() {
+		for _, tracker := range trackers {
+			tracker.close()
+		}
+	}()
+	// The joining trackers authenticate to trackers[0] asynchronously
+	first := trackers[0].table
+	for deadline := time.Now().Add(5 * time.Second); len(first.GetNeighbors(first.ID, len(trackers))) < len(trackers)-1; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("trackers[0] did not learn the joining trackers")
+		}
+	}
+	store := NewFileStore(filepath.Join(t.TempDir(), "trackers.json"))
+	trackers[0].table.Store = store
+	trackers[0].close()
+	records, err := store.Load()
+	if err != nil || len(records) == 0 {
+		t.Fatalf("Load() = %d records, %v", len(records), err)
+	}
+
+	restarted := newSimTracker(ctx, t, network)
+	trackers[0] = restarted
+	restarted.table.Store = store
+	restarted.table.Init(ctx, nil)
+	for _, record := range records {
+		tracker := restarted.table.GetTracker(record.ID)
+		if tracker == nil {
+			t.Fatalf("GetTracker(%x) = nil after restart", record.ID)
+		}
+		if tracker.Session() != nil {
+			t.Fatalf("tracker %x is connected before use", record.ID)
+		}
+	}
+	for _, record := range records {
+		tracker := restarted.table.GetTracker(record.ID)
+		restarted.table.FindTracker(ctx, record.ID, 1, time.Second)
+		if tracker.Session() == nil {
+			t.Fatalf("tracker %x did not reconnect", record.ID)
+		}
+		if !tracker.LastSeen().After(record.LastSeen) {
+			t.Errorf("LastSeen() = %v, want after %v", tracker.LastSeen(), record.LastSeen)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package routing
+
+import (
+	"net"
+	"time"
+)
+
+// subnetKey identifies the trackers of one subnet in one bucket, the trackers sharing the same prefix length with the
+// table's own ID.
+type subnetKey struct {
+	bucket int
+	subnet string
+}
+
+// subnetOf returns the IPv4 /24 or IPv6 /48 of the first address of tracker, or false if it is not an IP address.
+func subnetOf(tracker *Tracker) (string, bool) {
+	if len(tracker.Addr) == 0 {
+		return "", false
+	}
+	host, _, err := net.SplitHostPort(tracker.Addr[0])
+	if err != nil {
+		return "", false
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return "", false
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(24, 32)).String(), true
+	}
+	return ip.Mask(net.CIDRMask(48, 128)).String(), true
+}
+
+// admits reports whether tracker has the proof of work the table requires and its subnet has room in its bucket. The
+// caller holds r.mutex.
+func (r *Table) admits(tracker *Tracker) bool {
+	if tracker.ID.Difficulty() < r.Config.Routing.IDDifficulty {
+		return false
+	}
+	key, ok := r.subnetKey(tracker)
+	return !ok || r.Config.Routing.MaxSubnetTrackers <= 0 || r.subnets[key] < r.Config.Routing.MaxSubnetTrackers
+}
+
+func (r *Table) subnetKey(tracker *Tracker) (subnetKey, bool) {
+	subnet, ok := subnetOf(tracker)
+	if !ok {
+		return subnetKey{}, false
+	}
+	return subnetKey{bucket: r.ID.CommonPrefixLen(tracker.ID), subnet: subnet}, true
+}
+
+// canQuery reports whether a lookup may query tracker, so that candidates are not dialed in vain. The table need not
+// admit it: the subnet cap only limits which trackers are stored, while banned IDs and IDs without the proof of work
+// are never queried.
+func (r *Table) canQuery(tracker *Tracker) bool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	if !r.ID.IsZero() && tracker.ID == r.ID {
+		return false
+	}
+	if until, banned := r.banned[tracker.ID]; banned && time.Now().Before(until) {
+		return false
+	}
+	return tracker.ID.Difficulty() >= r.Config.Routing.IDDifficulty
+}
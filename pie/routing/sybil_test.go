@@ -0,0 +1,249 @@
+package routing
+
+import (
+	"context"
+	"github.com/Pie-Messaging/core/pie"
+	"github.com/Pie-Messaging/core/pie/memnet"
+	"github.com/Pie-Messaging/core/pie/pb"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestSubnetOf(t *testing.T) {
+	tests := []struct {
+		addr   Addr
+		subnet string
+		ok     bool
+	}{
+		{addr: Addr{"192.0.2.77:7000"}, subnet: "192.0.2.0", ok: true},
+		{addr: Addr{"[2001:db8:1:2::7]:7000", "192.0.2.1:7000"}, subnet: "2001:db8:1::", ok: true},
+		{addr: Addr{"[::ffff:192.0.2.77]:7000"}, subnet: "192.0.2.0", ok: true},
+		{addr: Addr{"tracker.example:7000"}},
+		{addr: Addr{"192.0.2.77"}},
+		{},
+	}
+	for _, test := range tests {
+		subnet, ok := subnetOf(&Tracker{Addr: test.addr})
+		if subnet != test.subnet || ok != test.ok {
+			t.Errorf("subnetOf(%v) = %q, %v, want %q, %v", test.addr, subnet, ok, test.subnet, test.ok)
+		}
+	}
+}
+
+// idInBucket returns a random ID sharing exactly bucket leading bits with own.
+func idInBucket(rng *rand.Rand, own pie.IDA, bucket int) pie.IDA {
+	id := randomID(rng)
+	for n := 0; n <= bucket; n++ {
+		mask := byte(0x80 >> (n % 8))
+		bit := own[n/8] & mask
+		if n == bucket {
+			bit ^= mask
+		}
+		id[n/8] = id[n/8]&^mask | bit
+	}
+	return id
+}
+
+func TestSubnetLimit(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	table := &Table{ID: randomID(rng)}
+	table.Init(context.Background(), nil)
+	table.Config.Routing.MaxSubnetTrackers = 2
+	add := func(bucket int, addr string) *Tracker {
+		tracker := &Tracker{ID: idInBucket(rng, table.ID, bucket), Addr: Addr{addr}}
+		if table.ID.CommonPrefixLen(tracker.ID) != bucket {
+			t.Fatalf("tracker is not in bucket %d", bucket)
+		}
+		if !table.AddTracker(tracker) {
+			return nil
+		}
+		return tracker
+	}
+	first := add(0, "192.0.2.1:7000")
+	if first == nil || add(0, "192.0.2.2:7000") == nil {
+		t.Fatal("AddTracker() = false below the limit")
+	}
+	if add(0, "192.0.2.3:7000") != nil {
+		t.Fatal("AddTracker() = true for a third tracker of a /24 in a bucket")
+	}
+	if add(0, "198.51.100.1:7000") == nil || add(1, "192.0.2.3:7000") == nil {
+		t.Fatal("AddTracker() = false for another subnet or bucket")
+	}
+	if add(0, "tracker.example:7000") == nil {
+		t.Fatal("AddTracker() = false for an address without subnet")
+	}
+	table.RemoveTracker(first.ID)
+	if add(0, "192.0.2.3:7000") == nil {
+		t.Fatal("AddTracker() = false after a tracker of the subnet was removed")
+	}
+}
+
+func TestIDDifficulty(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	table := &Table{}
+	table.Init(context.Background(), nil)
+	table.Config.Routing.IDDifficulty = 4
+	for added, rejected := 0, 0; added == 0 || rejected == 0; {
+		id := randomID(rng)
+		enough := id.Difficulty() >= 4
+		if table.AddTracker(&Tracker{ID: id}) != enough {
+			t.Fatalf("AddTracker(%x) with difficulty %d = %v", id, id.Difficulty(), !enough)
+		}
+		if enough {
+			added++
+		} else {
+			rejected++
+		}
+	}
+}
+
+func TestLookupPathClaims(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	target := randomID(rng)
+	trackers := make([]*Tracker, 6)
+	for i := range trackers {
+		trackers[i] = &Tracker{ID: randomID(rng)}
+	}
+	claims := &claims{paths: make(map[pie.IDA]int)}
+	a := &lookupPath{index: 0, queried: make(map[pie.IDA]struct{})}
+	b := &lookupPath{index: 1, queried: make(map[pie.IDA]struct{})}
+	a.add(target, trackers)
+	b.add(target, trackers)
+	for i := 1; i < len(a.candidates); i++ {
+		if a.candidates[i-1].ID.Xor(target).Cmp(a.candidates[i].ID.Xor(target)) >= 0 {
+			t.Fatal("candidates are not ordered by distance")
+		}
+	}
+	nextA := a.next(claims, 2)
+	nextB := b.next(claims, 2)
+	if len(nextA) != 2 || len(nextB) != 2 {
+		t.Fatalf("next() returned %d and %d trackers, want 2", len(nextA), len(nextB))
+	}
+	for _, tracker := range nextA {
+		for _, other := range nextB {
+			if tracker == other {
+				t.Fatalf("tracker %x is queried on both paths", tracker.ID)
+			}
+		}
+		a.queried[tracker.ID] = struct{}{}
+	}
+	if next := a.next(claims, 2); len(next) != 0 {
+		t.Fatalf("next() = %d trackers after the closest were queried, want 0", len(next))
+	}
+}
+
+// TestSubnetCapLookup checks that a lookup still queries the trackers the subnet cap keeps out of the table.
+func TestSubnetCapLookup(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	network := memnet.NewNetwork(1)
+	trackers := make([]*simTracker, 8)
+	for i := range trackers {
+		trackers[i] = newSimTracker(ctx, t, network)
+		defer trackers[i].close()
+	}
+	// All trackers join through the first one, which knows them all then
+	first := trackers[0]
+	first.join(ctx)
+	for _, tracker := range trackers[1:] {
+		tracker.join(ctx, first.addr())
+	}
+	// a shares no leading bit with the first tracker, so the cap of 1 fills bucket 0 with it
+	a := newSimTracker(ctx, t, network)
+	defer a.close()
+	a.table.ID = idInBucket(rand.New(rand.NewSource(1)), first.table.ID, 0)
+	a.table.Config.Routing.MaxSubnetTrackers = 1
+	a.table.Init(ctx, NewBootstrapTrackers([]string{first.addr()}))
+	var capped *simTracker
+	for _, tracker := range trackers[1:] {
+		if a.table.ID.CommonPrefixLen(tracker.table.ID) == 0 {
+			capped = tracker
+			break
+		}
+	}
+	if capped == nil {
+		t.Skip("no other tracker in bucket 0")
+	}
+
+	// Only capped stores the resource
+	user, resource := newUserResource(t)
+	if status := capped.table.StoreResource(pb.ResourceType_USER, resource); status != pb.Status_OK {
+		t.Fatalf("StoreResource() = %v", status)
+	}
+	recvTimeout := time.Duration(a.table.Config.Routing.RecvTimeout)
+	found, err := a.table.FindResource(ctx, pie.BytesToIDA(user.Id), pb.ResourceType_USER, len(trackers), recvTimeout)
+	if err != nil || found.GetUser().GetName() != user.Name {
+		t.Fatalf("FindResource() = %v, %v, want %v", found, err, user)
+	}
+	if a.table.GetTracker(capped.table.ID) != nil {
+		t.Fatal("tracker beyond the subnet cap is added")
+	}
+}
+
+func TestConnectVerifiesID(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	network := memnet.NewNetwork(1)
+	trackers := simulate(ctx, t, network, 2, rand.New(rand.NewSource(1)))
+	defer func() {
+		for _, tracker := range trackers {
+			tracker.close()
+		}
+	}()
+	a, b := trackers[0], trackers[1]
+	bogus := &Tracker{ID: randomID(rand.New(rand.NewSource(2))), Addr: Addr{b.addr()}}
+	if err := bogus.ConnectFrom(ctx, a.server, pie.TrackerTLSProto, a.table.Cert); err != pie.ErrPeerMismatch {
+		t.Fatalf("ConnectFrom() with a wrong ID = %v, want %v", err, pie.ErrPeerMismatch)
+	}
+	if bogus.Session() != nil {
+		t.Fatal("tracker with a wrong ID has a session")
+	}
+	if added, _ := a.table.addVerified(ctx, bogus, time.Second); added || a.table.GetTracker(bogus.ID) != nil {
+		t.Fatal("tracker with a wrong ID is added")
+	}
+}
+
+// TestForgedCandidates checks that a candidate whose address holds another cert is never added, and that the tracker
+// reporting it is removed once it did so MaxMisreports times.
+func TestForgedCandidates(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	network := memnet.NewNetwork(1)
+	trackers := simulate(ctx, t, network, 3, rand.New(rand.NewSource(1)))
+	defer func() {
+		for _, tracker := range trackers {
+			tracker.close()
+		}
+	}()
+	a, b, c := trackers[0], trackers[1], trackers[2]
+	a.table.Config.Routing.MaxMisreports = 3
+	events := make(chan TrackerEvent, 16)
+	a.table.SetEvents(events)
+	reporter := a.table.GetTracker(b.table.ID)
+	if reporter == nil {
+		t.Fatal("a does not know b")
+	}
+	// b reports a forged ID at the address of c
+	forged := randomID(rand.New(rand.NewSource(2)))
+	b.table.AddTracker(&Tracker{ID: forged, Addr: Addr{c.addr()}})
+
+	recvTimeout := time.Duration(a.table.Config.Routing.RecvTimeout)
+	for i := 0; i < 3; i++ {
+		a.table.FindTracker(ctx, forged, 1, recvTimeout)
+		if a.table.GetTracker(forged) != nil {
+			t.Fatal("forged candidate is added")
+		}
+	}
+	if event := waitEvent(t, events, b.table.ID, TrackerRemoved); event.Err != pie.ErrPeerMismatch {
+		t.Fatalf("TrackerRemoved error = %v, want %v", event.Err, pie.ErrPeerMismatch)
+	}
+	// c reports b as well, but b stays banned
+	a.table.FindTracker(ctx, b.table.ID, 2, recvTimeout)
+	if a.table.GetTracker(b.table.ID) != nil {
+		t.Fatal("tracker reporting forged candidates is in the table again")
+	}
+	if a.table.AddTracker(&Tracker{ID: b.table.ID, Addr: Addr{b.addr()}}) {
+		t.Fatal("AddTracker() = true for a banned tracker")
+	}
+}
@@ -1,46 +1,182 @@
 package routing
 
 import (
+	"container/list"
 	"context"
 	"crypto/tls"
 	"encoding/json"
 	"github.com/Pie-Messaging/core/pie"
-	"math/big"
+	"github.com/Pie-Messaging/core/pie/pb"
 	"sync"
+	"time"
 )
 
 type Tracker struct {
-	ID       *big.Int
+	ID       pie.IDA
 	Addr     Addr
 	session  *pie.Session
-	LiveFlag int32
+	lastSeen time.Time
+	rtt      time.Duration
+	failures int
+	// misreports counts the reported candidates whose cert did not match their ID, which answers do not reset
+	misreports int
+	// nextDial is when a tracker that failed may be dialed again
+	nextDial time.Time
 	mutex    sync.RWMutex
+	// dialMutex is held by Table.dial, so that concurrent lookups share one connection attempt
+	dialMutex sync.Mutex
+	// subnet is the key the table counted the tracker under when adding it, guarded by Table.mutex
+	subnet *subnetKey
+	// poolElement is the tracker's entry in Table.sessions while it has a session, guarded by Table.sessionMutex
+	poolElement *list.Element
 }
 
-func (t *Tracker) Connect(ctx context.Context, protocol string, cert ...*tls.Certificate) error {
-	t.mutex.Lock()
-	defer t.mutex.Unlock()
+func NewBootstrapTrackers(addrList []string) []*Tracker {
+	trackers := make([]*Tracker, 0, len(addrList))
+	for _, addr := range addrList {
+		trackers = append(trackers, &Tracker{Addr: Addr{addr}})
+	}
+	return trackers
+}
+
+// Connect dials the tracker. With the tracker protocol, cert authenticates the dialing tracker.
+func (t *Tracker) Connect(ctx context.Context, protocol string, config *pie.Config, cert ...*tls.Certificate) error {
+	return t.connect(protocol, cert, func(tlsConfig *tls.Config) (*pie.Session, error) {
+		return pie.Connect(ctx, tlsConfig, config, t.Addr...)
+	})
+}
+
+// ConnectFrom dials the tracker from the socket of server, so the tracker sees our listening address.
+func (t *Tracker) ConnectFrom(ctx context.Context, server *pie.Server, protocol string, cert ...*tls.Certificate) error {
+	return t.connect(protocol, cert, func(tlsConfig *tls.Config) (*pie.Session, error) {
+		if len(t.Addr) == 0 {
+			return nil, pie.ErrNoAddr
+		}
+		return server.Dial(ctx, tlsConfig, t.Addr[0])
+	})
+}
+
+func (t *Tracker) connect(protocol string, cert []*tls.Certificate, dial func(*tls.Config) (*pie.Session, error)) error {
 	tlsConfig := &tls.Config{
 		NextProtos:         []string{protocol},
 		InsecureSkipVerify: true,
 	}
-	session, err := pie.Connect(ctx, tlsConfig, t.Addr...)
+	session, err := dial(tlsConfig)
 	if err != nil {
 		return err
 	}
-	t.session = session
-	if protocol == pie.TrackerTLSProto {
-		err := t.session.SendCert(cert[0])
+	if protocol == pie.TrackerTLSProto && len(cert) != 0 {
+		err := session.SendCert(cert[0])
 		if err != nil {
+			session.Close(pie.SessErrNoReason)
 			return err
 		}
 	}
-	if t.ID.BitLen() == 0 {
-		t.ID.SetBytes(t.session.GetPeerIDByCertHash())
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	// A tracker with a known ID must hold the cert it hashes from, or anyone could claim the ID
+	peerID := pie.BytesToIDA(session.GetPeerIDByCertHash())
+	if !t.ID.IsZero() && peerID != t.ID {
+		session.Close(pie.SessErrNoReason)
+		return pie.ErrPeerMismatch
 	}
+	t.session = session
+	t.ID = peerID
+	t.lastSeen = time.Now()
+	t.failures = 0
 	return nil
 }
 
+func (t *Tracker) Proto() *pb.Tracker {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return &pb.Tracker{Id: t.ID[:], Addr: t.Addr}
+}
+
+// LastSeen returns when the tracker last connected or answered, or the zero time if it never did.
+func (t *Tracker) LastSeen() time.Time {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return t.lastSeen
+}
+
+// RTT returns the smoothed round-trip time of requests to the tracker, or 0 before the first answer.
+func (t *Tracker) RTT() time.Duration {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return t.rtt
+}
+
+// Failures returns the number of consecutive failed connections and requests.
+func (t *Tracker) Failures() int {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return t.failures
+}
+
+// succeeded records an answer that took rtt, smoothed the way TCP does.
+func (t *Tracker) succeeded(rtt time.Duration) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.lastSeen = time.Now()
+	t.failures = 0
+	if t.rtt == 0 {
+		t.rtt = rtt
+	} else {
+		t.rtt += (rtt - t.rtt) / 8
+	}
+}
+
+func (t *Tracker) failed() int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.failures++
+	return t.failures
+}
+
+func (t *Tracker) misreported() int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.misreports++
+	return t.misreports
+}
+
+func (t *Tracker) setNextDial(next time.Time) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.nextDial = next
+}
+
+func (t *Tracker) canDial() bool {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return !time.Now().Before(t.nextDial)
+}
+
+// adopt uses a session the tracker opened to us if it has none.
+func (t *Tracker) adopt(session *pie.Session) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.session != nil {
+		return false
+	}
+	t.session = session
+	t.lastSeen = time.Now()
+	t.failures = 0
+	return true
+}
+
+// dropSession forgets session and returns true if it is still the tracker's session.
+func (t *Tracker) dropSession(session *pie.Session) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.session != session {
+		return false
+	}
+	t.session = nil
+	return true
+}
+
 func (t *Tracker) Session() *pie.Session {
 	t.mutex.RLock()
 	defer t.mutex.RUnlock()
@@ -1,5 +1,10 @@
 package routing
 
+import (
+	"context"
+	"time"
+)
+
 func testAll(l int, f func(int) bool) bool {
 	for i := 0; i < l; i++ {
 		if !f(i) {
@@ -17,3 +22,12 @@ func testAny(l int, f func(int) bool) bool {
 	}
 	return false
 }
+
+// recvDeadline returns the deadline of a request, which is recvTimeout from now unless ctx ends earlier.
+func recvDeadline(ctx context.Context, recvTimeout time.Duration) time.Time {
+	deadline := time.Now().Add(recvTimeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		return ctxDeadline
+	}
+	return deadline
+}
@@ -0,0 +1,156 @@
+package pie
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"github.com/Pie-Messaging/core/pie/pb"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"time"
+)
+
+// RelayHelloSignPrefix separates relay hello signatures from the other signatures made with the same key.
+const RelayHelloSignPrefix = "pie relay hello:"
+
+// SecureStream encrypts messages end to end on a stream whose bytes pass through an untrusted relay.
+type SecureStream struct {
+	Stream   *Stream
+	PeerCert *x509.Certificate
+	sendAEAD cipher.AEAD
+	recvAEAD cipher.AEAD
+	sendSeq  uint64
+	recvSeq  uint64
+}
+
+// NewSecureStream runs an ephemeral X25519 exchange signed by both certificates together with both IDs.
+// The peer's certificate must hash to peerID.
+func NewSecureStream(stream *Stream, cert *tls.Certificate, peerID []byte, deadline time.Time) (*SecureStream, error) {
+	privateKey := make([]byte, curve25519.ScalarSize)
+	if _, err := rand.Read(privateKey); err != nil {
+		Logger.Println("Failed to generate ephemeral key:", err)
+		return nil, err
+	}
+	publicKey, err := curve25519.X25519(privateKey, curve25519.Basepoint)
+	if err != nil {
+		Logger.Println("Failed to generate ephemeral key:", err)
+		return nil, err
+	}
+	id := HashBytes(cert.Certificate[0], IDLen)
+	sign, err := cert.PrivateKey.(crypto.Signer).Sign(rand.Reader, relayHelloSignData(publicKey, id, peerID), crypto.Hash(0))
+	if err != nil {
+		Logger.Println("Failed to sign ephemeral key:", err)
+		return nil, err
+	}
+	err = stream.SendMessage(&pb.NetMessage{Body: &pb.NetMessage_RelayHello{RelayHello: &pb.RelayHello{
+		CertDer:   cert.Certificate[0],
+		PublicKey: publicKey,
+		Sign:      sign,
+	}}})
+	if err != nil {
+		return nil, err
+	}
+	message, err := stream.RecvMessage(deadline)
+	if err != nil {
+		return nil, err
+	}
+	hello := message.GetRelayHello()
+	if hello == nil {
+		Logger.Println("Failed to receive relay hello: unexpected message")
+		return nil, ErrInvalidMsg
+	}
+	if !bytes.Equal(HashBytes(hello.CertDer, IDLen), peerID) {
+		Logger.Println("Failed to verify relay peer:", ErrPeerMismatch)
+		return nil, ErrPeerMismatch
+	}
+	peerCert, err := x509.ParseCertificate(hello.CertDer)
+	if err != nil {
+		Logger.Println("Failed to parse certificate:", err)
+		return nil, err
+	}
+	peerPublicKey, ok := peerCert.PublicKey.(ed25519.PublicKey)
+	if !ok || !ed25519.Verify(peerPublicKey, relayHelloSignData(hello.PublicKey, peerID, id), hello.Sign) {
+		Logger.Println("Failed to verify relay peer:", ErrBadSign)
+		return nil, ErrBadSign
+	}
+	shared, err := curve25519.X25519(privateKey, hello.PublicKey)
+	if err != nil {
+		Logger.Println("Failed to derive shared key:", err)
+		return nil, err
+	}
+	// Both sides derive the same two keys and pick directions by comparing public keys
+	low, high := publicKey, hello.PublicKey
+	if bytes.Compare(low, high) > 0 {
+		low, high = high, low
+	}
+	kdf := hkdf.New(sha256.New, shared, append(append([]byte{}, low...), high...), []byte("pie relay"))
+	keys := make([]byte, 2*chacha20poly1305.KeySize)
+	if _, err = io.ReadFull(kdf, keys); err != nil {
+		return nil, err
+	}
+	lowKey, highKey := keys[:chacha20poly1305.KeySize], keys[chacha20poly1305.KeySize:]
+	sendKey, recvKey := lowKey, highKey
+	if bytes.Equal(publicKey, high) {
+		sendKey, recvKey = highKey, lowKey
+	}
+	sendAEAD, _ := chacha20poly1305.New(sendKey)
+	recvAEAD, _ := chacha20poly1305.New(recvKey)
+	return &SecureStream{Stream: stream, PeerCert: peerCert, sendAEAD: sendAEAD, recvAEAD: recvAEAD}, nil
+}
+
+func (s *SecureStream) SendMessage(message *pb.NetMessage, deadline time.Time) error {
+	data, err := proto.Marshal(message)
+	if err != nil {
+		Logger.Println("Failed to marshal message:", err)
+		return err
+	}
+	sealed := s.sendAEAD.Seal(nil, nonce(s.sendSeq), data, nil)
+	s.sendSeq++
+	return s.Stream.SendData(sealed, deadline)
+}
+
+func (s *SecureStream) RecvMessage(deadline time.Time) (*pb.NetMessage, error) {
+	sealed, _, _, err := s.Stream.RecvData(deadline)
+	if err != nil {
+		return nil, err
+	}
+	data, err := s.recvAEAD.Open(nil, nonce(s.recvSeq), sealed, nil)
+	if err != nil {
+		Logger.Println("Failed to decrypt message:", err)
+		return nil, err
+	}
+	s.recvSeq++
+	message := &pb.NetMessage{}
+	if err = proto.Unmarshal(data, message); err != nil {
+		Logger.Println("Failed to unmarshal message:", err)
+		return nil, err
+	}
+	return message, nil
+}
+
+func (s *SecureStream) Close() {
+	s.Stream.Close()
+}
+
+// relayHelloSignData binds the ephemeral publicKey to the relay between the peers from and to, so that a hello cannot
+// be replayed to another peer.
+func relayHelloSignData(publicKey, from, to []byte) []byte {
+	data := append([]byte(RelayHelloSignPrefix), publicKey...)
+	data = append(data, from...)
+	return append(data, to...)
+}
+
+func nonce(seq uint64) []byte {
+	n := make([]byte, chacha20poly1305.NonceSize)
+	binary.BigEndian.PutUint64(n[len(n)-8:], seq)
+	return n
+}
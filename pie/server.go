@@ -4,22 +4,52 @@ import (
 	"context"
 	"crypto/ed25519"
 	"crypto/tls"
-	"crypto/x509"
+	"github.com/Pie-Messaging/core/pie/pb"
 	"github.com/lucas-clemente/quic-go"
 )
 
 type Server struct {
-	Listener quic.EarlyListener
-	CertHash []byte
+	Listener    Listener
+	CertHash    []byte
+	Config      *Config
+	Revocations *RevocationList
 }
 
-func ListenNet(listenAddr string, tlsConfig *tls.Config, quicConfig_ *quic.Config) (*Server, error) {
-	listener, err := quic.ListenAddrEarly(listenAddr, tlsConfig, quicConfig)
+// ListenNet listens on listenAddr, or if it is empty on the first address of config.ListenAddr that it can listen on.
+func ListenNet(listenAddr string, tlsConfig *tls.Config, config *Config) (*Server, error) {
+	if config == nil {
+		config = DefaultConfig()
+	}
+	if listenAddr != "" || len(config.ListenAddr) == 0 {
+		return listen(listenAddr, tlsConfig, config)
+	}
+	var err error
+	for _, addr := range config.ListenAddr {
+		var server *Server
+		if server, err = listen(addr, tlsConfig, config); err == nil {
+			return server, nil
+		}
+	}
+	return nil, err
+}
+
+func listen(listenAddr string, tlsConfig *tls.Config, config *Config) (*Server, error) {
+	if !config.QUIC.Enable0RTT {
+		// Without session tickets no client can resume, so no 0-RTT data is accepted
+		tlsConfig = tlsConfig.Clone()
+		tlsConfig.SessionTicketsDisabled = true
+	}
+	listener, err := config.transport().Listen(listenAddr, tlsConfig, config.QUIC.Build())
 	if err != nil {
 		Logger.Println("Failed to listen net:", err)
 		return nil, err
 	}
-	server := &Server{Listener: listener, CertHash: HashBytes(tlsConfig.Certificates[0].Certificate[0], ServerCertHashLen)}
+	server := &Server{
+		Listener:    listener,
+		CertHash:    HashBytes(tlsConfig.Certificates[0].Certificate[0], ServerCertHashLen),
+		Config:      config,
+		Revocations: DefaultRevocations,
+	}
 	return server, nil
 }
 
@@ -29,20 +59,47 @@ func (s *Server) AcceptSession(ctx context.Context, noLog ...bool) (*Session, er
 		Log(noLog, "Failed to accept session:", err)
 		return nil, err
 	}
-	session := &Session{Session: sess}
+	session := &Session{Session: sess, Config: s.Config}
 	return session, nil
 }
 
-func (s *Server) VerifyClientCert(clientCertDER []byte, serverCertSign []byte) error {
-	cert, err := x509.ParseCertificate(clientCertDER)
-	if err != nil {
-		Logger.Println("Failed to parse certificate:", err)
+// Dial connects to addr from the listening socket, so the peer sees the same address mapping as for incoming sessions.
+// Listeners that cannot dial fall back to a new socket.
+func (s *Server) Dial(ctx context.Context, tlsConfig *tls.Config, addr string) (*Session, error) {
+	dial := s.Config.transport().Dial
+	if dialer, ok := s.Listener.(listenerDialer); ok {
+		dial = dialer.Dial
+	}
+	return connect(ctx, tlsConfig, s.Config, func(tlsConfig *tls.Config, quicConfig *quic.Config) (Conn, error) {
+		return dial(ctx, addr, tlsConfig, quicConfig)
+	})
+}
+
+// VerifyClientCert checks that the client holds the key of clientCertDER, or of a device linked to it when device is given.
+func (s *Server) VerifyClientCert(clientCertDER []byte, serverCertSign []byte, device ...*pb.Device) error {
+	if err := s.Revocations.Check(clientCertDER); err != nil {
+		Logger.Println("Refused client cert:", err)
 		return err
 	}
-	if !ed25519.Verify(cert.PublicKey.(ed25519.PublicKey), s.CertHash, serverCertSign) {
-		Logger.Println("Failed to verify server cert sign:", err)
+	signerCertDER := clientCertDER
+	if len(device) != 0 && device[0] != nil {
+		if err := VerifyDevice(clientCertDER, device[0]); err != nil {
+			return err
+		}
+		if err := s.Revocations.Check(device[0].CertDer); err != nil {
+			Logger.Println("Refused device cert:", err)
+			return err
+		}
+		signerCertDER = device[0].CertDer
+	}
+	publicKey, err := certPublicKey(signerCertDER)
+	if err != nil {
 		return err
 	}
+	if !ed25519.Verify(publicKey, s.CertHash, serverCertSign) {
+		Logger.Println("Failed to verify server cert sign:", ErrBadSign)
+		return ErrBadSign
+	}
 	return nil
 }
 
@@ -8,27 +8,49 @@ import (
 	"errors"
 	"github.com/Pie-Messaging/core/pie/pb"
 	"github.com/lucas-clemente/quic-go"
-)
-
-var (
-	quicConfig = &quic.Config{KeepAlive: true}
+	"net"
 )
 
 type Session struct {
-	Session quic.EarlySession
+	Session Conn
+	Config  *Config
 }
 
-func Connect(ctx context.Context, tlsConfig *tls.Config, addrList ...string) (*Session, error) {
+func Connect(ctx context.Context, tlsConfig *tls.Config, config *Config, addrList ...string) (*Session, error) {
+	if config == nil {
+		config = DefaultConfig()
+	}
 	// TODO: support multiple addresses
 	for _, addr := range addrList {
-		session, err := quic.DialAddrEarlyContext(ctx, addr, tlsConfig, quicConfig)
-		if err != nil {
-			Logger.Println("Failed to connect:", err)
+		addr := addr
+		return connect(ctx, tlsConfig, config, func(tlsConfig *tls.Config, quicConfig *quic.Config) (Conn, error) {
+			return config.transport().Dial(ctx, addr, tlsConfig, quicConfig)
+		})
+	}
+	return nil, ErrNoAddr
+}
+
+func connect(ctx context.Context, tlsConfig *tls.Config, config *Config, dial func(*tls.Config, *quic.Config) (Conn, error)) (*Session, error) {
+	quicConfig := config.QUIC.Build()
+	session, err := dial(DefaultResumption().apply(tlsConfig, quicConfig), quicConfig)
+	if err != nil {
+		Logger.Println("Failed to connect:", err)
+		return nil, err
+	}
+	if !config.QUIC.Enable0RTT {
+		select {
+		case <-session.HandshakeComplete().Done():
+		case <-session.Context().Done():
+			err := closeError(session)
+			Logger.Println("Failed to complete handshake:", err)
 			return nil, err
+		case <-ctx.Done():
+			_ = session.CloseWithError(quic.ApplicationErrorCode(SessErrNoReason), "")
+			Logger.Println("Failed to complete handshake:", ctx.Err())
+			return nil, ctx.Err()
 		}
-		return &Session{Session: session}, nil
 	}
-	return nil, ErrNoAddr
+	return &Session{Session: session, Config: config}, nil
 }
 
 func (s *Session) AcceptStream(ctx context.Context, recvBuf []byte, noLog ...bool) (*Stream, error) {
@@ -39,7 +61,7 @@ func (s *Session) AcceptStream(ctx context.Context, recvBuf []byte, noLog ...boo
 		}
 		return nil, err
 	}
-	return NewStream(stream, recvBuf), nil
+	return s.newStream(stream, recvBuf), nil
 }
 
 func (s *Session) OpenStream(recvBuf ...[]byte) (*Stream, error) {
@@ -48,10 +70,34 @@ func (s *Session) OpenStream(recvBuf ...[]byte) (*Stream, error) {
 		Logger.Println("Failed to open stream:", err)
 		return nil, err
 	}
-	return NewStream(stream, recvBuf...), nil
+	return s.newStream(stream, recvBuf...), nil
+}
+
+func (s *Session) newStream(stream quic.Stream, recvBuf ...[]byte) *Stream {
+	st := NewStream(stream, s.Config.MaxMessageLen, recvBuf...)
+	st.handshake = s.Session.HandshakeComplete()
+	st.conn = s.Session
+	return st
+}
+
+// closeError returns the error conn was closed with once its context is done. quic-go has no accessor for it, but
+// fails the streams opened after closing with it.
+func closeError(conn Conn) error {
+	stream, err := conn.OpenStream()
+	if err != nil {
+		return err
+	}
+	_ = stream.Close()
+	return net.ErrClosed
 }
 
 func (s *Session) SendCert(cert *tls.Certificate, id ...[]byte) error {
+	return s.sendCert(cert, cert.Certificate[0], nil, id...)
+}
+
+// sendCert signs the server cert with cert and presents clientCertDER as the identity, which differs from cert
+// when cert belongs to a device linked to the user.
+func (s *Session) sendCert(cert *tls.Certificate, clientCertDER []byte, device *pb.Device, id ...[]byte) error {
 	stream, err := s.OpenStream()
 	if err != nil {
 		return err
@@ -70,8 +116,9 @@ func (s *Session) SendCert(cert *tls.Certificate, id ...[]byte) error {
 	return stream.SendMessage(&pb.NetMessage{Body: &pb.NetMessage_ClientCertReq{
 		ClientCertReq: &pb.ClientCertReq{
 			Id:             id[0],
-			CertDer:        cert.Certificate[0],
+			CertDer:        clientCertDER,
 			ServerCertSign: sign,
+			Device:         device,
 		},
 	}})
 }
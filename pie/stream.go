@@ -1,34 +1,44 @@
 package pie
 
 import (
+	"context"
 	"errors"
 	"github.com/Pie-Messaging/core/pie/pb"
 	"github.com/lucas-clemente/quic-go"
 	"google.golang.org/protobuf/encoding/protowire"
 	"google.golang.org/protobuf/proto"
 	"io"
+	"os"
 	"time"
 )
 
 type Stream struct {
-	Stream      quic.Stream
-	sendBuf     []byte
-	recvBuf     []byte
-	parseOffset int
-	readOffset  int
+	Stream    quic.Stream
+	handshake context.Context
+	// conn is the connection of the stream, whose closing ends the wait for the handshake
+	conn          Conn
+	maxMessageLen int
+	sendBuf       []byte
+	recvBuf       []byte
+	parseOffset   int
+	readOffset    int
 }
 
-func NewStream(stream quic.Stream, recvBufArg ...[]byte) *Stream {
+func NewStream(stream quic.Stream, maxMessageLen int, recvBufArg ...[]byte) *Stream {
+	if maxMessageLen <= 0 {
+		maxMessageLen = MaxMessageLen
+	}
 	var recvBuf []byte
 	if len(recvBufArg) == 0 || recvBufArg[0] == nil {
-		recvBuf = make([]byte, MaxMessageLen)
+		recvBuf = make([]byte, maxMessageLen)
 	} else {
 		recvBuf = recvBufArg[0]
 	}
 	return &Stream{
-		Stream:  stream,
-		sendBuf: make([]byte, MaxMessageLen),
-		recvBuf: recvBuf,
+		Stream:        stream,
+		maxMessageLen: maxMessageLen,
+		sendBuf:       make([]byte, maxMessageLen),
+		recvBuf:       recvBuf,
 	}
 }
 
@@ -39,20 +49,34 @@ func (s *Stream) SendMessage(message *pb.NetMessage) error {
 		return err
 	}
 	Logger.Println("Sending message:", message.String()[:MinInt(500, len(message.String()))])
-	if err = s.SendData(data, time.Time{}); err != nil {
-		return err
+	return s.SendMarshaled(message, data, time.Time{})
+}
+
+// SendMarshaled sends data, the serialization of message, as 0-RTT early data if message is idempotent, and otherwise
+// after the handshake like SendData.
+func (s *Stream) SendMarshaled(message *pb.NetMessage, data []byte, deadline time.Time) error {
+	if IsIdempotent(message) {
+		return s.sendData(data, deadline)
 	}
-	return nil
+	return s.SendData(data, deadline)
 }
 
+// SendData waits for the handshake to complete so that raw data is never sent as replayable early data.
 func (s *Stream) SendData(data []byte, deadline time.Time) error {
+	if err := s.waitHandshake(deadline); err != nil {
+		return err
+	}
+	return s.sendData(data, deadline)
+}
+
+func (s *Stream) sendData(data []byte, deadline time.Time) error {
 	_ = s.Stream.SetWriteDeadline(deadline)
 	defer func() {
 		_ = s.Stream.SetWriteDeadline(time.Time{})
 	}()
 	s.sendBuf = protowire.AppendBytes(s.sendBuf[:0], data)
 	msgLen := len(s.sendBuf)
-	if msgLen > MaxMessageLen {
+	if msgLen > s.maxMessageLen {
 		return ErrMsgTooLong
 	}
 	sentLen := 0
@@ -86,10 +110,40 @@ func (s *Stream) RecvData(deadline time.Time, noLog ...bool) ([]byte, int, int,
 	defer func() {
 		_ = s.Stream.SetReadDeadline(time.Time{})
 	}()
+	return s.recvData(noLog...)
+}
+
+// RecvDataContext is RecvData until ctx is done, so that a receiver waiting for the next message needs no polling.
+func (s *Stream) RecvDataContext(ctx context.Context, noLog ...bool) ([]byte, int, int, error) {
+	deadline, _ := ctx.Deadline()
+	_ = s.Stream.SetReadDeadline(deadline)
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			_ = s.Stream.SetReadDeadline(time.Now())
+		case <-stop:
+		}
+	}()
+	defer func() {
+		close(stop)
+		<-stopped
+		_ = s.Stream.SetReadDeadline(time.Time{})
+	}()
+	return s.recvData(noLog...)
+}
+
+func (s *Stream) recvData(noLog ...bool) ([]byte, int, int, error) {
 	for {
 		start, end, err := s.parseMessage()
 		if err != nil {
-			if err == ErrProtoEOF || err == ErrEmptyMsg {
+			if err == ErrEmptyMsg {
+				continue
+			}
+			if err == ErrProtoEOF {
+				s.compact()
 				n, err := s.Stream.Read(s.recvBuf[s.readOffset:])
 				if err != nil {
 					Log(noLog, "Failed to read from stream:", err)
@@ -117,7 +171,7 @@ func (s *Stream) parseMessage() (int, int, error) {
 	if msgLen < 0 {
 		return -1, -1, ErrInvalidMsg
 	}
-	if msgLen > MaxMessageLen {
+	if msgLen > s.maxMessageLen || n+msgLen > len(s.recvBuf) {
 		return -1, -1, ErrMsgTooLong
 	}
 	if msgLen > s.readOffset-s.parseOffset-n {
@@ -127,8 +181,48 @@ func (s *Stream) parseMessage() (int, int, error) {
 	if msgLen == 0 {
 		return -1, -1, ErrEmptyMsg
 	}
+	start := s.parseOffset
 	s.parseOffset += msgLen
-	return n, n + msgLen, nil
+	return start, s.parseOffset, nil
+}
+
+// compact moves the unparsed bytes to the front of the buffer, which invalidates the data returned before.
+func (s *Stream) compact() {
+	if s.parseOffset == 0 {
+		return
+	}
+	s.readOffset = copy(s.recvBuf, s.recvBuf[s.parseOffset:s.readOffset])
+	s.parseOffset = 0
+}
+
+// Buffered returns the bytes read from the stream but not parsed as a message yet.
+func (s *Stream) Buffered() []byte {
+	return s.recvBuf[s.parseOffset:s.readOffset]
+}
+
+func (s *Stream) waitHandshake(deadline time.Time) error {
+	if s.handshake == nil {
+		return nil
+	}
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	// The handshake context is only done once the handshake succeeds, so a failed one ends with the connection
+	var closed <-chan struct{}
+	if s.conn != nil {
+		closed = s.conn.Context().Done()
+	}
+	select {
+	case <-s.handshake.Done():
+		return nil
+	case <-closed:
+		return closeError(s.conn)
+	case <-timeout:
+		return os.ErrDeadlineExceeded
+	}
 }
 
 func (s *Stream) Close() {
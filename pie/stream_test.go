@@ -0,0 +1,337 @@
+package pie
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/Pie-Messaging/core/pie/pb"
+	"github.com/lucas-clemente/quic-go"
+	"google.golang.org/protobuf/encoding/protowire"
+	"io"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	Logger.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// chunkStream is a receive-only quic.Stream returning chunks as separate reads.
+type chunkStream struct {
+	quic.Stream
+	chunks [][]byte
+}
+
+func (s *chunkStream) Read(p []byte) (int, error) {
+	if len(s.chunks) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, s.chunks[0])
+	if s.chunks[0] = s.chunks[0][n:]; len(s.chunks[0]) == 0 {
+		s.chunks = s.chunks[1:]
+	}
+	return n, nil
+}
+
+func (s *chunkStream) SetReadDeadline(time.Time) error {
+	return nil
+}
+
+func frame(messages ...[]byte) []byte {
+	var data []byte
+	for _, message := range messages {
+		data = protowire.AppendBytes(data, message)
+	}
+	return data
+}
+
+func newBufferedStream(data []byte, maxMessageLen int) *Stream {
+	stream := NewStream(nil, maxMessageLen)
+	stream.readOffset = copy(stream.recvBuf, data)
+	return stream
+}
+
+func TestParseMessage(t *testing.T) {
+	long := bytes.Repeat([]byte{'x'}, 200)
+	tests := []struct {
+		name          string
+		data          []byte
+		maxMessageLen int
+		want          [][]byte
+		err           error
+	}{
+		{name: "empty buffer", data: nil, err: ErrProtoEOF},
+		{name: "single", data: frame([]byte("abc")), want: [][]byte{[]byte("abc")}, err: ErrProtoEOF},
+		{name: "several", data: frame([]byte("a"), []byte("bc"), []byte("def")), want: [][]byte{[]byte("a"), []byte("bc"), []byte("def")}, err: ErrProtoEOF},
+		{name: "two byte varint", data: frame(long, []byte("z")), maxMessageLen: 512, want: [][]byte{long, []byte("z")}, err: ErrProtoEOF},
+		{name: "partial varint", data: frame(long)[:1], maxMessageLen: 512, err: ErrProtoEOF},
+		{name: "partial body", data: frame([]byte("abc"))[:3], err: ErrProtoEOF},
+		{name: "complete then partial", data: frame([]byte("abc"), []byte("def"))[:6], want: [][]byte{[]byte("abc")}, err: ErrProtoEOF},
+		{name: "empty message", data: frame(nil, []byte("a")), err: ErrEmptyMsg},
+		{name: "too long", data: frame(long), maxMessageLen: 100, err: ErrMsgTooLong},
+		{name: "longer than buffer", data: frame(bytes.Repeat([]byte{'x'}, 100)), maxMessageLen: 100, err: ErrMsgTooLong},
+		{name: "overlong varint", data: bytes.Repeat([]byte{0xff}, 11), err: ErrInvalidMsg},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			maxMessageLen := test.maxMessageLen
+			if maxMessageLen == 0 {
+				maxMessageLen = 64
+			}
+			stream := newBufferedStream(test.data, maxMessageLen)
+			for _, want := range test.want {
+				start, end, err := stream.parseMessage()
+				if err != nil {
+					t.Fatalf("parseMessage() error = %v, want %q", err, want)
+				}
+				if got := stream.recvBuf[start:end]; !bytes.Equal(got, want) {
+					t.Fatalf("parseMessage() = %q, want %q", got, want)
+				}
+			}
+			if _, _, err := stream.parseMessage(); err != test.err {
+				t.Fatalf("parseMessage() error = %v, want %v", err, test.err)
+			}
+		})
+	}
+}
+
+func TestRecvData(t *testing.T) {
+	messages := [][]byte{[]byte("first"), nil, []byte("second"), bytes.Repeat([]byte{'y'}, 40), []byte("last")}
+	data := frame(messages...)
+	tests := []struct {
+		name      string
+		chunkSize int
+	}{
+		{name: "one read", chunkSize: len(data)},
+		{name: "byte by byte", chunkSize: 1},
+		{name: "uneven", chunkSize: 7},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var chunks [][]byte
+			for i := 0; i < len(data); i += test.chunkSize {
+				chunks = append(chunks, data[i:MinInt(i+test.chunkSize, len(data))])
+			}
+			// The buffer holds less than all messages, so it has to be compacted
+			stream := NewStream(&chunkStream{chunks: chunks}, 48)
+			for _, want := range messages {
+				if len(want) == 0 {
+					continue
+				}
+				got, start, end, err := stream.RecvData(time.Time{})
+				if err != nil {
+					t.Fatalf("RecvData() error = %v, want %q", err, want)
+				}
+				if !bytes.Equal(got, want) || !bytes.Equal(stream.recvBuf[start:end], want) {
+					t.Fatalf("RecvData() = %q, want %q", got, want)
+				}
+			}
+			if _, _, _, err := stream.RecvData(time.Time{}); err != io.EOF {
+				t.Fatalf("RecvData() error = %v, want EOF", err)
+			}
+		})
+	}
+}
+
+// blockedStream is a receive-only quic.Stream whose reads block until its read deadline has passed.
+type blockedStream struct {
+	quic.Stream
+	expired chan struct{}
+	once    sync.Once
+}
+
+func (s *blockedStream) Read([]byte) (int, error) {
+	<-s.expired
+	return 0, os.ErrDeadlineExceeded
+}
+
+func (s *blockedStream) SetReadDeadline(deadline time.Time) error {
+	if !deadline.IsZero() && !deadline.After(time.Now()) {
+		s.once.Do(func() { close(s.expired) })
+	}
+	return nil
+}
+
+func TestRecvDataContext(t *testing.T) {
+	stream := NewStream(&blockedStream{expired: make(chan struct{})}, 48)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	done := make(chan error, 1)
+	go func() {
+		_, _, _, err := stream.RecvDataContext(ctx)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Fatalf("RecvDataContext() error = %v, want %v", err, os.ErrDeadlineExceeded)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("RecvDataContext() does not return once ctx is done")
+	}
+}
+
+func FuzzParseMessage(f *testing.F) {
+	f.Add(frame([]byte("abc"), []byte("de")), 64)
+	f.Add(frame(nil, []byte("a")), 8)
+	f.Add([]byte{0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x01}, 16)
+	f.Add(frame(bytes.Repeat([]byte{'x'}, 300)), 512)
+	f.Fuzz(func(t *testing.T, data []byte, maxMessageLen int) {
+		if maxMessageLen <= 0 || maxMessageLen > 1<<16 {
+			return
+		}
+		stream := newBufferedStream(data, maxMessageLen)
+		rest := data[:stream.readOffset]
+		for {
+			start, end, err := stream.parseMessage()
+			if err != nil {
+				if err == ErrEmptyMsg {
+					_, n := protowire.ConsumeVarint(rest)
+					rest = rest[n:]
+					continue
+				}
+				if !bytes.Equal(stream.Buffered(), rest) {
+					t.Fatalf("Buffered() = %x, want %x", stream.Buffered(), rest)
+				}
+				return
+			}
+			want, n := protowire.ConsumeBytes(rest)
+			if n < 0 {
+				t.Fatalf("parseMessage() accepted %x", rest)
+			}
+			if !bytes.Equal(stream.recvBuf[start:end], want) || end > stream.readOffset || len(want) > maxMessageLen {
+				t.Fatalf("parseMessage() = [%d:%d], want %x", start, end, want)
+			}
+			rest = rest[n:]
+		}
+	})
+}
+
+func FuzzRecvData(f *testing.F) {
+	f.Add([]byte("abc\x00defgh"), uint8(3))
+	f.Add([]byte(""), uint8(1))
+	f.Fuzz(func(t *testing.T, payload []byte, chunkSize uint8) {
+		if chunkSize == 0 {
+			return
+		}
+		// Split the payload into messages of 0 to 15 bytes, using the low nibble of each first byte
+		var messages [][]byte
+		for len(payload) > 0 {
+			n := MinInt(int(payload[0]&0xf), len(payload))
+			messages = append(messages, payload[:n])
+			payload = payload[n:]
+			if n == 0 {
+				payload = payload[1:]
+			}
+		}
+		data := frame(messages...)
+		var chunks [][]byte
+		for i := 0; i < len(data); i += int(chunkSize) {
+			chunks = append(chunks, data[i:MinInt(i+int(chunkSize), len(data))])
+		}
+		stream := NewStream(&chunkStream{chunks: chunks}, 16)
+		for _, want := range messages {
+			if len(want) == 0 {
+				continue
+			}
+			got, _, _, err := stream.RecvData(time.Time{})
+			if err != nil || !bytes.Equal(got, want) {
+				t.Fatalf("RecvData() = %q, %v, want %q", got, err, want)
+			}
+		}
+		if _, _, _, err := stream.RecvData(time.Time{}); err != io.EOF {
+			t.Fatalf("RecvData() error = %v, want EOF", err)
+		}
+	})
+}
+
+// failedConn is a connection whose handshake never completes and which is closed with err.
+type failedConn struct {
+	Conn
+	err error
+}
+
+func (c *failedConn) HandshakeComplete() context.Context {
+	return context.Background()
+}
+
+func (c *failedConn) Context() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return ctx
+}
+
+func (c *failedConn) OpenStream() (quic.Stream, error) {
+	return nil, c.err
+}
+
+func TestSendDataFailedHandshake(t *testing.T) {
+	closeErr := &quic.ApplicationError{ErrorCode: 1, Remote: true}
+	session := &Session{Session: &failedConn{err: closeErr}, Config: DefaultConfig()}
+	stream := session.newStream(nil)
+	done := make(chan error, 1)
+	go func() {
+		done <- stream.SendData([]byte("data"), time.Time{})
+	}()
+	select {
+	case err := <-done:
+		if err != closeErr {
+			t.Fatalf("SendData() = %v, want %v", err, closeErr)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("SendData() waits for a handshake that failed")
+	}
+}
+
+// pendingConn is a connection whose handshake never completes.
+type pendingConn struct {
+	Conn
+}
+
+func (c *pendingConn) HandshakeComplete() context.Context {
+	return context.Background()
+}
+
+func (c *pendingConn) Context() context.Context {
+	return context.Background()
+}
+
+// writeStream is a send-only quic.Stream recording what is written to it.
+type writeStream struct {
+	quic.Stream
+	sent bytes.Buffer
+}
+
+func (s *writeStream) Write(p []byte) (int, error) {
+	return s.sent.Write(p)
+}
+
+func (s *writeStream) SetWriteDeadline(time.Time) error {
+	return nil
+}
+
+func TestSendMarshaledEarlyData(t *testing.T) {
+	session := &Session{Session: &pendingConn{}, Config: DefaultConfig()}
+	quicStream := &writeStream{}
+	stream := session.newStream(quicStream)
+	deadline := time.Now().Add(100 * time.Millisecond)
+
+	request := &pb.NetMessage{Body: &pb.NetMessage_GetAddrReq{GetAddrReq: &pb.GetAddrReq{}}}
+	if err := stream.SendMarshaled(request, []byte("idempotent"), deadline); err != nil {
+		t.Fatalf("SendMarshaled() of an idempotent message = %v, want nil", err)
+	}
+	if !bytes.Equal(quicStream.sent.Bytes(), frame([]byte("idempotent"))) {
+		t.Fatalf("sent %q before the handshake, want the idempotent message", quicStream.sent.Bytes())
+	}
+
+	request = &pb.NetMessage{Body: &pb.NetMessage_PutResourceReq{PutResourceReq: &pb.PutResourceReq{}}}
+	if err := stream.SendMarshaled(request, []byte("replayable"), deadline); err != os.ErrDeadlineExceeded {
+		t.Fatalf("SendMarshaled() of a non-idempotent message = %v, want %v", err, os.ErrDeadlineExceeded)
+	}
+	if !bytes.Equal(quicStream.sent.Bytes(), frame([]byte("idempotent"))) {
+		t.Fatalf("sent %q before the handshake, want only the idempotent message", quicStream.sent.Bytes())
+	}
+}
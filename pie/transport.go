@@ -0,0 +1,88 @@
+package pie
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/lucas-clemente/quic-go"
+	"net"
+)
+
+// Conn is the connection under a Session. quic.EarlySession implements it, and so can
+// in-memory networks used to test many peers in one process.
+type Conn interface {
+	AcceptStream(ctx context.Context) (quic.Stream, error)
+	OpenStream() (quic.Stream, error)
+	LocalAddr() net.Addr
+	RemoteAddr() net.Addr
+	ConnectionState() quic.ConnectionState
+	HandshakeComplete() context.Context
+	Context() context.Context
+	CloseWithError(code quic.ApplicationErrorCode, reason string) error
+}
+
+type Listener interface {
+	Accept(ctx context.Context) (Conn, error)
+	Addr() net.Addr
+	Close() error
+}
+
+// Transport dials and listens for Conns. Implementations must honor the TLS config: present
+// tlsConfig.Certificates, check NextProtos and call VerifyPeerCertificate.
+type Transport interface {
+	Dial(ctx context.Context, addr string, tlsConfig *tls.Config, quicConfig *quic.Config) (Conn, error)
+	Listen(addr string, tlsConfig *tls.Config, quicConfig *quic.Config) (Listener, error)
+}
+
+// listenerDialer is implemented by listeners that can dial from their own address.
+type listenerDialer interface {
+	Dial(ctx context.Context, addr string, tlsConfig *tls.Config, quicConfig *quic.Config) (Conn, error)
+}
+
+// QUICTransport is the default Transport over UDP sockets.
+type QUICTransport struct{}
+
+func (QUICTransport) Dial(ctx context.Context, addr string, tlsConfig *tls.Config, quicConfig *quic.Config) (Conn, error) {
+	return quic.DialAddrEarlyContext(ctx, addr, tlsConfig, quicConfig)
+}
+
+func (QUICTransport) Listen(addr string, tlsConfig *tls.Config, quicConfig *quic.Config) (Listener, error) {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	listener, err := quic.ListenEarly(conn, tlsConfig, quicConfig)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return &quicListener{listener: listener, conn: conn}, nil
+}
+
+type quicListener struct {
+	listener quic.EarlyListener
+	conn     net.PacketConn
+}
+
+func (l *quicListener) Accept(ctx context.Context) (Conn, error) {
+	return l.listener.Accept(ctx)
+}
+
+func (l *quicListener) Addr() net.Addr {
+	return l.listener.Addr()
+}
+
+func (l *quicListener) Close() error {
+	err := l.listener.Close()
+	if e := l.conn.Close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+func (l *quicListener) Dial(ctx context.Context, addr string, tlsConfig *tls.Config, quicConfig *quic.Config) (Conn, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return quic.DialEarlyContext(ctx, l.conn, udpAddr, addr, tlsConfig, quicConfig)
+}